@@ -17,6 +17,8 @@ CONFIG_FILE = 'catmgr.json'
 SERVER_URL = 'http://localhost:10777/'
 DEFAULT_USER = None
 DEFAULT_PASSWORD = None
+TOKEN = None
+VERIFY = True
 
 if os.path.exists(CONFIG_FILE):
     with open(CONFIG_FILE, 'r') as fp:
@@ -28,6 +30,11 @@ if os.path.exists(CONFIG_FILE):
         DEFAULT_USER = config['user']
     if 'password' in config:
         DEFAULT_PASSWORD = config['password']
+    if 'token' in config:
+        TOKEN = config['token']
+    # `false`, or path to a CA bundle, e.g. a self-signed certificate
+    if 'verify' in config:
+        VERIFY = config['verify']
 
 
 JSONMap = Mapping[str, Any]
@@ -36,10 +43,27 @@ def invoke(route: str, payload: JSONMap) -> JSONMap:
     if VERBOSE:
         print(f'→ "{route}", Payload: {payload}')
 
-    r = requests.post(os.path.join(SERVER_URL, route), json=payload)
+    headers = {}
+    if TOKEN is not None:
+        headers['Authorization'] = f'Bearer {TOKEN}'
+
+    r = requests.post(os.path.join(SERVER_URL, route), json=payload, headers=headers, verify=VERIFY)
     r.raise_for_status()
     return r.json()
 
+def file_format(name: str) -> str:
+    if name.endswith('.ndjson') or name.endswith('.jsonl'):
+        return 'ndjson'
+    if name.endswith('.json'):
+        return 'json'
+    return 'csv'
+
+def print_next_cursor(resp: JSONMap) -> None:
+    if 'total' in resp:
+        print(f'{resp["total"]} in total')
+    if resp.get('next_cursor'):
+        print(f'Next page: --cursor {resp["next_cursor"]}')
+
 def print_error(resp: JSONMap) -> None:
     print(f'Status: {resp["status"]}')
     print(f'Reason: {resp["error"]}')
@@ -104,9 +128,10 @@ def print_record(record: JSONMap) -> None:
         print(f'(failed to retrieve book #{book_id})')
 
 
-user_prompt = click.option('-u', '--user', type=str, default=DEFAULT_USER, show_default='set in "catmgr.json"', prompt=True,
+# No prompts if a session token is set in "catmgr.json".
+user_prompt = click.option('-u', '--user', type=str, default=DEFAULT_USER, show_default='set in "catmgr.json"', prompt=TOKEN is None,
     help='Account username.')
-password_prompt = click.option('-p', '--password', type=str, default=DEFAULT_PASSWORD, show_default='set in "catmgr.json"', prompt=True, hide_input=True,
+password_prompt = click.option('-p', '--password', type=str, default=DEFAULT_PASSWORD, show_default='set in "catmgr.json"', prompt=TOKEN is None, hide_input=True,
     help='Account password.')
 
 @click.group()
@@ -116,6 +141,117 @@ def cli(verbose: bool) -> None:
     global VERBOSE
     VERBOSE = verbose
 
+@cli.command(short_help='Start a session.')
+@click.option('-u', '--user', type=str, default=DEFAULT_USER, show_default='set in "catmgr.json"', prompt=True,
+    help='Account username.')
+@click.option('-p', '--password', type=str, default=DEFAULT_PASSWORD, show_default='set in "catmgr.json"', prompt=True, hide_input=True,
+    help='Account password.')
+def login(**kwargs) -> None:
+    resp = invoke('login', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'Token:\t{resp["token"]}')
+        print(f'Expire:\t{resp["expires_at"]}')
+        print('Put the token as "token" in "catmgr.json" to use the session.')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='End the current session.')
+@click.option('--all', is_flag=True,
+    help='End all sessions of the user.')
+def logout(**kwargs) -> None:
+    resp = invoke('logout', kwargs)
+
+    if resp['status'] == 'ok':
+        print('Logged out.')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Delete a user.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+def deluser(**kwargs) -> None:
+    resp = invoke('deluser', kwargs)
+
+    if resp['status'] != 'ok':
+        print_error(resp)
+    elif resp['anonymized']:
+        print(f'User #{resp["user_id"]} has borrow history, anonymized instead.')
+    else:
+        print(f'User deleted: #{resp["user_id"]}')
+
+@cli.command(short_help='Deactivate a user.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+def deactivate(**kwargs) -> None:
+    resp = invoke('deactivate', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'User deactivated: #{resp["user_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Reactivate a user.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+def reactivate(**kwargs) -> None:
+    resp = invoke('reactivate', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'User reactivated: #{resp["user_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Set expiry date of a user.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+@click.argument('expires_at', type=str, default='')
+def expire(**kwargs) -> None:
+    """Set expiry date (YYYY-MM-DD) of TARGET. Clear it if EXPIRES_AT is omitted."""
+    resp = invoke('expire', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'Expiry date set: #{resp["user_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Change type of a user.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+@click.argument('new_user_type', type=str)
+def settype(**kwargs) -> None:
+    """Change type of TARGET to NEW_USER_TYPE."""
+    resp = invoke('settype', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'User type changed: #{resp["user_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Change or reset password.')
+@click.option('-u', '--user', type=str, default=DEFAULT_USER, show_default='set in "catmgr.json"', prompt=True,
+    help='Account username.')
+@click.option('-p', '--password', type=str, default=DEFAULT_PASSWORD, show_default='set in "catmgr.json"', prompt=True, hide_input=True,
+    help='Account password (the old one, if changing your own password).')
+@click.option('--new-password', prompt=True, hide_input=True, confirmation_prompt=True, type=str,
+    help='New password.')
+@click.option('-t', '--target', type=str,
+    help='Reset password of another user (requires "user.manage" permission).')
+@click.option('--must-change', is_flag=True,
+    help='Require the target user to change the password on next login.')
+def passwd(**kwargs) -> None:
+    resp = invoke('passwd', kwargs)
+
+    if resp['status'] == 'ok':
+        print('Password changed.')
+    else:
+        print_error(resp)
+
 @cli.command(short_help='Add a new book.')
 @user_prompt
 @password_prompt
@@ -151,6 +287,60 @@ def update(**kwargs) -> None:
     else:
         print_error(resp)
 
+@cli.command(name='import', short_help='Import books from CSV or JSON Lines.')
+@user_prompt
+@password_prompt
+@click.argument('file', type=click.File('r'))
+@click.option('-f', '--format', type=click.Choice(['csv', 'ndjson', 'json']),
+    help='Format of the file.  [default: by file extension]')
+@click.option('--on-conflict', type=click.Choice(['skip', 'update']), default='skip', show_default=True,
+    help='Whether to skip or update books with existing ISBNs.')
+@click.option('--dry-run', is_flag=True,
+    help='Check and count rows without importing.')
+def import_(**kwargs) -> None:
+    file = kwargs.pop('file')
+    if kwargs['format'] is None:
+        kwargs['format'] = file_format(file.name)
+    kwargs['data'] = file.read()
+    resp = invoke('import', kwargs)
+
+    for error in resp.get('errors', []):
+        print(f'{file.name}:{error["line"]}: {error["error"]}')
+    if resp['status'] != 'ok':
+        print_error(resp)
+        return
+
+    verb = 'Would import' if resp['dry_run'] else 'Imported'
+    print(f'{verb}: {resp["created"]} created, {resp["updated"]} updated, {resp["skipped"]} skipped')
+
+@cli.command(short_help='Export books to CSV, JSON Lines or JSON.')
+@click.argument('file', type=click.File('wb'), default='-')
+@click.option('-f', '--format', type=click.Choice(['csv', 'ndjson', 'json']),
+    help='Format of the file.  [default: by file extension]')
+@click.option('-s', '--section', type=click.Choice(['title', 'author', 'fulltext', 'query']),
+    help='Export only books found in this section.')
+@click.option('-k', '--keyword', type=str, default='',
+    help='Keyword to be searched in the section.')
+def export(**kwargs) -> None:
+    file = kwargs.pop('file')
+    if kwargs['format'] is None:
+        kwargs['format'] = file_format(file.name)
+
+    headers = {}
+    if TOKEN is not None:
+        headers['Authorization'] = f'Bearer {TOKEN}'
+    with requests.post(os.path.join(SERVER_URL, 'export'), json=kwargs, headers=headers, verify=VERIFY, stream=True) as r:
+        r.raise_for_status()
+        # Errors are sent as JSON instead of the export
+        chunks = r.iter_content(chunk_size=65536)
+        first = next(chunks, b'')
+        if first.startswith(b'{"status"'):
+            print_error(json.loads(first + b''.join(chunks)))
+            return
+        file.write(first)
+        for chunk in chunks:
+            file.write(chunk)
+
 @cli.command(short_help='Add a new user.')
 @user_prompt
 @password_prompt
@@ -169,8 +359,14 @@ def adduser(**kwargs) -> None:
         print_error(resp)
 
 @cli.command(short_help='Search for books.')
-@click.option('-s', '--section', type=click.Choice(['book_id', 'isbn', 'title', 'author']), required=True,
+@click.option('-s', '--section', type=click.Choice(['book_id', 'isbn', 'title', 'author', 'fulltext', 'query']), required=True,
     help='Section to be searched.')
+@click.option('--sort', type=str,
+    help='Sort key, prefixed with "-" for descending order.')
+@click.option('--cursor', type=str,
+    help='Cursor of the page to be shown, from a previous page.')
+@click.option('--page-size', type=click.IntRange(min=1, max=1000),
+    help='Number of results per page.')
 @click.argument('keyword', type=str)
 def show(**kwargs) -> None:
     resp = invoke('show', kwargs)
@@ -183,6 +379,7 @@ def show(**kwargs) -> None:
     for book in results:
         print_book(book)
     print(f'\n{len(results)} result(s)')
+    print_next_cursor(resp)
 
 @cli.command(name='list', short_help='List borrow history.')
 @user_prompt
@@ -192,6 +389,10 @@ def show(**kwargs) -> None:
     help='Filter condition.')
 @click.option('-l', '--limit', type=click.IntRange(min=0), default=100, show_default=True,
     help='Maximum number of records to be returned by the server.')
+@click.option('--sort', type=click.Choice(['record_id', '-record_id', 'borrow_date', '-borrow_date', 'deadline', '-deadline']),
+    help='Sort key.')
+@click.option('--cursor', type=str,
+    help='Cursor of the page to be shown, from a previous page.')
 def list_(**kwargs) -> None:
     resp = invoke('list', kwargs)
 
@@ -203,11 +404,16 @@ def list_(**kwargs) -> None:
     for record in results:
         print_record(record)
     print(f'\n{len(results)} result(s)')
+    print_next_cursor(resp)
 
 @cli.command(short_help='Borrow a book.')
 @user_prompt
 @password_prompt
-@click.argument('book_id', type=int)
+@click.argument('book_id', type=int, required=False)
+@click.option('-b', '--barcode', type=str,
+    help='Borrow the copy with this barcode instead.')
+@click.option('--branch', 'branch_id', type=int,
+    help='Borrow a copy kept at this branch.')
 def borrow(**kwargs) -> None:
     resp = invoke('borrow', kwargs)
 
@@ -231,7 +437,11 @@ def extend(**kwargs) -> None:
 @cli.command(name='return', short_help='Return a book.')
 @user_prompt
 @password_prompt
-@click.argument('record_id', type=int)
+@click.argument('record_id', type=int, required=False)
+@click.option('-b', '--barcode', type=str,
+    help='Return the copy with this barcode instead.')
+@click.option('--branch', 'branch_id', type=int,
+    help='Branch where the copy is returned.')
 def return_(**kwargs) -> None:
     resp = invoke('return', kwargs)
 
@@ -240,6 +450,84 @@ def return_(**kwargs) -> None:
     else:
         print_error(resp)
 
+@cli.command(short_help='Place a hold on a book.')
+@user_prompt
+@password_prompt
+@click.argument('book_id', type=int)
+def hold(**kwargs) -> None:
+    resp = invoke('hold', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'Success! Hold ID: #{resp["hold_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='List holds.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str, required=False)
+def holds(**kwargs) -> None:
+    resp = invoke('hold/list', kwargs)
+
+    if resp['status'] != 'ok':
+        print_error(resp)
+        return
+
+    results = resp['results']
+    for h in results:
+        if h['status'] == 'ready':
+            state = f'ready, pick up before {h["expires_at"]}'
+        else:
+            state = f'waiting, position {h["position"]}'
+        print(f'#{h["hold_id"]}\tBook #{h["book_id"]}\t{state}')
+    print(f'\n{len(results)} result(s)')
+
+@cli.command(short_help='Cancel a hold.')
+@user_prompt
+@password_prompt
+@click.argument('hold_id', type=int)
+def unhold(**kwargs) -> None:
+    resp = invoke('hold/cancel', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'Hold cancelled: #{resp["hold_id"]}')
+    else:
+        print_error(resp)
+
+@cli.command(short_help='Show fines and payments.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str, required=False)
+def fines(**kwargs) -> None:
+    resp = invoke('fine/balance', kwargs)
+
+    if resp['status'] != 'ok':
+        print_error(resp)
+        return
+
+    for f in resp['entries']:
+        record = f' (record #{f["record_id"]})' if f['record_id'] else ''
+        print(f'{parse_date(f["created_at"])}\t{f["kind"]}\t{f["amount"]}{record}\t{f["note"]}')
+    print(textwrap.dedent(f'''
+        Posted:\t{resp["posted"]}
+        Accruing:\t{resp["accruing"]}
+        Balance:\t{resp["balance"]}'''))
+
+@cli.command(short_help='Record a fine payment.')
+@user_prompt
+@password_prompt
+@click.argument('target', type=str)
+@click.argument('amount', type=click.IntRange(min=1))
+@click.option('--note', type=str, default='',
+    help='Note of the payment.')
+def pay(**kwargs) -> None:
+    resp = invoke('fine/pay', kwargs)
+
+    if resp['status'] == 'ok':
+        print(f'Payment recorded: #{resp["fine_id"]}')
+    else:
+        print_error(resp)
+
 
 if __name__ == '__main__':
     cli()
@@ -4,8 +4,6 @@ import "fmt"
 import "time"
 import "errors"
 import "strings"
-import "database/sql"
-import "crypto/sha1"
 
 var (
 	day   = time.Hour * 24
@@ -17,67 +15,152 @@ var (
 var ErrInvalidUser = errors.New("invalid username/user ID")
 var ErrInvalidPassword = errors.New("invalid password")
 var ErrPermissionDenied = errors.New("permission denied")
-
-// `AuthUser` check `login` information against table User
-// in database `db`, which stores the sha1 hashes of passwords.
-// Requested permissions `req` are encapsulated in Permission struct.
-// Auth success if no error returned.
+var ErrMustChangePassword = errors.New("password must be changed before use")
+var ErrEmptyPassword = errors.New("password cannot be empty")
+var ErrInactiveUser = errors.New("user is deactivated")
+var ErrExpiredUser = errors.New("user account has expired")
+
+// `AuthUser` check `login` information against users
+// in store `db`, which stores the password hashes (see password.go).
+// Requested permissions `req` must all be granted to the user's type.
+// Auth success if no error returned, and the authenticated user is
+// returned. Legacy SHA-1 tokens are replaced with new hashes on success.
 //
-// May return `ErrInvalidUser`, `ErrInvalidPassword` or
-// `ErrPermissionDenied`.
-func AuthUser(db *sql.DB, user interface{}, password string, req Permission) error {
-	hash_bytes := sha1.Sum([]byte(password))
-	hash := fmt.Sprintf("%x", hash_bytes)
-
-	var token string
-	var perm Permission
-	query := `SELECT token, can_update, can_adduser, can_borrow, can_inspect
-		FROM User JOIN UserType USING (type_id)
-		WHERE `
-
-	var row *sql.Row
-	switch v := user.(type) {
-	case int:
-		row = db.QueryRow(query+"user_id = ?", v)
-	case float64:
-		user_id := int(v)
-		row = db.QueryRow(query+"user_id = ?", user_id)
-	case string:
-		row = db.QueryRow(query+"name = ?", v)
-	default:
-		return ErrInvalidUser
+// May return `ErrInvalidUser`, `ErrInvalidPassword`,
+// `ErrLegacyPassword`, `ErrAccountLocked`, `ErrInactiveUser`,
+// `ErrExpiredUser`, `ErrMustChangePassword` or `ErrPermissionDenied`.
+func AuthUser(db Store, user interface{}, password string, req PermissionSet) (User, error) {
+	return AuthUserFrom(db, "", user, password, req)
+}
+
+// `AuthUserFrom` is `AuthUser` for requests from remote address `addr`,
+// whose failed attempts are also counted (see lockout.go).
+// Users whose passwords were reset with `must_change` set get
+// `ErrMustChangePassword` until they call `ChangePassword`.
+func AuthUserFrom(db Store, addr string, user interface{}, password string, req PermissionSet) (User, error) {
+	u, err := authPassword(db, addr, user, password)
+	if err != nil {
+		return User{}, err
+	}
+
+	if u.MustChange {
+		return User{}, ErrMustChangePassword
+	}
+
+	if !u.Perm.Contains(req) {
+		return User{}, ErrPermissionDenied
 	}
 
-	err := row.Scan(&token, &perm.Update, &perm.AddUser, &perm.Borrow, &perm.Inspect)
-	if err == sql.ErrNoRows {
-		return ErrInvalidUser
+	return u, nil
+}
+
+// `authPassword` checks the password of `user` only.
+func authPassword(db Store, addr string, user interface{}, password string) (User, error) {
+	now := time.Now()
+	var subjects []string
+	if addr != "" {
+		subjects = append(subjects, addrSubject(addr))
+	}
+
+	u, err := lookupUser(db, user)
+	if err == nil {
+		subjects = append(subjects, userSubject(u.UserID))
+	}
+	lock_err := checkLocked(db, subjects, now)
+	if lock_err != nil {
+		return User{}, lock_err
+	}
+	if err == ErrInvalidUser {
+		lock_err = recordFailure(db, subjects, now)
+		if lock_err != nil {
+			return User{}, lock_err
+		}
+		return User{}, err
 	}
 	if err != nil {
-		return err
+		return User{}, err
 	}
 
-	if hash != token {
-		return ErrInvalidPassword
+	ok, err := CheckPassword(u.Token, password)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		err = recordFailure(db, subjects, now)
+		if err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidPassword
 	}
 
-	if req.mask()&perm.mask() != req.mask() {
-		return ErrPermissionDenied
+	err = checkUserStatus(u, now)
+	if err != nil {
+		return User{}, err
 	}
 
+	f, err := db.AuthFailure(userSubject(u.UserID))
+	if err != nil {
+		return User{}, err
+	}
+	if f.Failures > 0 {
+		err = db.DeleteAuthFailure(f.Subject)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	if isLegacyToken(u.Token) && !LegacyPasswordCutoff.IsZero() &&
+		now.After(LegacyPasswordCutoff) {
+		return User{}, ErrLegacyPassword
+	}
+
+	if NeedsRehash(u.Token) {
+		token, err := HashPassword(password)
+		if err != nil {
+			return User{}, err
+		}
+		err = db.SetUserToken(u.UserID, token, u.MustChange)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	return u, nil
+}
+
+// `checkUserStatus` returns `ErrInactiveUser` or `ErrExpiredUser` if
+// `u` cannot log in at `now`.
+func checkUserStatus(u User, now time.Time) error {
+	if !u.Active {
+		return ErrInactiveUser
+	}
+	if !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt) {
+		return ErrExpiredUser
+	}
 	return nil
 }
 
-func GetUserID(db *sql.DB, name string) (int, error) {
-	var user_id int
-	query := "SELECT user_id FROM User WHERE name=?"
-	err := db.QueryRow(query, name).Scan(&user_id)
-	if err == sql.ErrNoRows {
-		return -1, ErrInvalidUser
+// `lookupUser` finds user by ID or name.
+func lookupUser(db Store, user interface{}) (User, error) {
+	switch v := user.(type) {
+	case int:
+		return db.UserByID(v)
+	case float64:
+		user_id := int(v)
+		return db.UserByID(user_id)
+	case string:
+		return db.UserByName(v)
+	default:
+		return User{}, ErrInvalidUser
 	}
+}
+
+func GetUserID(db Store, name string) (int, error) {
+	u, err := db.UserByName(name)
 	if err != nil {
 		return -1, err
 	}
-	return user_id, nil
+	return u.UserID, nil
 }
 
 var ErrInvalidUserType = errors.New("invalid user type name/ID")
@@ -87,13 +170,88 @@ var ErrInvalidUserType = errors.New("invalid user type name/ID")
 //
 // Returns `ErrInvalidUserType` when `type_name` is not found
 // in table UserType.
-func GetUserTypeID(db *sql.DB, type_name string) (int, error) {
-	var type_id int
-	query := "SELECT type_id FROM UserType WHERE type_name=?"
-	err := db.QueryRow(query, type_name).Scan(&type_id)
-	if err == sql.ErrNoRows {
-		return -1, ErrInvalidUserType
+func GetUserTypeID(db Store, type_name string) (int, error) {
+	t, err := db.UserTypeByName(type_name)
+	if err != nil {
+		return -1, err
+	}
+
+	return t.TypeID, nil
+}
+
+var ErrEmptyTypeName = errors.New("user type name cannot be empty")
+var ErrDuplicateUserType = errors.New("user type name already exists")
+var ErrUserTypeInUse = errors.New("user type is still in use")
+var ErrLastAdminType = errors.New("cannot remove the last user type with usertype.manage permission")
+var ErrPrivilegeEscalation = errors.New("cannot grant permissions beyond your own")
+
+// `CheckGrant` returns `ErrPrivilegeEscalation` unless `admin` has all
+// permissions in `perm`. Administrators may only create users of, or
+// change users to, types that are no more powerful than their own.
+// The same holds for users they manage, user types they create,
+// modify or delete, and API keys they create.
+func CheckGrant(admin User, perm PermissionSet) error {
+	if !admin.Perm.Contains(perm) {
+		return ErrPrivilegeEscalation
+	}
+	return nil
+}
+
+// `CheckTypeGrant` is `CheckGrant` with permissions of user type
+// `type_id`.
+//
+// May return `ErrInvalidUserType` or `ErrPrivilegeEscalation`.
+func CheckTypeGrant(db Store, admin User, type_id int) error {
+	t, err := db.UserTypeByID(type_id)
+	if err != nil {
+		return err
+	}
+	return CheckGrant(admin, t.Perm)
+}
+
+// `CheckUserGrant` is `CheckGrant` with permissions of user `user_id`.
+//
+// May return `ErrInvalidUser` or `ErrPrivilegeEscalation`.
+func CheckUserGrant(db Store, admin User, user_id int) error {
+	t, err := db.UserByID(user_id)
+	if err != nil {
+		return err
+	}
+	return CheckGrant(admin, t.Perm)
+}
+
+// `ListUserTypes` returns all user types.
+func ListUserTypes(db Store) ([]UserType, error) {
+	return db.ListUserTypes()
+}
+
+// `NewUserType` creates user type `t`. `t.TypeID` is ignored.
+//
+// May return `ErrEmptyTypeName`, `ErrUnknownPermission` or
+// `ErrDuplicateUserType`.
+func NewUserType(db Store, t UserType) (int, error) {
+	if t.TypeName == "" {
+		return -1, ErrEmptyTypeName
 	}
+	err := t.Perm.Validate()
+	if err != nil {
+		return -1, err
+	}
+	t.Perm = NewPermissionSet(t.Perm...)
+
+	type_id := -1
+	err = db.Atomic(func(db Store) error {
+		_, err := db.UserTypeByName(t.TypeName)
+		if err == nil {
+			return ErrDuplicateUserType
+		}
+		if err != ErrInvalidUserType {
+			return err
+		}
+
+		type_id, err = db.InsertUserType(t)
+		return err
+	})
 	if err != nil {
 		return -1, err
 	}
@@ -101,79 +259,312 @@ func GetUserTypeID(db *sql.DB, type_name string) (int, error) {
 	return type_id, nil
 }
 
-// `AddUser` simply insert a new user record into User table.
+// `UpdateUserType` changes name and permissions of user type
+// `t.TypeID`. The permissions take effect for all users of the type
+// immediately.
+//
+// May return `ErrInvalidUserType`, `ErrEmptyTypeName`,
+// `ErrUnknownPermission`, `ErrDuplicateUserType` or `ErrLastAdminType`.
+func UpdateUserType(db Store, t UserType) error {
+	if t.TypeName == "" {
+		return ErrEmptyTypeName
+	}
+	err := t.Perm.Validate()
+	if err != nil {
+		return err
+	}
+	t.Perm = NewPermissionSet(t.Perm...)
+
+	return db.Atomic(func(db Store) error {
+		old, err := db.UserTypeByID(t.TypeID)
+		if err != nil {
+			return err
+		}
+
+		e, err := db.UserTypeByName(t.TypeName)
+		if err == nil && e.TypeID != t.TypeID {
+			return ErrDuplicateUserType
+		}
+		if err != nil && err != ErrInvalidUserType {
+			return err
+		}
+
+		if old.Perm.Has(PermUserTypeManage) && !t.Perm.Has(PermUserTypeManage) {
+			err = checkLastAdminType(db, t.TypeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return db.UpdateUserType(t)
+	})
+}
+
+// `DeleteUserType` removes user type `type_id`, which must have no
+// users (including anonymized ones, see `DeleteUser`).
+//
+// May return `ErrInvalidUserType`, `ErrUserTypeInUse` or
+// `ErrLastAdminType`.
+func DeleteUserType(db Store, type_id int) error {
+	return db.Atomic(func(db Store) error {
+		t, err := db.UserTypeByID(type_id)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := db.CountUsersOfType(type_id)
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			return ErrUserTypeInUse
+		}
+
+		if t.Perm.Has(PermUserTypeManage) {
+			err = checkLastAdminType(db, type_id)
+			if err != nil {
+				return err
+			}
+		}
+
+		return db.DeleteUserType(type_id)
+	})
+}
+
+// `checkLastAdminType` returns `ErrLastAdminType` if no user type
+// other than `type_id` has `PermUserTypeManage`, so that there is
+// always a way to grant permissions.
+func checkLastAdminType(db Store, type_id int) error {
+	list, err := db.ListUserTypes()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range list {
+		if t.TypeID != type_id && t.Perm.Has(PermUserTypeManage) {
+			return nil
+		}
+	}
+	return ErrLastAdminType
+}
+
+// `AddUser` simply insert a new user record into User table,
+// with password hashed by `HashPassword`.
 //
 // Returns the ID of newly added user.
-func AddUser(db *sql.DB, type_id int, username string, password string) (int, error) {
-	token := fmt.Sprintf("%x", sha1.Sum([]byte(password)))
-	result, err := db.Exec(
-		"INSERT INTO User (type_id, name, token) VALUES (?, ?, ?)",
-		type_id, username, token,
-	)
+func AddUser(db Store, type_id int, username string, password string) (int, error) {
+	token, err := HashPassword(password)
 	if err != nil {
 		return -1, err
 	}
+	return db.InsertUser(type_id, username, token)
+}
+
+// `ChangePassword` replaces the password of `user` with `new_password`
+// after checking `old_password`, which works even if the user must
+// change the password. All sessions of the user are revoked.
+//
+// May return `ErrEmptyPassword` and errors from `AuthUserFrom` except
+// `ErrMustChangePassword` and `ErrPermissionDenied`.
+func ChangePassword(db Store, addr string, user interface{}, old_password string, new_password string) error {
+	if new_password == "" {
+		return ErrEmptyPassword
+	}
 
-	user_id, err := result.LastInsertId()
+	u, err := authPassword(db, addr, user, old_password)
 	if err != nil {
-		return -1, err
+		return err
 	}
 
-	return int(user_id), nil
+	return setPassword(db, u.UserID, new_password, false)
 }
 
-var selectBook = `
-SELECT
-	book_id,
-	COALESCE(title, '(no title)'),
-	COALESCE(author, '(no author)'),
-	COALESCE(isbn, '(no isbn)'),
-	available_count,
-	COALESCE(description, '(no description)'),
-	COALESCE(comment, '(no comment)')
-FROM Book
-WHERE `
+// `ResetPassword` sets the password of `user_id` without checking the
+// old one, and is meant for administrators. If `must_change` is set,
+// the user has to change it with `ChangePassword` before logging in.
+// All sessions of the user are revoked.
+//
+// May return `ErrEmptyPassword` or `ErrInvalidUser`.
+func ResetPassword(db Store, user_id int, new_password string, must_change bool) error {
+	if new_password == "" {
+		return ErrEmptyPassword
+	}
 
-var ErrBookNotFound = errors.New("book not found")
+	_, err := db.UserByID(user_id)
+	if err != nil {
+		return err
+	}
+
+	return setPassword(db, user_id, new_password, must_change)
+}
 
-func scanBook(row RowScanner) (Book, error) {
-	var book Book
-	err := row.Scan(
-		&book.BookID, &book.Title,
-		&book.Author, &book.ISBN,
-		&book.AvailableCount,
-		&book.Description,
-		&book.Comment,
-	)
-	if err == sql.ErrNoRows {
-		return Book{}, ErrBookNotFound
+func setPassword(db Store, user_id int, password string, must_change bool) error {
+	token, err := HashPassword(password)
+	if err != nil {
+		return err
 	}
 
-	return book, err
+	return db.Atomic(func(db Store) error {
+		err := db.SetUserToken(user_id, token, must_change)
+		if err != nil {
+			return err
+		}
+		return db.RevokeUserSessions(user_id)
+	})
 }
 
-// `CheckoutBook` obtains book information with id `book_id`.
+var ErrUnreturnedBooks = errors.New("user still has unreturned books")
+
+// `DeactivateUser` disables login of `user_id` and revokes all its
+// sessions. API keys are kept but stop working until reactivation.
 //
-// Book information is stored in struct `Book`. When no book
-// matches `book_id`, an `ErrBookNotFound` is returned.
-func CheckoutBook(db *sql.DB, book_id int) (Book, error) {
-	row := db.QueryRow(selectBook+"book_id=?", book_id)
-	book, err := scanBook(row)
+// May return `ErrInvalidUser`.
+func DeactivateUser(db Store, user_id int) error {
+	return db.Atomic(func(db Store) error {
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+
+		err = db.SetUserStatus(user_id, false, u.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		return db.RevokeUserSessions(user_id)
+	})
+}
+
+// `ReactivateUser` enables login of `user_id` again. Its expiry date
+// is kept, see `SetUserExpiry`.
+//
+// May return `ErrInvalidUser`.
+func ReactivateUser(db Store, user_id int) error {
+	return db.Atomic(func(db Store) error {
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+		return db.SetUserStatus(user_id, true, u.ExpiresAt)
+	})
+}
+
+// `SetUserType` changes the type of `user_id` to `type_id`. The
+// permissions of the new type take effect immediately.
+//
+// May return `ErrInvalidUser` or `ErrInvalidUserType`.
+func SetUserType(db Store, user_id int, type_id int) error {
+	return db.Atomic(func(db Store) error {
+		_, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+		_, err = db.UserTypeByID(type_id)
+		if err != nil {
+			return err
+		}
+		return db.SetUserType(user_id, type_id)
+	})
+}
+
+// `SetUserExpiry` sets the time after which `user_id` cannot log in.
+// Zero `expires_at` means never.
+//
+// May return `ErrInvalidUser`.
+func SetUserExpiry(db Store, user_id int, expires_at time.Time) error {
+	return db.Atomic(func(db Store) error {
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+		return db.SetUserStatus(user_id, u.Active, expires_at)
+	})
+}
+
+// `DeleteUser` removes `user_id`. Users with borrow history cannot be
+// removed, because records refer to them. They are anonymized instead:
+// renamed to "deleted-<user_id>", deactivated, and given a random
+// password, with all sessions, API keys and holds revoked. Returns whether
+// the user is anonymized rather than removed.
+//
+// May return `ErrInvalidUser` or `ErrUnreturnedBooks`.
+func DeleteUser(db Store, user_id int) (bool, error) {
+	anonymized := false
+	err := db.Atomic(func(db Store) error {
+		_, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+
+		list, err := db.ListRecords(user_id, RecordFilter{NotReturned: true}, Page{Size: 1})
+		if err != nil {
+			return err
+		}
+		if len(list) > 0 {
+			return ErrUnreturnedBooks
+		}
+
+		err = cancelUserHolds(db, user_id, time.Now())
+		if err != nil {
+			return err
+		}
+
+		list, err = db.ListRecords(user_id, RecordFilter{}, Page{Size: 1})
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return db.DeleteUser(user_id)
+		}
+
+		anonymized = true
+		password, err := newToken(32)
+		if err != nil {
+			return err
+		}
+		token, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		err = db.SetUserName(user_id, fmt.Sprintf("deleted-%d", user_id))
+		if err != nil {
+			return err
+		}
+		err = db.SetUserToken(user_id, token, false)
+		if err != nil {
+			return err
+		}
+		err = db.SetUserStatus(user_id, false, time.Time{})
+		if err != nil {
+			return err
+		}
+		err = db.RevokeUserSessions(user_id)
+		if err != nil {
+			return err
+		}
+		return db.RevokeUserAPIKeys(user_id)
+	})
 	if err != nil {
-		return Book{}, err
+		return false, err
 	}
 
-	return book, nil
+	return anonymized, nil
 }
 
-// `CheckoutISBN` obtains book information with `isbn`. Similar to
-// `CheckoutBook`.
+var ErrBookNotFound = errors.New("book not found")
+
+// `CheckoutBook` obtains book information with id `book_id`.
 //
-// Book information is stored in struct `Book`. When no book
-// matches `book_id`, an `ErrBookNotFound` is returned.
-func CheckoutISBN(db *sql.DB, isbn string) (Book, error) {
-	row := db.QueryRow(selectBook+"isbn=?", isbn)
-	book, err := scanBook(row)
+// Book information is stored in struct `Book`, with available counts
+// per branch. When no book matches `book_id`, an `ErrBookNotFound` is
+// returned.
+func CheckoutBook(db Store, book_id int) (Book, error) {
+	book, err := db.BookByID(book_id)
+	if err != nil {
+		return Book{}, err
+	}
+
+	book.Branches, err = db.BranchCounts(book_id)
 	if err != nil {
 		return Book{}, err
 	}
@@ -181,32 +572,28 @@ func CheckoutISBN(db *sql.DB, isbn string) (Book, error) {
 	return book, nil
 }
 
-var selectRecord = `
-SELECT
-	record_id, user_id, name, book_id,
-	return_date, borrow_date,
-	deadline, final_deadline
-FROM Record JOIN User USING (user_id)
-WHERE `
-
-func scanRecord(row RowScanner) (Record, error) {
-	var return_date sql.NullTime
-	var r Record
-	err := row.Scan(
-		&r.RecordID, &r.UserID, &r.Username, &r.BookID,
-		&return_date, &r.BorrowDate,
-		&r.DueDate, &r.FinalDate,
-	)
+// `CheckoutISBN` obtains book information with `isbn`, an ISBN-10 or
+// ISBN-13 in any formatting (see isbn.go). Similar to `CheckoutBook`.
+//
+// Book information is stored in struct `Book`. When no book
+// matches `isbn`, an `ErrBookNotFound` is returned.
+func CheckoutISBN(db Store, isbn string) (Book, error) {
+	// Invalid ISBNs left by migration 0014 are matched as they are
+	isbn13, err := NormalizeISBN(isbn)
 	if err != nil {
-		return Record{}, err
+		isbn13 = strings.TrimSpace(isbn)
+	}
+	book, err := db.BookByISBN(isbn13)
+	if err != nil {
+		return Book{}, err
 	}
 
-	if return_date.Valid {
-		r.Returned = true
-		r.ReturnDate = return_date.Time
+	book.Branches, err = db.BranchCounts(book.BookID)
+	if err != nil {
+		return Book{}, err
 	}
 
-	return r, nil
+	return book, nil
 }
 
 var ErrInvalidRecordID = errors.New("invalid record ID")
@@ -215,12 +602,8 @@ var ErrInvalidRecordID = errors.New("invalid record ID")
 //
 // Record information is stored in struct `Record`. When no record
 // matches `record_id`, an `ErrInvalidRecordID` is returned.
-func CheckoutRecord(db *sql.DB, record_id int) (Record, error) {
-	row := db.QueryRow(selectRecord+"record_id = ?", record_id)
-	r, err := scanRecord(row)
-	if err == sql.ErrNoRows {
-		return Record{}, ErrInvalidRecordID
-	}
+func CheckoutRecord(db Store, record_id int) (Record, error) {
+	r, err := db.RecordByID(record_id)
 	if err != nil {
 		return Record{}, err
 	}
@@ -230,358 +613,491 @@ func CheckoutRecord(db *sql.DB, record_id int) (Record, error) {
 
 var ErrNoAvailableBook = errors.New("no available book")
 var ErrInvalidBookID = errors.New("invalid book ID")
-var ErrSuspendedUser = errors.New("user suspended: you have more than 3 overdue books")
+var ErrSuspendedUser = errors.New("user suspended: too many overdue books")
+var ErrTooManyLoans = errors.New("too many books borrowed")
+
+// `SuspendedError` is returned by `BorrowBook` for users with more than
+// `MaxOverdue` overdue books. It matches `ErrSuspendedUser` in
+// `errors.Is`.
+type SuspendedError struct {
+	MaxOverdue int
+}
+
+func (e SuspendedError) Error() string {
+	return fmt.Sprintf("user suspended: you have more than %d overdue books", e.MaxOverdue)
+}
+
+func (e SuspendedError) Is(target error) bool {
+	return target == ErrSuspendedUser
+}
 
 // BorrowBook attempts to borrow a book with `book_id` and add a record.
+// Deadlines are set by the circulation policy of the user's type,
+// overridden by that of the book (see policy.go).
 //
 // The ID of newly added record is returned when success.
 // If there is no available book, `ErrNoAvailableBook` is returned.
 // If no book has `book_id`, `ErrInvalidBookID` is returned.
-// If the user with `user_id` has more than 3 overdue book records,
-// `BorrowBook` rejects this request.
-func BorrowBook(db *sql.DB, user_id, book_id int) (int, error) {
-	var tmp int
-	err := db.QueryRow(
-		"SELECT book_id FROM Book WHERE book_id = ?", book_id).
-		Scan(&tmp)
-	if err == sql.ErrNoRows {
-		return -1, ErrInvalidBookID
-	}
+// If the user with `user_id` has more than `MaxOverdue` overdue book
+// records, `BorrowBook` rejects this request with a `SuspendedError`,
+// if the user has `MaxLoans` unreturned books, with `ErrTooManyLoans`,
+// and if the user owes more than `MaxBalance` in fines (see fine.go),
+// with `ErrFinesOwed`. The copy reserved for the user's hold on the
+// book is borrowed if any, and the hold is fulfilled (see hold.go).
+// Otherwise any available copy is borrowed (see item.go).
+func BorrowBook(db Store, user_id, book_id int) (int, error) {
+	return BorrowBookAt(db, user_id, book_id, 0)
+}
+
+// `BorrowBookAt` is like `BorrowBook`, but borrows a copy kept at
+// `branch_id`, or at any branch if `branch_id` is 0 (see branch.go).
+// If the copy reserved for the user's hold is kept at another branch,
+// an available copy at `branch_id` is borrowed instead, and the
+// reserved copy goes to the next holder.
+//
+// May also return `ErrInvalidBranchID`.
+func BorrowBookAt(db Store, user_id, book_id, branch_id int) (int, error) {
+	now := time.Now()
+	var record_id int
+	err := db.Atomic(func(tx Store) error {
+		err := expireHolds(tx, now)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.BookByID(book_id)
+		if err == ErrBookNotFound {
+			return ErrInvalidBookID
+		}
+		if err != nil {
+			return err
+		}
+		if branch_id != 0 {
+			err = checkBranch(tx, branch_id)
+			if err != nil {
+				return err
+			}
+		}
+
+		p, err := checkBorrower(tx, user_id, book_id, now)
+		if err != nil {
+			return err
+		}
+
+		h, err := tx.ActiveHold(user_id, book_id)
+		if err != nil {
+			return err
+		}
+		var it Item
+		if h.Status == HoldReady {
+			it, err = tx.ItemByID(h.ItemID)
+			if err != nil {
+				return err
+			}
+			if branch_id != 0 && it.BranchID != branch_id {
+				it, err = tx.AvailableItem(book_id, branch_id)
+			}
+		} else {
+			it, err = tx.AvailableItem(book_id, branch_id)
+		}
+		if err != nil {
+			return err
+		}
+		if it.ItemID == 0 {
+			return ErrNoAvailableBook
+		}
+
+		record_id, err = lendItem(tx, user_id, it, h, p, now)
+		return err
+	})
 	if err != nil {
 		return -1, err
 	}
 
+	return record_id, nil
+}
+
+// `BorrowItem` is like `BorrowBook`, but borrows the copy with
+// `barcode`. Copies reserved for other users cannot be borrowed. If the
+// user's hold on the book has another copy reserved, that copy goes
+// to the next holder.
+//
+// May return `ErrInvalidBarcode` or `ErrItemNotAvailable`, and errors
+// of `BorrowBook` on the user.
+func BorrowItem(db Store, user_id int, barcode string) (int, error) {
 	now := time.Now()
-	var overdue_count int
-	err = db.QueryRow(`
-		SELECT COUNT(*)
-		FROM Record
-		WHERE
-			user_id = ? AND
-			return_date IS NULL AND
-			deadline < ?`,
-		user_id, now).
-		Scan(&overdue_count)
+	var record_id int
+	err := db.Atomic(func(tx Store) error {
+		err := expireHolds(tx, now)
+		if err != nil {
+			return err
+		}
+
+		it, err := tx.ItemByBarcode(barcode)
+		if err != nil {
+			return err
+		}
+
+		p, err := checkBorrower(tx, user_id, it.BookID, now)
+		if err != nil {
+			return err
+		}
+
+		h, err := tx.ActiveHold(user_id, it.BookID)
+		if err != nil {
+			return err
+		}
+		reserved := h.Status == HoldReady && h.ItemID == it.ItemID
+		if it.Status != ItemAvailable && !reserved {
+			return ErrItemNotAvailable
+		}
+
+		record_id, err = lendItem(tx, user_id, it, h, p, now)
+		if err == ErrNoAvailableBook {
+			return ErrItemNotAvailable
+		}
+		return err
+	})
 	if err != nil {
 		return -1, err
 	}
-	if overdue_count > 3 {
-		return -1, ErrSuspendedUser
-	}
 
-	due := now.Add(month)
-	final := now.Add(3 * month)
+	return record_id, nil
+}
 
-	tx, err := db.Begin()
+// `checkBorrower` returns the effective policy of `user_id` on
+// `book_id`, if the user is allowed to borrow it.
+func checkBorrower(db Store, user_id, book_id int, now time.Time) (Policy, error) {
+	p, err := effectivePolicy(db, user_id, book_id)
 	if err != nil {
-		return -1, err
+		return Policy{}, err
 	}
-	defer tx.Rollback()
 
-	// try decreasing available count
-	result, err := tx.Exec(`
-		UPDATE Book
-		SET
-			available_count = available_count - 1
-		WHERE
-			book_id = ? AND
-			available_count > 0`, book_id)
+	overdue_count, err := db.CountOverdue(user_id, now)
 	if err != nil {
-		return -1, err
+		return Policy{}, err
+	}
+	if overdue_count > p.MaxOverdue {
+		return Policy{}, SuspendedError{p.MaxOverdue}
+	}
+
+	if p.MaxBalance > 0 {
+		b, err := GetFineBalance(db, user_id, now)
+		if err != nil {
+			return Policy{}, err
+		}
+		if b.Balance > p.MaxBalance {
+			return Policy{}, ErrFinesOwed
+		}
+	}
+
+	if p.MaxLoans > 0 {
+		loan_count, err := db.CountLoans(user_id)
+		if err != nil {
+			return Policy{}, err
+		}
+		if loan_count >= p.MaxLoans {
+			return Policy{}, ErrTooManyLoans
+		}
 	}
 
-	cnt, err := result.RowsAffected()
+	return p, nil
+}
+
+// `lendItem` lends `it` to `user_id` with deadlines of `p`, and
+// fulfils `h`, the user's active hold on the book if any. Returns
+// `ErrNoAvailableBook` if `it` has been taken since it was read.
+func lendItem(db Store, user_id int, it Item, h Hold, p Policy, now time.Time) (int, error) {
+	err := db.ChangeItemStatus(it.ItemID, it.Status, ItemLoaned)
 	if err != nil {
 		return -1, err
 	}
-	if cnt == 0 {
-		return -1, ErrNoAvailableBook
-	}
 
-	result, err = tx.Exec(`
-		INSERT INTO Record
-			(user_id, book_id, borrow_date, deadline, final_deadline)
-		VALUES (?, ?, ?, ?, ?)`,
-		user_id, book_id, now, due, final)
+	record_id, err := db.InsertRecord(Record{
+		UserID:         user_id,
+		BookID:         it.BookID,
+		ItemID:         it.ItemID,
+		BorrowBranchID: it.BranchID,
+		BorrowDate:     now,
+		DueDate:        now.Add(days(p.LoanDays)),
+		FinalDate:      now.Add(days(p.FinalDays)),
+	})
 	if err != nil {
 		return -1, err
 	}
 
-	record_id, err := result.LastInsertId()
+	if h.HoldID == 0 {
+		return record_id, nil
+	}
+	reserved_id := h.ItemID
+	h.Status = HoldFulfilled
+	h.ItemID = it.ItemID
+	err = db.UpdateHold(h)
 	if err != nil {
 		return -1, err
 	}
+	if reserved_id != 0 && reserved_id != it.ItemID {
+		err = releaseItem(db, reserved_id, it.BookID, now)
+		if err != nil {
+			return -1, err
+		}
+	}
 
-	tx.Commit()
-	return int(record_id), nil
+	return record_id, nil
 }
 
 var ErrAlreadyReturned = errors.New("this book has been returned")
 var ErrOverdue = errors.New("cannot extend deadline for overdue records")
-var ErrNotExtensible = errors.New("do extend deadline in the last week")
-var ErrFinalDeadline = errors.New("must return book in three months")
+var ErrNotExtensible = errors.New("deadline cannot be extended now")
+var ErrFinalDeadline = errors.New("cannot extend deadline beyond the final deadline")
 
 // `ExtendDeadline` tries to extend deadline of a specific record
-// with `record_id` for a month. Deadlines are not allowed to be later than
-// final deadlines, in which case an `ErrFinalDeadline` will be returned.
+// with `record_id` by `ExtendDays` of the circulation policy. Deadlines
+// are not allowed to be later than final deadlines, in which case an
+// `ErrFinalDeadline` will be returned.
 //
 // `ErrInvalidRecordID` occurs when no record matches `record_id`.
 //
 // Attempting to extend deadline for returned or overdue records is invalid, which
 // will result in `ErrAlreadyReturned` and `ErrOverdue` respectively.
-// Extending deadline is limited within the last week before deadline,
-// and an `ErrNotExtensible` will be returned otherwise.
+// Extending deadline is limited within `ExtendWindowDays` before
+// deadline, and an `ErrNotExtensible` will be returned otherwise, or if
+// extension is disabled by the policy.
 //
 // NOTE: this function does not check `user_id`. Anyone who knows
 // `record_id` can do this.
-func ExtendDeadline(db *sql.DB, record_id int) error {
-	var is_null int
-	var due, final time.Time
-	err := db.QueryRow(
-		`SELECT
-			ISNULL(return_date),
-			deadline,
-			final_deadline
-		FROM Record WHERE record_id=?`,
-		record_id).
-		Scan(&is_null, &due, &final)
-	if err == sql.ErrNoRows {
-		return ErrInvalidRecordID
-	}
-	if err != nil {
-		return err
-	}
+func ExtendDeadline(db Store, record_id int) error {
+	return db.Atomic(func(db Store) error {
+		r, err := db.RecordByID(record_id)
+		if err != nil {
+			return err
+		}
 
-	if is_null == 0 {
-		return ErrAlreadyReturned
-	}
+		if r.Returned {
+			return ErrAlreadyReturned
+		}
 
-	now := time.Now()
-	if due.Before(now) {
-		return ErrOverdue
-	}
+		now := time.Now()
+		if r.DueDate.Before(now) {
+			return ErrOverdue
+		}
 
-	next_week := now.Add(week)
-	if next_week.Before(due) {
-		return ErrNotExtensible
-	}
+		p, err := effectivePolicy(db, r.UserID, r.BookID)
+		if err != nil {
+			return err
+		}
 
-	new_due := due.Add(month)
-	if final.Before(new_due) {
-		return ErrFinalDeadline
-	}
+		window := now.Add(days(p.ExtendWindowDays))
+		if p.ExtendDays == 0 || window.Before(r.DueDate) {
+			return ErrNotExtensible
+		}
 
-	_, err = db.Exec(`
-		UPDATE Record
-		SET deadline = ?
-		WHERE record_id = ?`, new_due, record_id)
-	if err != nil {
-		return err
-	}
-	return nil
+		new_due := r.DueDate.Add(days(p.ExtendDays))
+		if r.FinalDate.Before(new_due) {
+			return ErrFinalDeadline
+		}
+
+		return db.SetDeadline(record_id, new_due)
+	})
 }
 
-// `ReturnBook` returns book for record with `record_id`.
+// `ReturnBook` returns book for record with `record_id`. Fines of
+// overdue records are charged (see fine.go), and the copy is put back
+// on the shelf, or reserved for the next holder of the book if any.
 //
 // If no record matches `record_id`, an `ErrInvaildRecordID` is returned.
 // If the record is marked as "returned", an `ErrAlreadyReturned` is returned.
 //
 // NOTE: this function does not check `user_id`.
-func ReturnBook(db *sql.DB, record_id int) error {
-	var is_null int
-	err := db.QueryRow("SELECT ISNULL(return_date) FROM Record WHERE record_id=?", record_id).
-		Scan(&is_null)
-	if err == sql.ErrNoRows {
-		return ErrInvalidRecordID
-	}
-	if err != nil {
-		return err
-	}
-	if is_null == 0 {
-		return ErrAlreadyReturned
-	}
+func ReturnBook(db Store, record_id int) error {
+	return ReturnBookAt(db, record_id, 0)
+}
 
-	var book_id int
-	err = db.QueryRow("SELECT book_id FROM Record WHERE record_id=?", record_id).
-		Scan(&book_id)
-	if err != nil { // must be valid record ID
-		return err
-	}
+// `ReturnBookAt` is like `ReturnBook`, but the copy is returned at
+// `branch_id`, or at its home branch if `branch_id` is 0. Copies
+// returned at another branch are in transit until received at home by
+// `ReceiveItem` (see branch.go).
+//
+// May also return `ErrInvalidBranchID`.
+func ReturnBookAt(db Store, record_id, branch_id int) error {
+	return db.Atomic(func(tx Store) error {
+		r, err := tx.RecordByID(record_id)
+		if err != nil {
+			return err
+		}
+		if r.Returned {
+			return ErrAlreadyReturned
+		}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+		var it Item
+		if r.ItemID != 0 {
+			it, err = tx.ItemByID(r.ItemID)
+			if err != nil {
+				return err
+			}
+		}
+		if branch_id == 0 {
+			branch_id = it.BranchID
+		} else {
+			err = checkBranch(tx, branch_id)
+			if err != nil {
+				return err
+			}
+		}
 
-	now := time.Now()
-	_, err = tx.Exec("UPDATE Record SET return_date=? WHERE record_id=?", now, record_id)
+		now := time.Now()
+		err = tx.SetReturnDate(record_id, now, branch_id)
+		if err != nil {
+			return err
+		}
+		r.Returned = true
+		r.ReturnDate = now
+		err = chargeFine(tx, r, now)
+		if err != nil {
+			return err
+		}
+
+		if r.ItemID == 0 {
+			return nil
+		}
+		if it.BranchID != branch_id {
+			return tx.SetItemStatus(r.ItemID, ItemInTransit)
+		}
+		return releaseItem(tx, r.ItemID, r.BookID, now)
+	})
+}
+
+// `CheckoutItemLoan` returns the unreturned record of the copy with
+// `barcode`.
+//
+// May return `ErrInvalidBarcode`, or `ErrAlreadyReturned` if the copy
+// is not on loan.
+func CheckoutItemLoan(db Store, barcode string) (Record, error) {
+	it, err := db.ItemByBarcode(barcode)
 	if err != nil {
-		return err
+		return Record{}, err
 	}
-
-	_, err = tx.Exec(`
-		UPDATE Book
-		SET
-			available_count = available_count + 1
-		WHERE book_id = ?`, book_id)
+	r, err := db.ItemLoan(it.ItemID)
 	if err != nil {
-		return err
+		return Record{}, err
 	}
-
-	tx.Commit()
-	return nil
+	if r.RecordID == 0 {
+		return Record{}, ErrAlreadyReturned
+	}
+	return r, nil
 }
 
 // `NewBook` simply insert a new book record into table Book.
 // More information can be added by `UpdateBook`.
-// Book's available count is initially 0.
-func NewBook(db *sql.DB) (int, error) {
-	result, err := db.Exec("INSERT INTO Book SET available_count = 0")
-	if err != nil {
-		return -1, err
-	}
-
-	book_id, err := result.LastInsertId()
+// Book has no items initially.
+func NewBook(db Store) (int, error) {
+	book_id, err := db.InsertBook()
 	if err != nil {
 		return -1, err
 	}
 
-	return int(book_id), nil
+	return book_id, reindexBook(db, book_id)
 }
 
-func UpdateBook(db *sql.DB, book_id, delta_cnt int, info BookInfo) error {
-	var tmp int
-	err := db.QueryRow("SELECT book_id FROM Book WHERE book_id=?", book_id).
-		Scan(&tmp)
-	if err == sql.ErrNoRows {
-		return ErrInvalidBookID
-	}
-	if err != nil {
-		return err
-	}
-
-	var buf strings.Builder
-	buf.WriteString("UPDATE Book SET ")
-
-	var args []interface{}
-	if info.Author != nil {
-		buf.WriteString("author=?,")
-		args = append(args, info.Author)
-	}
-	if info.Comment != nil {
-		buf.WriteString("comment=?,")
-		args = append(args, info.Comment)
-	}
-	if info.Description != nil {
-		buf.WriteString("description=?,")
-		args = append(args, info.Description)
-	}
+// `UpdateBook` changes book information of `book_id` and adds
+// `delta_cnt` to its available count. See `BookInfo` for details.
+// Positive `delta_cnt` adds copies with generated barcodes, which go
+// to holders of the book first and belong to `DefaultBranchID`, and
+// negative `delta_cnt` withdraws available copies (see item.go).
+// ISBNs are checked and stored as ISBN-13s (see isbn.go), and an empty
+// ISBN removes it.
+//
+// Returns `ErrInvalidBookID` if no book has `book_id`,
+// `ErrNoAvailableBook` if there are not enough copies to withdraw,
+// `ErrInvalidISBN` for malformed ISBNs, and `ErrDuplicateISBN` if
+// another book has the ISBN.
+func UpdateBook(db Store, book_id, delta_cnt int, info BookInfo) error {
 	if info.ISBN != nil {
-		buf.WriteString("isbn=?,")
-		args = append(args, info.ISBN)
-	}
-	if info.Title != nil {
-		buf.WriteString("title=?,")
-		args = append(args, info.Title)
+		display := strings.TrimSpace(*info.ISBN)
+		isbn := ""
+		if display != "" {
+			var err error
+			isbn, err = NormalizeISBN(display)
+			if err != nil {
+				return err
+			}
+		}
+		info.ISBN, info.ISBNDisplay = &isbn, &display
 	}
 
-	buf.WriteString("available_count=available_count+(?) ")
-	buf.WriteString("WHERE book_id=?")
-	args = append(args, delta_cnt)
-	args = append(args, book_id)
-
-	_, err = db.Exec(buf.String(), args...)
-	return err
-}
-
-// `SearchBookByTitle` returns all books whose title contain `keyword`.
-func SearchBookByTitle(db *sql.DB, keyword string) ([]Book, error) {
-	list := []Book{}
-	rows, err := db.Query(selectBook+"title LIKE ?", fmt.Sprintf("%%%s%%", keyword))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	err := db.Atomic(func(db Store) error {
+		if info.ISBN != nil && *info.ISBN != "" {
+			b, err := db.BookByISBN(*info.ISBN)
+			if err == nil && b.BookID != book_id {
+				return ErrDuplicateISBN
+			}
+			if err != nil && err != ErrBookNotFound {
+				return err
+			}
+		}
 
-	for rows.Next() {
-		book, err := scanBook(rows)
+		err := db.ModifyBook(book_id, info)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		list = append(list, book)
-	}
+		if delta_cnt < 0 {
+			return withdrawItems(db, book_id, -delta_cnt)
+		}
+		if delta_cnt == 0 {
+			return nil
+		}
 
-	err = rows.Err()
+		now := time.Now()
+		_, err = newItems(db, book_id, DefaultBranchID, delta_cnt, now)
+		if err != nil {
+			return err
+		}
+		return serveHolds(db, book_id, now)
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return list, nil
+	// Keep full-text search in sync (see fulltext.go)
+	return reindexBook(db, book_id)
+}
+
+// `SearchBookByTitle` returns all books whose title contain `keyword`.
+func SearchBookByTitle(db Store, keyword string) ([]Book, error) {
+	return searchBooks(db, "title", keyword)
 }
 
 // `SearchBookByAuthor` returns all book whose author names contain `keyword`.
-func SearchBookByAuthor(db *sql.DB, keyword string) ([]Book, error) {
-	list := []Book{}
-	rows, err := db.Query(selectBook+"author LIKE ?", fmt.Sprintf("%%%s%%", keyword))
+func SearchBookByAuthor(db Store, keyword string) ([]Book, error) {
+	return searchBooks(db, "author", keyword)
+}
+
+// `searchBooks` returns books matching `keyword` in `field`, with
+// available counts per branch.
+func searchBooks(db Store, field, keyword string) ([]Book, error) {
+	books, err := db.QueryBooks(&BookQuery{Field: field, Cmp: ":", Text: keyword}, Page{})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		book, err := scanBook(rows)
-		if err != nil {
-			return nil, err
-		}
-
-		list = append(list, book)
-	}
-
-	err = rows.Err()
+	err = fillBranches(db, books)
 	if err != nil {
 		return nil, err
 	}
 
-	return list, nil
+	return books, nil
 }
 
 // `ChechoutHistory` list borrow history of user with `user_id`.
 // The max number of records can be controlled by `limit` argument.
-// `filter` is used in WHERE clause in SQL statement, and `args` is the
-// placeholders for prepared statements.
-func CheckoutHistory(db *sql.DB, user_id int, limit int, filter string, args ...interface{}) ([]Record, error) {
-	if len(filter) != 0 {
-		filter = filter + " AND user_id = ?"
-	} else {
-		filter = "user_id = ?"
+// Only records matching `filter` are listed.
+func CheckoutHistory(db Store, user_id int, limit int, filter RecordFilter) ([]Record, error) {
+	if limit < 0 {
+		return nil, ErrInvalidPageSize
 	}
-	query := selectRecord + filter + " ORDER BY borrow_date DESC, record_id DESC LIMIT ?"
-	// fmt.Println(query)
-
-	args = append(args, user_id)
-	args = append(args, limit)
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	list := []Record{}
-	for rows.Next() {
-		r, err := scanRecord(rows)
-		if err != nil {
-			return nil, err
-		}
-
-		list = append(list, r)
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return nil, err
+	if limit == 0 {
+		return []Record{}, nil
 	}
-
-	return list, nil
+	return db.ListRecords(user_id, filter, Page{Size: limit})
 }
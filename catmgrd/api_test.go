@@ -1,116 +1,243 @@
 package main
 
 import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
-func TestMain(m *testing.M) {
-	rand.Seed(time.Now().UnixNano())
+type testStore struct {
+	name  string
+	store Store
+	close func()
+}
 
-	config, err := LoadMySQLConfig("test_config.json")
+// Every test runs against all stores in `testStores`.
+var testStores []testStore
+
+// `openTestStores` prepares an in-memory store and a SQLite database
+// in a temporary directory, both loaded with sample data. If
+// "test_config.json" exists, the database in it (MySQL or PostgreSQL)
+// is also tested. It must be empty, as created by `sql/setup_test.sql`
+// or `sql/setup_test_postgres.sql`.
+func openTestStores() error {
+	mem := NewMemoryStore()
+	err := loadSamples(mem)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	testStores = append(testStores, testStore{"memory", mem, func() {}})
 
-	db, err = ConnectMySQL(config) // `db` is declared in main.go
+	dir, err := ioutil.TempDir("", "catmgrd-test")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	conn, dialect, err := ConnectDatabase(DatabaseConfig{
+		Driver: "sqlite",
+		File:   filepath.Join(dir, "library_test.db"),
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	sqlite := NewSQLStore(conn, dialect)
+	testStores = append(testStores, testStore{"sqlite", sqlite, func() {
+		sqlite.Close()
+		os.RemoveAll(dir)
+	}})
+
+	_, err = sqlite.MigrateUp(-1)
+	if err != nil {
+		return err
+	}
+	err = loadSamples(sqlite)
+	if err != nil {
+		return err
 	}
 
+	config, err := LoadConfig("test_config.json")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, dialect, err = ConnectDatabase(config.DatabaseConfig)
+	if err != nil {
+		return err
+	}
+	external := NewSQLStore(conn, dialect)
+	testStores = append(testStores, testStore{dialect.Name(), external, func() { external.Close() }})
+
+	_, err = external.MigrateUp(-1)
+	if err != nil {
+		return err
+	}
+	return loadSamples(external)
+}
+
+// `forEachStore` runs `fn` as a subtest for every test store, with
+// `db` set to that store.
+func forEachStore(t *testing.T, fn func(t *testing.T)) {
+	for _, e := range testStores {
+		db = e.store // `db` is declared in main.go
+		t.Run(e.name, fn)
+	}
+}
+
+func TestMain(m *testing.M) {
+	rand.Seed(time.Now().UnixNano())
+
 	var return_code int
 	defer func() {
-		db.Close()
+		for _, e := range testStores {
+			e.close()
+		}
 		os.Exit(return_code)
 	}()
 
+	err := openTestStores()
+	if err != nil {
+		panic(err)
+	}
+
 	return_code = m.Run()
 }
 
 func TestAuthUser(t *testing.T) {
-	tb := []struct {
-		user     interface{}
-		password string
-		req      Permission
-		err      error
-	}{
-		{1, "root", Permission{true, true, true, true}, nil},
-		{2, "admin", Permission{true, true, false, true}, nil},
-		{233, "admin", Permission{true, true, false, true}, ErrInvalidUser},
-		{2, "admin", Permission{true, true, true, true}, ErrPermissionDenied},
-		{2, "admin", Permission{false, false, false, true}, nil},
-		{2, "admin", Permission{false, false, false, false}, nil},
-		{3, "123456", Permission{false, false, true, false}, nil},
-		{3, "1234567", Permission{false, false, true, false}, ErrInvalidPassword},
-		{4, "123456", Permission{false, false, true, false}, nil},
-		{5, "654321", Permission{false, false, true, false}, ErrPermissionDenied},
-		{5, "654321", Permission{false, false, false, false}, nil},
-		{5, "", Permission{false, false, false, false}, ErrInvalidPassword},
-		{19260817, "", Permission{false, false, false, false}, ErrInvalidUser},
-		{0, "", Permission{false, false, false, false}, ErrInvalidUser},
-		{-1, "", Permission{false, false, false, false}, ErrInvalidUser},
-		{"root", "root", Permission{true, true, true, true}, nil},
-		{"admin", "admin", Permission{true, true, false, true}, nil},
-		{"nobody", "123456", Permission{false, false, false, false}, ErrInvalidUser},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			user     interface{}
+			password string
+			req      PermissionSet
+			err      error
+		}{
+			{1, "root", AllPermissions, nil},
+			{2, "admin", NewPermissionSet(PermBookUpdate, PermUserCreate, PermRecordInspectAny), nil},
+			{233, "admin", NewPermissionSet(PermBookUpdate, PermUserCreate, PermRecordInspectAny), ErrInvalidUser},
+			{2, "admin", AllPermissions, ErrPermissionDenied},
+			{2, "admin", PermissionSet{PermRecordInspectAny}, nil},
+			{2, "admin", PermissionSet{}, nil},
+			{3, "123456", PermissionSet{PermRecordBorrow}, nil},
+			{3, "1234567", PermissionSet{PermRecordBorrow}, ErrInvalidPassword},
+			{4, "123456", PermissionSet{PermRecordBorrow}, nil},
+			{5, "654321", PermissionSet{PermRecordBorrow}, ErrPermissionDenied},
+			{5, "654321", PermissionSet{}, nil},
+			{5, "", PermissionSet{}, ErrInvalidPassword},
+			{19260817, "", PermissionSet{}, ErrInvalidUser},
+			{0, "", PermissionSet{}, ErrInvalidUser},
+			{-1, "", PermissionSet{}, ErrInvalidUser},
+			{"root", "root", AllPermissions, nil},
+			{"admin", "admin", NewPermissionSet(PermBookUpdate, PermUserCreate, PermRecordInspectAny), nil},
+			{"nobody", "123456", PermissionSet{}, ErrInvalidUser},
+		}
 
-	for _, e := range tb {
-		err := AuthUser(db, e.user, e.password, e.req)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
+		for _, e := range tb {
+			_, err := AuthUser(db, e.user, e.password, e.req)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
 		}
-	}
+	})
+}
+
+func TestLegacyPassword(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		legacy := fmt.Sprintf("%x", sha1.Sum([]byte("123456")))
+		user_id, err := db.InsertUser(3, randString(16), legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		LegacyPasswordCutoff = time.Now().Add(-time.Hour)
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		LegacyPasswordCutoff = time.Time{}
+		if err != ErrLegacyPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrLegacyPassword, err)
+		}
+
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(u.Token, "$argon2id$") {
+			t.Errorf("token is not upgraded: %s", u.Token)
+		}
+
+		LegacyPasswordCutoff = time.Now().Add(-time.Hour)
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		LegacyPasswordCutoff = time.Time{}
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+		_, err = AuthUser(db, user_id, "1234567", PermissionSet{})
+		if err != ErrInvalidPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPassword, err)
+		}
+	})
 }
 
 func TestGetUserID(t *testing.T) {
-	tb := []struct {
-		name    string
-		user_id int
-		err     error
-	}{
-		{"root", 1, nil},
-		{"admin", 2, nil},
-		{"riteme", 3, nil},
-		{"nobody", -1, ErrInvalidUser},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			name    string
+			user_id int
+			err     error
+		}{
+			{"root", 1, nil},
+			{"admin", 2, nil},
+			{"riteme", 3, nil},
+			{"nobody", -1, ErrInvalidUser},
+		}
 
-	for _, e := range tb {
-		got, err := GetUserID(db, e.name)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
-		} else if got != e.user_id {
-			t.Errorf("expected: %d, got: %d", e.user_id, got)
+		for _, e := range tb {
+			got, err := GetUserID(db, e.name)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			} else if got != e.user_id {
+				t.Errorf("expected: %d, got: %d", e.user_id, got)
+			}
 		}
-	}
+	})
 }
 
 func TestGetUserTypeID(t *testing.T) {
-	tb := []struct {
-		type_name string
-		type_id   int
-		err       error
-	}{
-		{"root", 1, nil},
-		{"admin", 2, nil},
-		{"student", 3, nil},
-		{"guest", 4, nil},
-		{"trump", -1, ErrInvalidUserType},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			type_name string
+			type_id   int
+			err       error
+		}{
+			{"root", 1, nil},
+			{"admin", 2, nil},
+			{"student", 3, nil},
+			{"guest", 4, nil},
+			{"trump", -1, ErrInvalidUserType},
+		}
 
-	for _, e := range tb {
-		got, err := GetUserTypeID(db, e.type_name)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
-		} else if got != e.type_id {
-			t.Errorf("type_name = %#v; expected: %d, got: %d",
-				e.type_name, e.type_id, got)
+		for _, e := range tb {
+			got, err := GetUserTypeID(db, e.type_name)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			} else if got != e.type_id {
+				t.Errorf("type_name = %#v; expected: %d, got: %d",
+					e.type_name, e.type_id, got)
+			}
 		}
-	}
+	})
 }
 
 func randString(length int) string {
@@ -122,75 +249,544 @@ func randString(length int) string {
 	return buf.String()
 }
 
-func TestAddUser(t *testing.T) {
-	type_id := rand.Intn(4) + 1
-	username := randString(8)
-	password := randString(16)
-	t.Logf("type_id = %d, username = %#v, password = %#v",
-		type_id, username, password)
+func TestUserType(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		name := randString(8)
+		type_id, err := NewUserType(db, UserType{TypeName: name, Perm: PermissionSet{PermRecordBorrow}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewUserType(db, UserType{TypeName: name})
+		if err != ErrDuplicateUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrDuplicateUserType, err)
+		}
+		_, err = NewUserType(db, UserType{})
+		if err != ErrEmptyTypeName {
+			t.Errorf("expected: %+v, got: %+v", ErrEmptyTypeName, err)
+		}
+		_, err = NewUserType(db, UserType{TypeName: randString(8), Perm: PermissionSet{"book.burn"}})
+		if err != ErrUnknownPermission {
+			t.Errorf("expected: %+v, got: %+v", ErrUnknownPermission, err)
+		}
 
-	user_id, err := AddUser(db, type_id, username, password)
-	if err != nil {
-		t.Error(err)
-	} else {
-		err := AuthUser(db, user_id, password, Permission{})
+		err = UpdateUserType(db, UserType{TypeID: type_id, TypeName: "root"})
+		if err != ErrDuplicateUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrDuplicateUserType, err)
+		}
+		err = UpdateUserType(db, UserType{TypeID: 19260817, TypeName: randString(8)})
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+		name = randString(8)
+		err = UpdateUserType(db, UserType{TypeID: type_id, TypeName: name, Perm: PermissionSet{"book.burn"}})
+		if err != ErrUnknownPermission {
+			t.Errorf("expected: %+v, got: %+v", ErrUnknownPermission, err)
+		}
+		perm := PermissionSet{PermRecordInspectAny, PermBookCreate, PermRecordInspectAny}
+		err = UpdateUserType(db, UserType{TypeID: type_id, TypeName: name, Perm: perm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list, err := ListUserTypes(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, e := range list {
+			if e.TypeID == type_id {
+				found = true
+				expect := PermissionSet{PermBookCreate, PermRecordInspectAny}
+				if e.TypeName != name || !reflect.DeepEqual(e.Perm, expect) {
+					t.Errorf("unexpected user type: %+v", e)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("user type %d not listed", type_id)
+		}
+
+		user_id, err := AddUser(db, type_id, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{PermRecordInspectAny})
 		if err != nil {
 			t.Error(err)
 		}
+		err = DeleteUserType(db, type_id)
+		if err != ErrUserTypeInUse {
+			t.Errorf("expected: %+v, got: %+v", ErrUserTypeInUse, err)
+		}
+		_, err = DeleteUser(db, user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = DeleteUserType(db, type_id)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = db.UserTypeByID(type_id)
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+		err = DeleteUserType(db, type_id)
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+	})
+}
+
+func without(s PermissionSet, p Permission) PermissionSet {
+	ret := PermissionSet{}
+	for _, e := range s {
+		if e != p {
+			ret = append(ret, e)
+		}
 	}
+	return ret
+}
+
+func TestLastAdminType(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		// only "root" (1) and "admin" (2) have usertype.manage permission
+		admin, err := db.UserTypeByID(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		demoted := admin
+		demoted.Perm = without(admin.Perm, PermUserTypeManage)
+		err = UpdateUserType(db, demoted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			err := UpdateUserType(db, admin)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		root, err := db.UserTypeByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root.Perm = without(root.Perm, PermUserTypeManage)
+		err = UpdateUserType(db, root)
+		if err != ErrLastAdminType {
+			t.Errorf("expected: %+v, got: %+v", ErrLastAdminType, err)
+		}
+
+		type_id, err := NewUserType(db, UserType{TypeName: randString(8), Perm: PermissionSet{PermUserTypeManage}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = DeleteUserType(db, type_id)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestCheckGrant(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		admin, err := db.UserByID(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// admin cannot borrow, so neither root nor student is granted
+		tb := []struct {
+			type_id int
+			err     error
+		}{
+			{1, ErrPrivilegeEscalation},
+			{2, nil},
+			{3, ErrPrivilegeEscalation},
+			{4, nil},
+			{19260817, ErrInvalidUserType},
+		}
+		for _, e := range tb {
+			err = CheckTypeGrant(db, admin, e.type_id)
+			if err != e.err {
+				t.Errorf("type %d: expected: %+v, got: %+v", e.type_id, e.err, err)
+			}
+		}
+
+		root, err := db.UserByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckGrant(root, AllPermissions)
+		if err != nil {
+			t.Error(err)
+		}
+		err = CheckGrant(admin, PermissionSet{PermRecordBorrow})
+		if err != ErrPrivilegeEscalation {
+			t.Errorf("expected: %+v, got: %+v", ErrPrivilegeEscalation, err)
+		}
+	})
+}
+
+func TestSetUserType(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user_id, err := AddUser(db, 4, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{PermRecordBorrow})
+		if err != ErrPermissionDenied {
+			t.Errorf("expected: %+v, got: %+v", ErrPermissionDenied, err)
+		}
+
+		err = SetUserType(db, user_id, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := AuthUser(db, user_id, "123456", PermissionSet{PermRecordBorrow})
+		if err != nil {
+			t.Error(err)
+		} else if u.TypeID != 3 {
+			t.Errorf("expected type 3, got %d", u.TypeID)
+		}
+
+		err = SetUserType(db, user_id, 19260817)
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+		err = SetUserType(db, 19260817, 3)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+	})
+}
+
+func TestAddUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		type_id := rand.Intn(4) + 1
+		username := randString(8)
+		password := randString(16)
+		t.Logf("type_id = %d, username = %#v, password = %#v",
+			type_id, username, password)
+
+		user_id, err := AddUser(db, type_id, username, password)
+		if err != nil {
+			t.Error(err)
+		} else {
+			_, err := AuthUser(db, user_id, password, PermissionSet{})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		username := randString(8)
+		user_id, err := AddUser(db, 3, username, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := Login(db, "", user_id, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tb := []struct {
+			old_password string
+			new_password string
+			err          error
+		}{
+			{"1234567", "654321", ErrInvalidPassword},
+			{"123456", "", ErrEmptyPassword},
+			{"123456", "654321", nil},
+			{"123456", "abcdef", ErrInvalidPassword},
+			{"654321", "abcdef", nil},
+		}
+		for _, e := range tb {
+			err := ChangePassword(db, "", username, e.old_password, e.new_password)
+			if err != e.err {
+				t.Errorf("%#v -> %#v: expected: %+v, got: %+v",
+					e.old_password, e.new_password, e.err, err)
+			}
+		}
+
+		_, err = AuthUser(db, user_id, "abcdef", PermissionSet{PermRecordBorrow})
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = AuthSession(db, token, PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("sessions should be revoked, got: %+v", err)
+		}
+
+		err = ChangePassword(db, "", "nobody", "123456", "654321")
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		username := randString(8)
+		user_id, err := AddUser(db, 3, username, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ResetPassword(db, user_id, "", false)
+		if err != ErrEmptyPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrEmptyPassword, err)
+		}
+		err = ResetPassword(db, 19260817, "654321", false)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+
+		err = ResetPassword(db, user_id, "654321", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "654321", PermissionSet{})
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = ResetPassword(db, user_id, "abcdef", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "abcdef", PermissionSet{})
+		if err != ErrMustChangePassword {
+			t.Errorf("expected: %+v, got: %+v", ErrMustChangePassword, err)
+		}
+		_, _, err = Login(db, "", user_id, "abcdef")
+		if err != ErrMustChangePassword {
+			t.Errorf("expected: %+v, got: %+v", ErrMustChangePassword, err)
+		}
+		_, err = AuthUser(db, user_id, "wrong", PermissionSet{})
+		if err != ErrInvalidPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPassword, err)
+		}
+
+		err = ChangePassword(db, "", user_id, "abcdef", "qwerty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "qwerty", PermissionSet{})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUserStatus(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user_id, err := AddUser(db, 3, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := Login(db, "", user_id, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = DeactivateUser(db, user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		if err != ErrInactiveUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInactiveUser, err)
+		}
+		_, err = AuthUser(db, user_id, "654321", PermissionSet{})
+		if err != ErrInvalidPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPassword, err)
+		}
+		_, err = AuthSession(db, token, PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+
+		err = ReactivateUser(db, user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = SetUserExpiry(db, user_id, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		if err != ErrExpiredUser {
+			t.Errorf("expected: %+v, got: %+v", ErrExpiredUser, err)
+		}
+
+		err = SetUserExpiry(db, user_id, time.Now().Add(day))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUser(db, user_id, "123456", PermissionSet{})
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = SetUserExpiry(db, user_id, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.Active || !u.ExpiresAt.IsZero() {
+			t.Errorf("unexpected status: %+v", u)
+		}
+
+		err = DeactivateUser(db, 19260817)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// no records: removed
+		name := randString(8)
+		user_id, err := AddUser(db, 3, name, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = NewAPIKey(db, user_id, "test", PermissionSet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		anonymized, err := DeleteUser(db, user_id)
+		if err != nil || anonymized {
+			t.Errorf("expected user removed, got: %v, %+v", anonymized, err)
+		}
+		_, err = GetUserID(db, name)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+		_, err = DeleteUser(db, user_id)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+
+		// unreturned books: refused
+		name = randString(8)
+		user_id, err = AddUser(db, 3, name, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record_id, err := BorrowBook(db, user_id, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = DeleteUser(db, user_id)
+		if err != ErrUnreturnedBooks {
+			t.Errorf("expected: %+v, got: %+v", ErrUnreturnedBooks, err)
+		}
+
+		// borrow history: anonymized
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		anonymized, err = DeleteUser(db, user_id)
+		if err != nil || !anonymized {
+			t.Errorf("expected user anonymized, got: %v, %+v", anonymized, err)
+		}
+		_, err = GetUserID(db, name)
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+		r, err := CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Username != fmt.Sprintf("deleted-%d", user_id) {
+			t.Errorf("unexpected username: %s", r.Username)
+		}
+		_, err = AuthUser(db, r.Username, "123456", PermissionSet{})
+		if err != ErrInvalidPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPassword, err)
+		}
+	})
 }
 
 func TestCheckoutBook(t *testing.T) {
-	tb := []struct {
-		book_id int
-		isbn    string
-		title   string
-		err     error
-	}{
-		{1, "978-981-13-2971-5", "Monte Carlo Methods", nil},
-		{5, "978-3-030-33836-7", "Database Design and Implementation", nil},
-		{13, "978-3-662-53622-3", "Graph Theory", nil},
-		{26, "978-1-4939-2865-1", "Encyclopedia of Algorithms", nil},
-		{-1, "", "", ErrBookNotFound},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			book_id int
+			isbn    string
+			title   string
+			err     error
+		}{
+			{1, "978-981-13-2971-5", "Monte Carlo Methods", nil},
+			{5, "978-3-030-33836-7", "Database Design and Implementation", nil},
+			{13, "978-3-662-53622-3", "Graph Theory", nil},
+			{26, "978-1-4939-2865-1", "Encyclopedia of Algorithms", nil},
+			{-1, "", "", ErrBookNotFound},
+		}
 
-	for _, e := range tb {
-		book, err := CheckoutBook(db, e.book_id)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
-		} else if err == nil {
-			if book.Title != e.title {
-				t.Errorf("expected: %#v, got: %#v", e.title, book.Title)
-			} else if book.ISBN != e.isbn {
-				t.Errorf("expected: %#v, got: %#v", e.isbn, book.ISBN)
+		for _, e := range tb {
+			book, err := CheckoutBook(db, e.book_id)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			} else if err == nil {
+				if book.Title != e.title {
+					t.Errorf("expected: %#v, got: %#v", e.title, book.Title)
+				} else if book.ISBN != e.isbn {
+					t.Errorf("expected: %#v, got: %#v", e.isbn, book.ISBN)
+				}
 			}
 		}
-	}
+	})
 }
 
 func TestCheckoutISBN(t *testing.T) {
-	tb := []struct {
-		isbn  string
-		title string
-		err   error
-	}{
-		{"978-981-13-2971-5", "Monte Carlo Methods", nil},
-		{"978-3-030-33836-7", "Database Design and Implementation", nil},
-		{"978-3-662-53622-3", "Graph Theory", nil},
-		{"978-1-4939-2865-1", "Encyclopedia of Algorithms", nil},
-		{"978-1-4939-2865-12", "Encyclopedia of Algorithms", ErrBookNotFound},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			isbn  string
+			title string
+			err   error
+		}{
+			{"978-981-13-2971-5", "Monte Carlo Methods", nil},
+			{"978-3-030-33836-7", "Database Design and Implementation", nil},
+			{"978-3-662-53622-3", "Graph Theory", nil},
+			{"978-1-4939-2865-1", "Encyclopedia of Algorithms", nil},
+			{"978-1-4939-2865-12", "Encyclopedia of Algorithms", ErrBookNotFound},
+		}
 
-	for _, e := range tb {
-		book, err := CheckoutISBN(db, e.isbn)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
-		} else if err == nil {
-			if book.Title != e.title {
-				t.Errorf("expected: %#v, got: %#v", e.title, book.Title)
+		for _, e := range tb {
+			book, err := CheckoutISBN(db, e.isbn)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			} else if err == nil {
+				if book.Title != e.title {
+					t.Errorf("expected: %#v, got: %#v", e.title, book.Title)
+				}
 			}
 		}
-	}
+	})
 }
 
 func parseDate(val string) time.Time {
@@ -200,50 +796,107 @@ func parseDate(val string) time.Time {
 }
 
 func TestCheckoutRecord(t *testing.T) {
-	e := Record{
-		RecordID:   5,
-		UserID:     6,
-		Username:   "ayaya",
-		BookID:     1,
-		Returned:   false,
-		ReturnDate: time.Time{},
-		BorrowDate: parseDate("1926-08-17"),
-		DueDate:    parseDate("1926-09-17"),
-		FinalDate:  parseDate("2020-02-02"),
-	}
-	r, err := CheckoutRecord(db, 5)
-	if err != nil {
-		t.Error(err)
-	} else if r != e {
-		t.Errorf("expected: %+v, got: %+v", e, r)
-	}
+	forEachStore(t, func(t *testing.T) {
+		it, err := CheckoutItem(db, "1-R5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := Record{
+			RecordID:   5,
+			UserID:     6,
+			Username:   "ayaya",
+			BookID:     1,
+			ItemID:     it.ItemID,
+			Barcode:    "1-R5",
+			Returned:   false,
+			ReturnDate: time.Time{},
+			BorrowDate: parseDate("1926-08-17"),
+			DueDate:    parseDate("1926-09-17"),
+			FinalDate:  parseDate("2020-02-02"),
+		}
+		r, err := CheckoutRecord(db, 5)
+		if err != nil {
+			t.Error(err)
+		} else if r != e {
+			t.Errorf("expected: %+v, got: %+v", e, r)
+		}
 
-	_, err = CheckoutRecord(db, -1)
-	if err != ErrInvalidRecordID {
-		t.Error(err)
-	}
+		_, err = CheckoutRecord(db, -1)
+		if err != ErrInvalidRecordID {
+			t.Error(err)
+		}
+	})
 }
 
 func TestBorrowBook(t *testing.T) {
-	tb := []struct {
-		user_id int
-		book_id int
-		err     error
-	}{
-		{3, 5, nil},
-		{3, 11, ErrNoAvailableBook},
-		{3, -1, ErrInvalidBookID},
-		{6, 10, nil},
-		{7, 10, ErrSuspendedUser},
-		{4, 10, nil},
-	}
+	forEachStore(t, func(t *testing.T) {
+		tb := []struct {
+			user_id int
+			book_id int
+			err     error
+		}{
+			{3, 5, nil},
+			{3, 11, ErrNoAvailableBook},
+			{3, -1, ErrInvalidBookID},
+			{6, 10, nil},
+			{7, 10, ErrSuspendedUser},
+			{4, 10, nil},
+		}
 
-	for _, e := range tb {
-		_, err := BorrowBook(db, e.user_id, e.book_id)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
+		for _, e := range tb {
+			_, err := BorrowBook(db, e.user_id, e.book_id)
+			if !errors.Is(err, e.err) {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
 		}
-	}
+	})
+}
+
+func TestBorrowReturnCount(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		book_id := 2
+		book, err := CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := book.AvailableCount
+
+		record_id, err := BorrowBook(db, 4, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		book, err = CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.AvailableCount != count-1 {
+			t.Errorf("expected: %d, got: %d", count-1, book.AvailableCount)
+		}
+
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ReturnBook(db, record_id)
+		if err != ErrAlreadyReturned {
+			t.Errorf("expected: %+v, got: %+v", ErrAlreadyReturned, err)
+		}
+		book, err = CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.AvailableCount != count {
+			t.Errorf("expected: %d, got: %d", count, book.AvailableCount)
+		}
+
+		r, err := CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Returned || !r.ReturnDate.Equal(truncateDate(time.Now())) {
+			t.Errorf("record not returned today: %+v", r)
+		}
+	})
 }
 
 type fakeRecord struct {
@@ -257,213 +910,212 @@ type fakeRecord struct {
 }
 
 func insertFakeRecord(r fakeRecord) (int, error) {
-	result, err := db.Exec(`
-		INSERT INTO Record
-			(user_id, book_id, borrow_date, deadline, final_deadline)
-		VALUES (?, ?, ?, ?, ?)`,
-		r.user_id, r.book_id, r.borrow, r.due, r.final)
-	if err != nil {
-		return -1, err
+	record := Record{
+		UserID:     r.user_id,
+		BookID:     r.book_id,
+		BorrowDate: r.borrow,
+		DueDate:    r.due,
+		FinalDate:  r.final,
 	}
-
-	record_id, err := result.LastInsertId()
-	if err != nil {
-		return -1, err
-	}
-
 	if r.ret != nil {
-		_, err = db.Exec(`
-			UPDATE Record
-			SET
-				return_date = ?
-			WHERE record_id = ?`,
-			r.ret, record_id)
-		if err != nil {
-			return -1, err
-		}
+		record.Returned = true
+		record.ReturnDate = *r.ret
 	}
 
-	return int(record_id), nil
+	return db.InsertRecord(record)
 }
 
 func TestExtendDeadline(t *testing.T) {
-	err := ExtendDeadline(db, -1)
-	if err != ErrInvalidRecordID {
-		t.Fatalf("expected <invalid record id>, got: %+v", err)
-	}
-
-	today := time.Now()
-	tb := []fakeRecord{
-		{8, 5, nil, today, today.Add(day), today.Add(day + month), nil},
-		{8, 5, &today, today, today, today, ErrAlreadyReturned},
-		{8, 5, nil, today.Add(-2 * day), today.Add(-day), today.Add(3 * month), ErrOverdue},
-		{8, 5, nil, today, today.Add(month), today.Add(month * 2), ErrNotExtensible},
-		{8, 5, nil, today, today.Add(day), today.Add(week), ErrFinalDeadline},
-	}
+	forEachStore(t, func(t *testing.T) {
+		err := ExtendDeadline(db, -1)
+		if err != ErrInvalidRecordID {
+			t.Fatalf("expected <invalid record id>, got: %+v", err)
+		}
 
-	for _, e := range tb {
-		record_id, err := insertFakeRecord(e)
-		if err != nil {
-			t.Fatal(err)
+		today := time.Now()
+		tb := []fakeRecord{
+			{8, 5, nil, today, today.Add(day), today.Add(day + month), nil},
+			{8, 5, &today, today, today, today, ErrAlreadyReturned},
+			{8, 5, nil, today.Add(-2 * day), today.Add(-day), today.Add(3 * month), ErrOverdue},
+			{8, 5, nil, today, today.Add(month), today.Add(month * 2), ErrNotExtensible},
+			{8, 5, nil, today, today.Add(day), today.Add(week), ErrFinalDeadline},
 		}
 
-		err = ExtendDeadline(db, record_id)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
+		for _, e := range tb {
+			record_id, err := insertFakeRecord(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ExtendDeadline(db, record_id)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
 		}
-	}
+	})
 }
 
 func TestReturnBook(t *testing.T) {
-	err := ReturnBook(db, -1)
-	if err != ErrInvalidRecordID {
-		t.Fatalf("expected <invalid record id>, got: %+v", err)
-	}
-
-	today := time.Now()
-	tb := []fakeRecord{
-		{8, 5, nil, today, today.Add(day), today.Add(day + month), nil},
-		{8, 5, &today, today, today, today, ErrAlreadyReturned},
-		{8, 5, nil, today.Add(-2 * day), today.Add(-day), today.Add(3 * month), nil},
-		{8, 5, nil, today, today.Add(month), today.Add(month * 2), nil},
-		{8, 5, nil, today, today.Add(day), today.Add(week), nil},
-	}
+	forEachStore(t, func(t *testing.T) {
+		err := ReturnBook(db, -1)
+		if err != ErrInvalidRecordID {
+			t.Fatalf("expected <invalid record id>, got: %+v", err)
+		}
 
-	for _, e := range tb {
-		record_id, err := insertFakeRecord(e)
-		if err != nil {
-			t.Fatal(err)
+		today := time.Now()
+		tb := []fakeRecord{
+			{8, 5, nil, today, today.Add(day), today.Add(day + month), nil},
+			{8, 5, &today, today, today, today, ErrAlreadyReturned},
+			{8, 5, nil, today.Add(-2 * day), today.Add(-day), today.Add(3 * month), nil},
+			{8, 5, nil, today, today.Add(month), today.Add(month * 2), nil},
+			{8, 5, nil, today, today.Add(day), today.Add(week), nil},
 		}
 
-		err = ReturnBook(db, record_id)
-		if err != e.err {
-			t.Errorf("expected: %+v, got: %+v", e.err, err)
+		for _, e := range tb {
+			record_id, err := insertFakeRecord(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ReturnBook(db, record_id)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
 		}
-	}
+	})
 }
 
 func TestNewBook(t *testing.T) {
-	book_id, err := NewBook(db)
-	if err != nil {
-		t.Error(err)
-	}
-	if book_id <= 1 {
-		t.Error("invalid book id")
-	}
+	forEachStore(t, func(t *testing.T) {
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Error(err)
+		}
+		if book_id <= 1 {
+			t.Error("invalid book id")
+		}
+	})
 }
 
 func TestUpdateBook(t *testing.T) {
-	err := UpdateBook(db, -1, 0, BookInfo{})
-	if err != ErrInvalidBookID {
-		t.Fatal(err)
-	}
+	forEachStore(t, func(t *testing.T) {
+		err := UpdateBook(db, -1, 0, BookInfo{})
+		if err != ErrInvalidBookID {
+			t.Fatal(err)
+		}
 
-	book_id, err := NewBook(db)
-	if err != nil {
-		t.Fatal(err)
-	}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	author := "ayaya"
-	title := "a nice book"
-	text := "naive"
-	no_desc := "(no description)"
-	isbn := randString(8)
-	count := 998
-	err = UpdateBook(db, book_id, count, BookInfo{
-		Author:  &author,
-		Comment: &text,
-		Title:   &title,
-		ISBN:    &isbn,
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
+		author := "ayaya"
+		title := "a nice book"
+		text := "naive"
+		no_desc := "(no description)"
+		isbn := randISBN()
+		count := 998
+		err = UpdateBook(db, book_id, count, BookInfo{
+			Author:  &author,
+			Comment: &text,
+			Title:   &title,
+			ISBN:    &isbn,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	book, err := CheckoutBook(db, book_id)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if book.Author != author {
-		t.Errorf("expected: %#v, got: %#v", author, book.Author)
-	}
-	if book.AvailableCount != count {
-		t.Errorf("expected: %#v, got: %#v", count, book.AvailableCount)
-	}
-	if book.Comment != text {
-		t.Errorf("expected: %#v, got: %#v", text, book.Comment)
-	}
-	if book.Description != no_desc {
-		t.Errorf("expected: %#v, got: %#v", no_desc, book.Description)
-	}
-	if book.ISBN != isbn {
-		t.Errorf("expected: %#v, got: %#v", isbn, book.ISBN)
-	}
-	if book.Title != title {
-		t.Errorf("expected: %#v, got: %#v", title, book.Title)
-	}
+		book, err := CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Author != author {
+			t.Errorf("expected: %#v, got: %#v", author, book.Author)
+		}
+		if book.AvailableCount != count {
+			t.Errorf("expected: %#v, got: %#v", count, book.AvailableCount)
+		}
+		if book.Comment != text {
+			t.Errorf("expected: %#v, got: %#v", text, book.Comment)
+		}
+		if book.Description != no_desc {
+			t.Errorf("expected: %#v, got: %#v", no_desc, book.Description)
+		}
+		if book.ISBN != isbn {
+			t.Errorf("expected: %#v, got: %#v", isbn, book.ISBN)
+		}
+		if book.Title != title {
+			t.Errorf("expected: %#v, got: %#v", title, book.Title)
+		}
+	})
 }
 
 func TestSearchBookByTitle(t *testing.T) {
-	list, err := SearchBookByTitle(db, "gRaPh")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if len(list) != 13 {
-		t.Fatalf("incorrect length! expected: 13, got: %d", len(list))
-	}
+	forEachStore(t, func(t *testing.T) {
+		list, err := SearchBookByTitle(db, "gRaPh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 13 {
+			t.Fatalf("incorrect length! expected: 13, got: %d", len(list))
+		}
+	})
 }
 
 func TestSearchBookByAuthor(t *testing.T) {
-	list, err := SearchBookByAuthor(db, "diestel")
-	if err != nil {
-		t.Fatal(err)
-	}
+	forEachStore(t, func(t *testing.T) {
+		list, err := SearchBookByAuthor(db, "diestel")
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	if len(list) != 1 {
-		t.Fatalf("expect only one book, got: %d", len(list))
-	}
+		if len(list) != 1 {
+			t.Fatalf("expect only one book, got: %d", len(list))
+		}
 
-	book_id := 13
-	book_title := "Graph Theory"
-	book := list[0]
-	if book.BookID != book_id {
-		t.Fatalf("incorrect book ID. expected: %d, got: %d", book_id, book.BookID)
-	}
-	if book.Title != book_title {
-		t.Fatalf("incorrect book title. expected: %#v, got: %#v", book_title, book.Title)
-	}
+		book_id := 13
+		book_title := "Graph Theory"
+		book := list[0]
+		if book.BookID != book_id {
+			t.Fatalf("incorrect book ID. expected: %d, got: %d", book_id, book.BookID)
+		}
+		if book.Title != book_title {
+			t.Fatalf("incorrect book title. expected: %#v, got: %#v", book_title, book.Title)
+		}
+	})
 }
 
 func TestCheckoutHistory(t *testing.T) {
-	today := time.Now()
-	tb := []struct {
-		user_id int
-		limit   int
-		filter  string
-		args    []interface{}
-		id_list []int
-	}{
-		{9, 100, "", nil, []int{4, 3, 2, 1}},
-		{9, 2, "", nil, []int{4, 3}},
-		{9, 100, "return_date IS NULL", nil, []int{2, 1}},
-		{9, 100, "return_date IS NULL AND deadline < ?", []interface{}{today}, []int{2}},
-		{9, 100, "return_date IS NOT NULL", nil, []int{4, 3}},
-		{9, 100, "deadline < ?", []interface{}{today}, []int{4, 2}},
-		{9, 100, "return_date IS NOT NULL AND deadline < ?", []interface{}{today}, []int{4}},
-	}
+	forEachStore(t, func(t *testing.T) {
+		today := time.Now()
+		tb := []struct {
+			user_id int
+			limit   int
+			filter  RecordFilter
+			id_list []int
+		}{
+			{9, 100, RecordFilter{}, []int{4, 3, 2, 1}},
+			{9, 2, RecordFilter{}, []int{4, 3}},
+			{9, 100, RecordFilter{NotReturned: true}, []int{2, 1}},
+			{9, 100, RecordFilter{NotReturned: true, DueBefore: today}, []int{2}},
+			{9, 100, RecordFilter{Returned: true}, []int{4, 3}},
+			{9, 100, RecordFilter{DueBefore: today}, []int{4, 2}},
+			{9, 100, RecordFilter{Returned: true, DueBefore: today}, []int{4}},
+		}
 
-	for _, e := range tb {
-		list, err := CheckoutHistory(db, e.user_id, e.limit, e.filter, e.args...)
-		if err != nil {
-			t.Error(err)
-		} else if len(list) != len(e.id_list) {
-			t.Errorf("incorrect list length. expected: %d, got: %d", len(e.id_list), len(list))
-		} else {
-			for i, r := range list {
-				if e.id_list[i] != r.RecordID {
-					t.Errorf("incorrect record ID at index %d. expected: %d, got: %d", i, e.id_list[i], r.RecordID)
+		for _, e := range tb {
+			list, err := CheckoutHistory(db, e.user_id, e.limit, e.filter)
+			if err != nil {
+				t.Error(err)
+			} else if len(list) != len(e.id_list) {
+				t.Errorf("incorrect list length. expected: %d, got: %d", len(e.id_list), len(list))
+			} else {
+				for i, r := range list {
+					if e.id_list[i] != r.RecordID {
+						t.Errorf("incorrect record ID at index %d. expected: %d, got: %d", i, e.id_list[i], r.RecordID)
+					}
 				}
 			}
 		}
-	}
+	})
 }
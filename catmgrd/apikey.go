@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// API keys are long-lived tokens for service accounts and scripts. A
+// key belongs to a user and carries a scope, i.e. a subset of the
+// permissions of its user. Keys are sent in the "Authorization: Bearer"
+// header like session tokens, and are told apart by `apiKeyPrefix`.
+// Only SHA-256 hashes of keys are stored, in table ApiKey.
+const apiKeyPrefix = "cmk_"
+const apiKeyLen = 32
+
+var ErrInvalidAPIKey = errors.New("invalid or revoked API key")
+var ErrInvalidScope = errors.New("scope exceeds permissions of the key owner")
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// `NewAPIKey` creates an API key named `name` for `user_id`, restricted
+// to `scope`. Returns the key, which is not recoverable afterwards.
+//
+// May return `ErrInvalidUser`, `ErrUnknownPermission` or
+// `ErrInvalidScope`.
+func NewAPIKey(db Store, user_id int, name string, scope PermissionSet) (string, APIKey, error) {
+	err := scope.Validate()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	scope = NewPermissionSet(scope...)
+
+	u, err := db.UserByID(user_id)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	if !u.Perm.Contains(scope) {
+		return "", APIKey{}, ErrInvalidScope
+	}
+
+	token, err := newToken(apiKeyLen)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	key := apiKeyPrefix + token
+
+	k := APIKey{
+		UserID:    user_id,
+		Name:      name,
+		KeyHash:   hashToken(key),
+		Scope:     scope,
+		CreatedAt: time.Now().UTC(),
+	}
+	k.KeyID, err = db.InsertAPIKey(k)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	return key, k, nil
+}
+
+// `AuthAPIKey` checks `key` and returns its user. Requested permissions
+// `req` must be granted by both the scope of the key and the user, and
+// `Perm` of the returned user is limited to the scope of the key.
+// Last used time of the key is updated on success. Keys of users who
+// must change their passwords are refused, as passwords are.
+//
+// May return `ErrInvalidAPIKey`, `ErrInactiveUser`, `ErrExpiredUser`,
+// `ErrMustChangePassword` or `ErrPermissionDenied`.
+func AuthAPIKey(db Store, key string, req PermissionSet) (User, error) {
+	k, err := db.APIKeyByHash(hashToken(key))
+	if err != nil {
+		return User{}, err
+	}
+	if k.Revoked {
+		return User{}, ErrInvalidAPIKey
+	}
+
+	u, err := db.UserByID(k.UserID)
+	if err == ErrInvalidUser {
+		return User{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return User{}, err
+	}
+	err = checkUserStatus(u, time.Now())
+	if err != nil {
+		return User{}, err
+	}
+	if u.MustChange {
+		return User{}, ErrMustChangePassword
+	}
+
+	u.Perm = u.Perm.Intersect(k.Scope)
+	if !u.Perm.Contains(req) {
+		return User{}, ErrPermissionDenied
+	}
+
+	err = db.TouchAPIKey(k.KeyID, time.Now())
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// `CheckKeyOwner` checks that `admin` may manage API keys of user
+// `owner_id`. Key managers manage their own keys, and keys of users they
+// could manage with `PermUserManage` (see `CheckGrant`).
+//
+// May return `ErrInvalidUser`, `ErrPermissionDenied` or
+// `ErrPrivilegeEscalation`.
+func CheckKeyOwner(db Store, admin User, owner_id int) error {
+	if owner_id == admin.UserID {
+		return nil
+	}
+	if !admin.Perm.Has(PermUserManage) {
+		return ErrPermissionDenied
+	}
+
+	owner, err := db.UserByID(owner_id)
+	if err != nil {
+		return err
+	}
+	return CheckGrant(admin, owner.Perm)
+}
+
+// `ListAPIKeys` returns API keys of `user_id`, or of all users whose
+// keys `admin` may manage if `user_id` is 0. Revoked keys are included.
+//
+// May return the errors of `CheckKeyOwner` for `user_id`.
+func ListAPIKeys(db Store, admin User, user_id int) ([]APIKey, error) {
+	if user_id != 0 {
+		err := CheckKeyOwner(db, admin, user_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list, err := db.ListAPIKeys(user_id)
+	if err != nil || user_id != 0 {
+		return list, err
+	}
+
+	allowed := map[int]bool{}
+	result := []APIKey{}
+	for _, k := range list {
+		ok, checked := allowed[k.UserID]
+		if !checked {
+			err := CheckKeyOwner(db, admin, k.UserID)
+			if err != nil && err != ErrInvalidUser &&
+				err != ErrPermissionDenied && err != ErrPrivilegeEscalation {
+				return nil, err
+			}
+			ok = err == nil
+			allowed[k.UserID] = ok
+		}
+		if ok {
+			result = append(result, k)
+		}
+	}
+	return result, nil
+}
+
+// `RevokeAPIKey` disables the key with `key_id` permanently, if `admin`
+// may manage keys of its owner.
+//
+// May return `ErrInvalidAPIKey`, `ErrPermissionDenied` or
+// `ErrPrivilegeEscalation`.
+func RevokeAPIKey(db Store, admin User, key_id int) error {
+	return db.Atomic(func(db Store) error {
+		k, err := db.APIKeyByID(key_id)
+		if err != nil {
+			return err
+		}
+		err = CheckKeyOwner(db, admin, k.UserID)
+		if err != nil {
+			return err
+		}
+		return db.RevokeAPIKey(key_id)
+	})
+}
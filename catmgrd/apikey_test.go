@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		// admin cannot borrow books
+		_, _, err := NewAPIKey(db, 2, "kiosk", PermissionSet{PermRecordBorrow})
+		if err != ErrInvalidScope {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidScope, err)
+		}
+		_, _, err = NewAPIKey(db, 233, "kiosk", PermissionSet{})
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+		_, _, err = NewAPIKey(db, 1, "kiosk", PermissionSet{"book.burn"})
+		if err != ErrUnknownPermission {
+			t.Errorf("expected: %+v, got: %+v", ErrUnknownPermission, err)
+		}
+
+		key, k, err := NewAPIKey(db, 1, "kiosk", NewPermissionSet(PermRecordBorrow, PermRecordInspectAny))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(key, apiKeyPrefix) || k.KeyHash == key {
+			t.Errorf("unexpected key: %s, %+v", key, k)
+		}
+
+		tb := []struct {
+			req PermissionSet
+			err error
+		}{
+			{PermissionSet{}, nil},
+			{PermissionSet{PermRecordBorrow}, nil},
+			{NewPermissionSet(PermRecordBorrow, PermRecordInspectAny), nil},
+			{PermissionSet{PermBookUpdate}, ErrPermissionDenied},
+			{NewPermissionSet(PermUserCreate, PermRecordBorrow), ErrPermissionDenied},
+		}
+		for _, e := range tb {
+			u, err := AuthAPIKey(db, key, e.req)
+			if err != e.err {
+				t.Errorf("%+v: expected: %+v, got: %+v", e.req, e.err, err)
+			} else if err == nil && u.UserID != 1 {
+				t.Errorf("expected user 1, got %d", u.UserID)
+			} else if err == nil && !reflect.DeepEqual(u.Perm, k.Scope) {
+				t.Errorf("permissions not limited to scope: %v", u.Perm)
+			}
+		}
+
+		_, err = AuthAPIKey(db, key+"x", PermissionSet{})
+		if err != ErrInvalidAPIKey {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidAPIKey, err)
+		}
+
+		root, err := db.UserByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := ListAPIKeys(db, root, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, e := range list {
+			if e.KeyID == k.KeyID {
+				found = true
+				if e.Name != "kiosk" || !reflect.DeepEqual(e.Scope, k.Scope) || !e.Used || e.Revoked {
+					t.Errorf("unexpected key: %+v", e)
+				}
+			}
+			if e.UserID != 1 {
+				t.Errorf("key of user %d is listed", e.UserID)
+			}
+		}
+		if !found {
+			t.Errorf("key %d not listed", k.KeyID)
+		}
+
+		err = RevokeAPIKey(db, root, k.KeyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthAPIKey(db, key, PermissionSet{})
+		if err != ErrInvalidAPIKey {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidAPIKey, err)
+		}
+		err = RevokeAPIKey(db, root, 19260817)
+		if err != ErrInvalidAPIKey {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidAPIKey, err)
+		}
+	})
+}
+
+func TestAPIKeyOwner(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		root, err := db.UserByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admin, err := db.UserByID(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type_id, err := NewUserType(db, UserType{TypeName: randString(8), Perm: PermissionSet{PermAPIKeyManage}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user_id, err := AddUser(db, type_id, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keeper, err := db.UserByID(user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tb := []struct {
+			admin User
+			owner int
+			err   error
+		}{
+			{root, 2, nil},
+			{admin, 2, nil},
+			{admin, 5, nil},
+			{admin, 3, ErrPrivilegeEscalation},
+			{admin, 1, ErrPrivilegeEscalation},
+			{admin, 233, ErrInvalidUser},
+			{keeper, user_id, nil},
+			{keeper, 3, ErrPermissionDenied},
+		}
+		for _, e := range tb {
+			err := CheckKeyOwner(db, e.admin, e.owner)
+			if err != e.err {
+				t.Errorf("%d for %d: expected: %+v, got: %+v", e.admin.UserID, e.owner, e.err, err)
+			}
+		}
+
+		// admin can neither see nor revoke keys of root
+		_, k, err := NewAPIKey(db, 1, "root", PermissionSet{PermBookCreate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ListAPIKeys(db, admin, 1)
+		if err != ErrPrivilegeEscalation {
+			t.Errorf("expected: %+v, got: %+v", ErrPrivilegeEscalation, err)
+		}
+		list, err := ListAPIKeys(db, admin, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range list {
+			if e.UserID == 1 {
+				t.Errorf("key of root is listed: %+v", e)
+			}
+		}
+		err = RevokeAPIKey(db, admin, k.KeyID)
+		if err != ErrPrivilegeEscalation {
+			t.Errorf("expected: %+v, got: %+v", ErrPrivilegeEscalation, err)
+		}
+		err = RevokeAPIKey(db, keeper, k.KeyID)
+		if err != ErrPermissionDenied {
+			t.Errorf("expected: %+v, got: %+v", ErrPermissionDenied, err)
+		}
+		k, err = db.APIKeyByID(k.KeyID)
+		if err != nil || k.Revoked {
+			t.Errorf("unexpected key: %+v (%v)", k, err)
+		}
+
+		_, k, err = NewAPIKey(db, user_id, "own", PermissionSet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = RevokeAPIKey(db, keeper, k.KeyID)
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+	})
+}
+
+func TestAPIKeyMustChange(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		name := randString(8)
+		user_id, err := AddUser(db, 3, name, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _, err := NewAPIKey(db, user_id, "kiosk", PermissionSet{PermRecordBorrow})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Keys do not get around a forced password change
+		err = ResetPassword(db, user_id, "654321", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthAPIKey(db, key, PermissionSet{})
+		if err != ErrMustChangePassword {
+			t.Errorf("expected: %+v, got: %+v", ErrMustChangePassword, err)
+		}
+
+		err = ChangePassword(db, "", name, "654321", "abcdef")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthAPIKey(db, key, PermissionSet{})
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Branches are the places where copies are kept, borrowed and
+// returned. Every item belongs to a home branch. A copy returned at
+// another branch is in transit, and is not available until received
+// back at its home branch by `ReceiveItem`.
+
+// `DefaultBranchID` is the branch created by migration 0013, which
+// existing items, and copies added by `UpdateBook`, belong to.
+const DefaultBranchID = 1
+
+// `MaxBranchName` is the size of the name column.
+const MaxBranchName = 64
+
+var ErrInvalidBranchID = errors.New("invalid branch ID")
+var ErrInvalidBranchName = errors.New("invalid branch name")
+var ErrDuplicateBranch = errors.New("branch name already in use")
+var ErrNotInTransit = errors.New("item is not in transit")
+
+// `NewBranch` adds a branch named `name`, and returns its ID.
+//
+// May return `ErrInvalidBranchName` or `ErrDuplicateBranch`.
+func NewBranch(db Store, name string) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxBranchName {
+		return -1, ErrInvalidBranchName
+	}
+
+	branch_id := -1
+	err := db.Atomic(func(db Store) error {
+		list, err := db.ListBranches()
+		if err != nil {
+			return err
+		}
+		for _, b := range list {
+			if b.Name == name {
+				return ErrDuplicateBranch
+			}
+		}
+
+		branch_id, err = db.InsertBranch(name)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return branch_id, nil
+}
+
+// `ListBranches` returns all branches ordered by branch ID.
+func ListBranches(db Store) ([]Branch, error) {
+	return db.ListBranches()
+}
+
+// `checkBranch` returns `ErrInvalidBranchID` if no branch has
+// `branch_id`.
+func checkBranch(db Store, branch_id int) error {
+	_, err := db.BranchByID(branch_id)
+	return err
+}
+
+// `ReceiveItem` puts the copy with `barcode`, which is in transit, back
+// on the shelf of its home branch. The copy goes to the next holder of
+// the book if any.
+//
+// May return `ErrInvalidBarcode` or `ErrNotInTransit`.
+func ReceiveItem(db Store, barcode string) error {
+	return db.Atomic(func(db Store) error {
+		it, err := db.ItemByBarcode(barcode)
+		if err != nil {
+			return err
+		}
+		if it.Status != ItemInTransit {
+			return ErrNotInTransit
+		}
+		return releaseItem(db, it.ItemID, it.BookID, time.Now())
+	})
+}
+
+// `fillBranches` fills `Branches` of `books`.
+func fillBranches(db Store, books []Book) error {
+	for i := range books {
+		var err error
+		books[i].Branches, err = db.BranchCounts(books[i].BookID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBranch(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		name := "B" + randString(8)
+		branch_id, err := NewBranch(db, " "+name+" ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := ListBranches(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list[0].BranchID != DefaultBranchID ||
+			list[len(list)-1] != (Branch{branch_id, name}) {
+			t.Errorf("unexpected branches: %+v", list)
+		}
+
+		tb := []struct {
+			name string
+			err  error
+		}{
+			{name, ErrDuplicateBranch},
+			{"  ", ErrInvalidBranchName},
+			{strings.Repeat("x", MaxBranchName+1), ErrInvalidBranchName},
+		}
+		for _, e := range tb {
+			_, err = NewBranch(db, e.name)
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
+		}
+
+		user_id, err := AddUser(db, 3, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := AddItem(db, book_id, branch_id, "", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.BranchID != branch_id || it.Barcode != fmt.Sprintf("%d-2", book_id) {
+			t.Errorf("unexpected item: %+v", it)
+		}
+		_, err = AddItem(db, book_id, -1, "", time.Now())
+		if err != ErrInvalidBranchID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBranchID, err)
+		}
+
+		expectCounts := func(main, other int) {
+			t.Helper()
+			b, err := CheckoutBook(db, book_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []BranchCount{
+				{DefaultBranchID, "main", main},
+				{branch_id, name, other},
+			}
+			if !reflect.DeepEqual(b.Branches, expected) || b.AvailableCount != main+other {
+				t.Errorf("expected: %+v, got: %+v (%d)", expected, b.Branches, b.AvailableCount)
+			}
+		}
+		expectCounts(1, 1)
+
+		// Copies are borrowed where they are kept
+		record_id, err := BorrowBookAt(db, user_id, book_id, branch_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ItemID != it.ItemID || r.BorrowBranchID != branch_id {
+			t.Errorf("unexpected record: %+v", r)
+		}
+		expectCounts(1, 0)
+
+		_, err = BorrowBookAt(db, user_id, book_id, branch_id)
+		if err != ErrNoAvailableBook {
+			t.Errorf("expected: %+v, got: %+v", ErrNoAvailableBook, err)
+		}
+		_, err = BorrowBookAt(db, user_id, book_id, -1)
+		if err != ErrInvalidBranchID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBranchID, err)
+		}
+		err = ReturnBookAt(db, record_id, -1)
+		if err != ErrInvalidBranchID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBranchID, err)
+		}
+
+		// Copies returned elsewhere are in transit until received
+		err = ReturnBookAt(db, record_id, DefaultBranchID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Returned || r.ReturnBranchID != DefaultBranchID {
+			t.Errorf("unexpected record: %+v", r)
+		}
+		it, err = CheckoutItem(db, it.Barcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.Status != ItemInTransit {
+			t.Errorf("expected: %s, got: %s", ItemInTransit, it.Status)
+		}
+		expectCounts(1, 0)
+
+		err = SetItemStatus(db, it.Barcode, ItemLost)
+		if err != ErrItemInUse {
+			t.Errorf("expected: %+v, got: %+v", ErrItemInUse, err)
+		}
+		err = ReceiveItem(db, it.Barcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCounts(1, 1)
+		err = ReceiveItem(db, it.Barcode)
+		if err != ErrNotInTransit {
+			t.Errorf("expected: %+v, got: %+v", ErrNotInTransit, err)
+		}
+		err = ReceiveItem(db, "nonexistent")
+		if err != ErrInvalidBarcode {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBarcode, err)
+		}
+
+		// Copies returned at home are put back on the shelf
+		record_id, err = BorrowBookAt(db, user_id, book_id, branch_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ReturnBranchID != branch_id {
+			t.Errorf("unexpected record: %+v", r)
+		}
+		expectCounts(1, 1)
+	})
+}
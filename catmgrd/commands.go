@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// `runCommand` runs subcommand `args[0]` with its arguments.
+func runCommand(s *SQLStore, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return cmdMigrate(s, args[1:])
+	case "import":
+		return cmdImport(s, args[1:])
+	case "export":
+		return cmdExport(s, args[1:])
+	default:
+		return fmt.Errorf("unknown command: %#v", args[0])
+	}
+}
+
+// `cmdMigrate` implements:
+//
+//	catmgrd migrate up [version]
+//	catmgrd migrate down [version]
+//	catmgrd migrate status
+//
+// "up" applies pending migrations up to `version` (default: latest).
+// "down" reverts migrations newer than `version` (default: only the
+// last applied one).
+func cmdMigrate(s *SQLStore, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: catmgrd migrate up|down|status [version]")
+	}
+
+	target := -1
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version: %#v", args[1])
+		}
+		target = v
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := s.MigrateUp(target)
+		for _, m := range done {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+			for _, note := range m.Notes {
+				fmt.Printf("  %s\n", note)
+			}
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("database schema is up to date")
+		}
+		return err
+
+	case "down":
+		if target < 0 {
+			current, err := s.SchemaVersion()
+			if err != nil {
+				return err
+			}
+
+			status, err := s.MigrationStatus()
+			if err != nil {
+				return err
+			}
+			target = 0
+			for _, m := range status {
+				if m.Applied && m.Version < current {
+					target = m.Version
+				}
+			}
+		}
+
+		done, err := s.MigrateDown(target)
+		for _, m := range done {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to revert")
+		}
+		return err
+
+	case "status":
+		status, err := s.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, m := range status {
+			if m.Applied {
+				fmt.Fprintf(w, "%04d\t%s\tapplied\t%s\n",
+					m.Version, m.Name, m.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, "%04d\t%s\tpending\t\n", m.Version, m.Name)
+			}
+		}
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command: %#v", args[0])
+	}
+}
+
+// `cmdImport` implements:
+//
+//	catmgrd import [-format csv|ndjson|json] [-on-conflict skip|update] [-dry-run] file
+//
+// The format is guessed from the extension of `file` if not given, and
+// "-" reads standard input. Invalid rows are printed, and nothing is
+// imported if there are any.
+func cmdImport(s *SQLStore, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "\"csv\", \"ndjson\" or \"json\" (default: by file extension)")
+	on_conflict := fs.String("on-conflict", ConflictSkip, "\"skip\" or \"update\" books with existing ISBNs")
+	dry_run := fs.Bool("dry-run", false, "check and count rows without importing")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: catmgrd import [-format csv|ndjson|json] [-on-conflict skip|update] [-dry-run] file")
+	}
+
+	_, _, err = s.CheckSchema()
+	if err != nil {
+		return fmt.Errorf("%v. run \"catmgrd migrate up\" first", err)
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		switch filepath.Ext(path) {
+		case ".csv":
+			*format = ImportCSV
+		case ".ndjson", ".jsonl":
+			*format = ImportNDJSON
+		case ".json":
+			*format = ImportJSON
+		default:
+			return fmt.Errorf("unknown format of %#v, use -format", path)
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	opts := ImportOptions{*format, *on_conflict, *dry_run}
+	result, err := ImportBooks(s, r, opts)
+	for _, e := range result.Errors {
+		fmt.Printf("%s:%d: %s\n", path, e.Line, e.Msg)
+	}
+	if err == ErrInvalidImport {
+		return fmt.Errorf("%v: %d invalid row(s), nothing imported", err, len(result.Errors))
+	}
+
+	verb := "imported"
+	if *dry_run {
+		verb = "would import"
+	}
+	fmt.Printf("%s: %d created, %d updated, %d skipped\n",
+		verb, result.Created, result.Updated, result.Skipped)
+	if err == nil && !*dry_run && result.Updated > 0 {
+		fmt.Println("send SIGHUP to a running catmgrd (e.g. pkill -HUP catmgrd) to search updated books")
+	}
+	return err
+}
+
+// `cmdExport` implements:
+//
+//	catmgrd export [-format csv|ndjson|json] [-section section -keyword keyword] [file]
+//
+// Books are written to `file`, or standard output if it is "-" or not
+// given. Sections are those of "/show".
+func cmdExport(s *SQLStore, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "\"csv\", \"ndjson\" or \"json\" (default: by file extension, or csv)")
+	section := fs.String("section", "", "export books found in \"title\", \"author\", \"fulltext\" or \"query\"")
+	keyword := fs.String("keyword", "", "keyword of -section")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: catmgrd export [-format csv|ndjson|json] [-section section -keyword keyword] [file]")
+	}
+
+	_, _, err = s.CheckSchema()
+	if err != nil {
+		return fmt.Errorf("%v. run \"catmgrd migrate up\" first", err)
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		switch filepath.Ext(path) {
+		case ".ndjson", ".jsonl":
+			*format = ExportNDJSON
+		case ".json":
+			*format = ExportJSON
+		default:
+			*format = ExportCSV
+		}
+	}
+
+	export, err := NewBookExport(s, *format, ExportFilter{*section, *keyword})
+	if err != nil {
+		return err
+	}
+
+	if path == "" || path == "-" {
+		_, err = export.Write(os.Stdout)
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := export.Write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d book(s) to %s\n", n, path)
+	return f.Close()
+}
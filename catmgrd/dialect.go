@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// `Dialect` hides the differences among SQL databases supported by
+// `SQLStore`. Queries in `SQLStore` are written in MySQL flavor, i.e.
+// with "?" placeholders and unquoted table names, and are rewritten
+// by `Rebind` before execution.
+type Dialect interface {
+	// `Name` is the driver name passed to `sql.Open`.
+	Name() string
+	// `DSN` builds the data source name from `config`.
+	DSN(config DatabaseConfig) string
+	// `Rebind` rewrites `query` for this dialect.
+	Rebind(query string) string
+	// `Returning` reports whether new IDs are obtained by
+	// "INSERT ... RETURNING" rather than `LastInsertId`.
+	Returning() bool
+	// `ILike` is the case-insensitive LIKE operator.
+	ILike() string
+}
+
+// `GetDialect` returns the dialect of `driver`. An empty `driver`
+// means "mysql".
+func GetDialect(driver string) (Dialect, error) {
+	switch driver {
+	case "", "mysql":
+		return mysqlDialect{}, nil
+	case "sqlite":
+		return sqliteDialect{}, nil
+	case "postgres":
+		return postgresDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver: %#v", driver)
+	}
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) DSN(config DatabaseConfig) string {
+	return fmt.Sprintf("%s:%s@%s(%s:%d)/%s?parseTime=true",
+		config.Username, config.Password,
+		config.Protocol, config.Address,
+		config.Port, config.Database)
+}
+
+func (mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+func (mysqlDialect) Returning() bool {
+	return false
+}
+
+// LIKE is case-insensitive with default collations in MySQL.
+func (mysqlDialect) ILike() string {
+	return "LIKE"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+// Foreign key constraints are turned on for every connection.
+func (sqliteDialect) DSN(config DatabaseConfig) string {
+	return fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite", config.File)
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) Returning() bool {
+	return false
+}
+
+// LIKE is case-insensitive for ASCII characters in SQLite.
+func (sqliteDialect) ILike() string {
+	return "LIKE"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+// Session time zone is set to UTC, so that dates are not shifted when
+// timestamps are converted to DATE.
+func (postgresDialect) DSN(config DatabaseConfig) string {
+	quote := func(v string) string {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		v = strings.ReplaceAll(v, `'`, `\'`)
+		return "'" + v + "'"
+	}
+
+	ssl_mode := config.SSLMode
+	if ssl_mode == "" {
+		ssl_mode = "disable"
+	}
+
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s timezone=UTC",
+		quote(config.Address), config.Port,
+		quote(config.Username), quote(config.Password),
+		quote(config.Database), quote(ssl_mode))
+}
+
+// `Rebind` replaces "?" with "$1", "$2", ... and quotes table name
+// `User`, which is a reserved word in PostgreSQL. String literals and
+// quoted identifiers are left untouched.
+func (postgresDialect) Rebind(query string) string {
+	var buf strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(query[i+1:], c)
+			if j < 0 {
+				buf.WriteString(query[i:])
+				return buf.String()
+			}
+			buf.WriteString(query[i : i+j+2])
+			i += j + 1
+		case c == '?':
+			n++
+			buf.WriteByte('$')
+			buf.WriteString(strconv.Itoa(n))
+		case strings.HasPrefix(query[i:], "User") &&
+			(i == 0 || !isIdentByte(query[i-1])) &&
+			(i+4 == len(query) || !isIdentByte(query[i+4])):
+			buf.WriteString(`"User"`)
+			i += 3
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (postgresDialect) Returning() bool {
+	return true
+}
+
+func (postgresDialect) ILike() string {
+	return "ILIKE"
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPostgresRebind(t *testing.T) {
+	tb := []struct {
+		query  string
+		expect string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM Book WHERE book_id = ?", "SELECT * FROM Book WHERE book_id = $1"},
+		{"INSERT INTO User (type_id, name, token) VALUES (?, ?, ?)",
+			`INSERT INTO "User" (type_id, name, token) VALUES ($1, $2, $3)`},
+		{"FROM Record JOIN User USING (user_id) WHERE user_id = ?",
+			`FROM Record JOIN "User" USING (user_id) WHERE user_id = $1`},
+		{"SELECT type_id FROM UserType WHERE type_name = ?",
+			"SELECT type_id FROM UserType WHERE type_name = $1"},
+		{"SELECT COALESCE(title, 'User?') FROM Book WHERE title = ?",
+			"SELECT COALESCE(title, 'User?') FROM Book WHERE title = $1"},
+		{`SELECT "User".name FROM User`, `SELECT "User".name FROM "User"`},
+	}
+
+	for _, e := range tb {
+		got := postgresDialect{}.Rebind(e.query)
+		if got != e.expect {
+			t.Errorf("expected: %#v, got: %#v", e.expect, got)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The catalog is exported as CSV, JSON Lines (NDJSON) or a JSON
+// snapshot, with columns or keys in the order of `importColumns`, so
+// that exports can be imported again (see import.go). `count` is the
+// number of copies in circulation, including those on loan, reserved
+// or in transit, which are all available once imported again. Missing
+// values are empty. Books are read and written in batches of
+// `exportBatchSize`, so exports of any size take little memory. Books
+// changed during an export may be written as before or after the
+// change.
+
+var ErrInvalidExportFormat = errors.New("unknown export format")
+var ErrInvalidExportSection = errors.New("unknown section name")
+
+// Export formats are those of imports.
+const (
+	ExportCSV    = ImportCSV
+	ExportNDJSON = ImportNDJSON
+	ExportJSON   = ImportJSON
+)
+
+const exportBatchSize = 500
+
+// `ExportFilter` selects books to be exported as "/show" does, by
+// section "title", "author", "fulltext" or "query" and `Keyword`. All
+// books are exported if `Section` is empty.
+type ExportFilter struct {
+	Section string
+	Keyword string
+}
+
+// `exportedBook` is a book in NDJSON and JSON exports.
+type exportedBook struct {
+	BookID      int    `json:"book_id"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	ISBN        string `json:"isbn"`
+	Count       int    `json:"count"`
+	Description string `json:"description"`
+	Comment     string `json:"comment"`
+}
+
+// `newExportedBook` drops placeholders of missing values of `b`, such
+// as "(no title)". `count` is the number of copies of `b`.
+func newExportedBook(b Book, count int) exportedBook {
+	missing := func(value, name string) string {
+		if value == "(no "+name+")" {
+			return ""
+		}
+		return value
+	}
+	return exportedBook{
+		BookID:      b.BookID,
+		Title:       missing(b.Title, "title"),
+		Author:      missing(b.Author, "author"),
+		ISBN:        missing(b.ISBN, "isbn"),
+		Count:       count,
+		Description: missing(b.Description, "description"),
+		Comment:     missing(b.Comment, "comment"),
+	}
+}
+
+// `BookExport` is an export checked by `NewBookExport`, and written by
+// `Write`.
+type BookExport struct {
+	db     Store
+	format string
+	next   func() ([]Book, error) // returns an empty batch at the end
+}
+
+// `NewBookExport` prepares an export of books selected by `filter` in
+// `format`.
+//
+// May return `ErrInvalidExportFormat`, `ErrInvalidExportSection`, a
+// `QueryError` for malformed queries of section "query", or
+// `ErrInvalidQuery` for those of "fulltext".
+func NewBookExport(db Store, format string, filter ExportFilter) (*BookExport, error) {
+	if format != ExportCSV && format != ExportNDJSON && format != ExportJSON {
+		return nil, ErrInvalidExportFormat
+	}
+
+	var q *BookQuery
+	switch filter.Section {
+	case "":
+	case "title", "author":
+		q = &BookQuery{Field: filter.Section, Cmp: ":", Text: filter.Keyword}
+	case "query":
+		var err error
+		q, err = ParseBookQuery(filter.Keyword)
+		if err != nil {
+			return nil, err
+		}
+	case "fulltext":
+		return newFullTextExport(db, format, filter.Keyword)
+	default:
+		return nil, ErrInvalidExportSection
+	}
+
+	// Batches follow each other as pages do (see page.go)
+	p := Page{Sort: BookSortKeys[0], Size: exportBatchSize}
+	next := func() ([]Book, error) {
+		books, err := db.QueryBooks(q, p)
+		if err != nil || len(books) == 0 {
+			return books, err
+		}
+		p.After = &Cursor{Sort: p.Sort, ID: books[len(books)-1].BookID}
+		return books, nil
+	}
+	return &BookExport{db, format, next}, nil
+}
+
+// `newFullTextExport` exports full-text search results, the most
+// relevant first.
+func newFullTextExport(db Store, format, query string) (*BookExport, error) {
+	x, err := fullTextIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := x.Search(query)
+	if err != nil {
+		return nil, err
+	}
+
+	next := func() ([]Book, error) {
+		books := []Book{}
+		for len(ids) > 0 && len(books) < exportBatchSize {
+			b, err := db.BookByID(ids[0])
+			ids = ids[1:]
+			if err == ErrBookNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			books = append(books, b)
+		}
+		return books, nil
+	}
+	return &BookExport{db, format, next}, nil
+}
+
+// `ContentType` is the MIME type of the export.
+func (e *BookExport) ContentType() string {
+	switch e.format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+// `Write` writes the export to `w`, and returns the number of books
+// written. An error leaves the export cut short.
+func (e *BookExport) Write(w io.Writer) (int, error) {
+	buf := bufio.NewWriter(w)
+	var csv_writer *csv.Writer
+	enc := json.NewEncoder(buf)
+
+	var err error
+	switch e.format {
+	case ExportCSV:
+		csv_writer = csv.NewWriter(buf)
+		err = csv_writer.Write(importColumns)
+	case ExportJSON:
+		_, err = fmt.Fprintf(buf, "{\"exported_at\": %q,\n\"books\": [\n",
+			time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		books, err := e.next()
+		if err != nil {
+			return n, err
+		}
+		if len(books) == 0 {
+			break
+		}
+
+		for _, b := range books {
+			count, err := e.db.CountCopies(b.BookID)
+			if err != nil {
+				return n, err
+			}
+			v := newExportedBook(b, count)
+			switch e.format {
+			case ExportCSV:
+				err = csv_writer.Write([]string{
+					strconv.Itoa(v.BookID), v.Title, v.Author, v.ISBN,
+					strconv.Itoa(v.Count), v.Description, v.Comment,
+				})
+			case ExportJSON:
+				if n > 0 {
+					_, err = buf.WriteString(",\n")
+					if err != nil {
+						return n, err
+					}
+				}
+				var data []byte
+				data, err = json.Marshal(v)
+				if err == nil {
+					_, err = buf.Write(data)
+				}
+			default:
+				err = enc.Encode(v)
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if csv_writer != nil {
+			csv_writer.Flush()
+			err = csv_writer.Error()
+		}
+		if err == nil {
+			err = buf.Flush()
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if e.format == ExportJSON {
+		_, err = fmt.Fprintf(buf, "\n],\n\"total\": %d}\n", n)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, buf.Flush()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportBooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		word := randString(10)
+		var data strings.Builder
+		data.WriteString("title,author,isbn,count,description,comment\n")
+		for i := 0; i < exportBatchSize+100; i++ {
+			fmt.Fprintf(&data, "%s %d,\"Knuth, D\",%s,%d,\"<a\nb>\",\n", word, i, randISBN(), i%3)
+		}
+		data.WriteString(word + " last,,,,,\n")
+		result, err := ImportBooks(db, strings.NewReader(data.String()), ImportOptions{Format: ImportCSV})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Created != exportBatchSize+101 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		books, _, err := QueryBooks(db, "title:"+word, PageRequest{Size: MaxPageSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter := ExportFilter{"query", "title:" + word}
+		for _, format := range []string{ExportCSV, ExportNDJSON, ExportJSON} {
+			export, err := NewBookExport(db, format, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			n, err := export.Write(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(books) {
+				t.Errorf("%s: expected %d books, got: %d", format, len(books), n)
+			}
+
+			// Exports are read back as imports
+			var rows []ImportRow
+			var errs []ImportError
+			switch format {
+			case ExportCSV:
+				if !strings.HasPrefix(buf.String(), "book_id,title,author,isbn,count,description,comment\n") {
+					t.Errorf("unexpected header: %.60q", buf.String())
+				}
+				rows, errs = parseCSV(&buf)
+			case ExportNDJSON:
+				rows, errs = parseNDJSON(&buf)
+			case ExportJSON:
+				rows, errs = parseJSON(&buf)
+			}
+			if len(errs) > 0 || len(rows) != len(books) {
+				t.Fatalf("%s: %d rows, errors: %+v", format, len(rows), errs)
+			}
+			for i, b := range books {
+				expected := ImportRow{Line: rows[i].Line, Count: b.AvailableCount, Info: BookInfo{Title: &b.Title}}
+				if i < len(books)-1 {
+					expected.Info.Author = strp(b.Author)
+					expected.Info.ISBN = strp(b.ISBN)
+					expected.ISBN13 = b.ISBN13
+					expected.Info.Description = strp(b.Description)
+				}
+				if !reflect.DeepEqual(rows[i], expected) {
+					t.Errorf("%s: expected: %+v, got: %+v", format, expected, rows[i])
+					break
+				}
+			}
+		}
+
+		// Exported books are matched again by ISBN
+		var buf bytes.Buffer
+		export, err := NewBookExport(db, ExportNDJSON, ExportFilter{"fulltext", word + " 7"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = export.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = ImportBooks(db, &buf, ImportOptions{ImportNDJSON, ConflictUpdate, true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, ImportResult{Updated: 1}) {
+			t.Errorf("unexpected result: %+v", result)
+		}
+
+		errs := []struct {
+			format string
+			filter ExportFilter
+			err    error
+		}{
+			{"xml", ExportFilter{}, ErrInvalidExportFormat},
+			{ExportCSV, ExportFilter{"shelf", "3"}, ErrInvalidExportSection},
+			{ExportCSV, ExportFilter{"query", "shelf:3"}, ErrInvalidQuery},
+			{ExportCSV, ExportFilter{"fulltext", "\""}, ErrInvalidQuery},
+		}
+		for _, e := range errs {
+			_, err := NewBookExport(db, e.format, e.filter)
+			if !errors.Is(err, e.err) {
+				t.Errorf("%+v: expected: %+v, got: %+v", e.filter, e.err, err)
+			}
+		}
+	})
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		// A book never given a title
+		untitled, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, untitled, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A book with a copy on loan
+		word := randString(10)
+		loaned, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, loaned, 2, BookInfo{Title: strp(word + " loaned"), ISBN: strp(randISBN())})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = BorrowBook(db, 3, loaned)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A book stored with an invalid ISBN before ISBNs were checked
+		legacy, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, legacy, 1, BookInfo{Title: strp(word + " legacy")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		isbn := "legacy-" + randString(8)
+		err = db.ModifyBook(legacy, BookInfo{ISBN: &isbn, ISBNDisplay: &isbn})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter := ExportFilter{"query", fmt.Sprintf("book_id:>=%d", untitled)}
+		formats := map[string]string{ExportCSV: ImportCSV, ExportNDJSON: ImportNDJSON, ExportJSON: ImportJSON}
+		for format, import_format := range formats {
+			export, err := NewBookExport(db, format, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			_, err = export.Write(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := buf.String()
+
+			restored := NewMemoryStore()
+			result, err := ImportBooks(restored, strings.NewReader(data), ImportOptions{Format: import_format})
+			if err != nil {
+				t.Fatalf("%s: %v %+v", format, err, result.Errors)
+			}
+			if !reflect.DeepEqual(result, ImportResult{Created: 3}) {
+				t.Errorf("%s: unexpected result: %+v", format, result)
+			}
+			books, err := restored.ListBooks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, b := range books {
+				got = append(got, fmt.Sprintf("%s/%s/%d", b.Title, b.ISBN, b.AvailableCount))
+			}
+			b, _ := db.BookByID(loaned)
+			expected := []string{
+				"(no title)/(no isbn)/1",
+				fmt.Sprintf("%s loaned/%s/2", word, b.ISBN),
+				fmt.Sprintf("%s legacy/%s/1", word, isbn),
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: expected: %q, got: %q", format, expected, got)
+			}
+
+			// Legacy ISBNs match their books again
+			result, err = ImportBooks(restored, strings.NewReader(data), ImportOptions{import_format, ConflictUpdate, true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, ImportResult{Created: 1, Updated: 2}) {
+				t.Errorf("%s: unexpected result: %+v", format, result)
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Fines are charged for overdue books at `FineRate` per day of the
+// borrower's circulation policy, up to `FineCap` per loan. They accrue
+// while the book is out, and are posted to the ledger (table Fine) as a
+// charge when it is returned. Administrators record payments and
+// waivers against the posted balance. Amounts are integers in the
+// smallest currency unit.
+type FineKind string
+
+const (
+	FineCharge  FineKind = "charge"
+	FinePayment FineKind = "payment"
+	FineWaiver  FineKind = "waiver"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+var ErrExceedsBalance = errors.New("amount exceeds the balance")
+var ErrFinesOwed = errors.New("too many fines owed")
+
+// `ComputeFine` returns the fine of record `r` under policy `p`, counted
+// until the return date, or until `now` if not returned.
+func ComputeFine(p Policy, r Record, now time.Time) int {
+	end := now
+	if r.Returned {
+		end = r.ReturnDate
+	}
+
+	late := int(truncateDate(end).Sub(truncateDate(r.DueDate)) / day)
+	if late <= 0 {
+		return 0
+	}
+
+	fine := late * p.FineRate
+	if p.FineCap > 0 && fine > p.FineCap {
+		fine = p.FineCap
+	}
+	return fine
+}
+
+// `GetFineBalance` returns the balance of `user_id` at `now`.
+//
+// May return `ErrInvalidUser`.
+func GetFineBalance(db Store, user_id int, now time.Time) (FineBalance, error) {
+	var b FineBalance
+	err := db.Atomic(func(db Store) error {
+		u, err := db.UserByID(user_id)
+		if err != nil {
+			return err
+		}
+		p, err := db.TypePolicy(u.TypeID)
+		if err != nil {
+			return err
+		}
+
+		b.Posted, err = db.FineBalance(user_id)
+		if err != nil {
+			return err
+		}
+
+		list, err := db.ListRecords(user_id, RecordFilter{NotReturned: true, DueBefore: now}, Page{})
+		if err != nil {
+			return err
+		}
+		for _, r := range list {
+			b.Accruing += ComputeFine(p, r, now)
+		}
+
+		b.Balance = b.Posted + b.Accruing
+		return nil
+	})
+	if err != nil {
+		return FineBalance{}, err
+	}
+
+	return b, nil
+}
+
+// `ListFines` returns ledger entries of `user_id`.
+func ListFines(db Store, user_id int) ([]Fine, error) {
+	return db.ListFines(user_id)
+}
+
+// `chargeFine` posts the fine of returned record `r`, if any.
+func chargeFine(db Store, r Record, now time.Time) error {
+	u, err := db.UserByID(r.UserID)
+	if err != nil {
+		return err
+	}
+	p, err := db.TypePolicy(u.TypeID)
+	if err != nil {
+		return err
+	}
+
+	amount := ComputeFine(p, r, now)
+	if amount == 0 {
+		return nil
+	}
+	_, err = db.InsertFine(Fine{
+		UserID:    r.UserID,
+		RecordID:  r.RecordID,
+		Kind:      FineCharge,
+		Amount:    amount,
+		CreatedAt: now,
+	})
+	return err
+}
+
+// `PayFine` records a payment of `amount` by `user_id`, taken by
+// administrator `admin_id`, and returns the ID of the ledger entry.
+//
+// May return `ErrInvalidUser`, `ErrInvalidAmount` or
+// `ErrExceedsBalance`.
+func PayFine(db Store, user_id, admin_id, amount int, note string) (int, error) {
+	return settleFine(db, Fine{
+		UserID:  user_id,
+		Kind:    FinePayment,
+		Amount:  amount,
+		AdminID: admin_id,
+		Note:    note,
+	})
+}
+
+// `WaiveFine` waives `amount` of the fines of `user_id`, optionally
+// those of record `record_id`, and returns the ID of the ledger entry.
+//
+// May return `ErrInvalidUser`, `ErrInvalidRecordID`, `ErrInvalidAmount`
+// or `ErrExceedsBalance`.
+func WaiveFine(db Store, user_id, admin_id, record_id, amount int, note string) (int, error) {
+	return settleFine(db, Fine{
+		UserID:   user_id,
+		RecordID: record_id,
+		Kind:     FineWaiver,
+		Amount:   amount,
+		AdminID:  admin_id,
+		Note:     note,
+	})
+}
+
+// `settleFine` adds payment or waiver `f`. Only posted fines can be
+// settled, so the balance never becomes negative.
+func settleFine(db Store, f Fine) (int, error) {
+	if f.Amount <= 0 {
+		return -1, ErrInvalidAmount
+	}
+
+	fine_id := -1
+	err := db.Atomic(func(db Store) error {
+		_, err := db.UserByID(f.UserID)
+		if err != nil {
+			return err
+		}
+		if f.RecordID != 0 {
+			r, err := db.RecordByID(f.RecordID)
+			if err != nil {
+				return err
+			}
+			if r.UserID != f.UserID {
+				return ErrInvalidRecordID
+			}
+		}
+
+		balance, err := db.FineBalance(f.UserID)
+		if err != nil {
+			return err
+		}
+		if f.Amount > balance {
+			return ErrExceedsBalance
+		}
+
+		f.CreatedAt = time.Now()
+		fine_id, err = db.InsertFine(f)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return fine_id, nil
+}
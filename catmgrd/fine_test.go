@@ -0,0 +1,196 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeFine(t *testing.T) {
+	now := time.Now()
+	ago := func(n int) time.Time {
+		return now.Add(-days(n))
+	}
+
+	tb := []struct {
+		rate, cap int
+		r         Record
+		fine      int
+	}{
+		{10, 0, Record{DueDate: ago(-1)}, 0},
+		{10, 0, Record{DueDate: ago(0)}, 0},
+		{10, 0, Record{DueDate: ago(3)}, 30},
+		{10, 25, Record{DueDate: ago(3)}, 25},
+		{0, 0, Record{DueDate: ago(3)}, 0},
+		{10, 0, Record{DueDate: ago(5), Returned: true, ReturnDate: ago(3)}, 20},
+		{10, 0, Record{DueDate: ago(5), Returned: true, ReturnDate: ago(6)}, 0},
+	}
+
+	for _, e := range tb {
+		p := Policy{FineRate: e.rate, FineCap: e.cap}
+		fine := ComputeFine(p, e.r, now)
+		if fine != e.fine {
+			t.Errorf("%+v: expected: %d, got: %d", e, e.fine, fine)
+		}
+	}
+}
+
+func TestFines(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		type_id, err := NewUserType(db, UserType{TypeName: randString(8), Perm: PermissionSet{PermRecordBorrow}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := DefaultPolicy
+		p.FineRate = 10
+		p.MaxBalance = 25
+		err = SetPolicy(db, type_id, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user_id, err := AddUser(db, type_id, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Five days overdue
+		now := time.Now()
+		record_id, err := db.InsertRecord(Record{
+			UserID:     user_id,
+			BookID:     book_id,
+			BorrowDate: now.Add(-days(10)),
+			DueDate:    now.Add(-days(5)),
+			FinalDate:  now.Add(days(10)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectBalance := func(posted, accruing int) {
+			t.Helper()
+			b, err := GetFineBalance(db, user_id, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := FineBalance{posted, accruing, posted + accruing}
+			if b != expected {
+				t.Errorf("expected: %+v, got: %+v", expected, b)
+			}
+		}
+
+		expectBalance(0, 50)
+		_, err = BorrowBook(db, user_id, book_id)
+		if err != ErrFinesOwed {
+			t.Errorf("expected: %+v, got: %+v", ErrFinesOwed, err)
+		}
+
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(50, 0)
+
+		_, err = PayFine(db, user_id, 1, 60, "")
+		if err != ErrExceedsBalance {
+			t.Errorf("expected: %+v, got: %+v", ErrExceedsBalance, err)
+		}
+		_, err = PayFine(db, user_id, 1, 0, "")
+		if err != ErrInvalidAmount {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidAmount, err)
+		}
+		_, err = PayFine(db, -1, 1, 10, "")
+		if err != ErrInvalidUser {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUser, err)
+		}
+		_, err = PayFine(db, user_id, 1, 20, "cash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(30, 0)
+		_, err = BorrowBook(db, user_id, book_id)
+		if err != ErrFinesOwed {
+			t.Errorf("expected: %+v, got: %+v", ErrFinesOwed, err)
+		}
+
+		// Record 1 belongs to another user
+		_, err = WaiveFine(db, user_id, 1, 1, 10, "")
+		if err != ErrInvalidRecordID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidRecordID, err)
+		}
+		_, err = WaiveFine(db, user_id, 1, record_id, 10, "first offence")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(20, 0)
+		_, err = BorrowBook(db, user_id, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list, err := ListFines(db, user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := []FineKind{FineCharge, FinePayment, FineWaiver}
+		if len(list) != len(kinds) {
+			t.Fatalf("unexpected entries: %+v", list)
+		}
+		for i, f := range list {
+			if f.Kind != kinds[i] || f.UserID != user_id {
+				t.Errorf("unexpected entry: %+v", f)
+			}
+		}
+		if list[0].RecordID != record_id || list[0].Amount != 50 || list[0].AdminID != 0 {
+			t.Errorf("unexpected charge: %+v", list[0])
+		}
+		if list[1].AdminID != 1 || list[1].Note != "cash" {
+			t.Errorf("unexpected payment: %+v", list[1])
+		}
+	})
+}
+
+func TestFineGrant(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		type_id, err := NewUserType(db, UserType{
+			TypeName: randString(8),
+			Perm:     NewPermissionSet(PermFineManage, PermRecordBorrow),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user_id, err := AddUser(db, type_id, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		desk, err := db.UserByID(user_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Fines of more privileged users are out of reach of the desk
+		tb := []struct {
+			user_id int
+			err     error
+		}{
+			{user_id, nil},
+			{3, nil},
+			{5, nil},
+			{1, ErrPrivilegeEscalation},
+			{2, ErrPrivilegeEscalation},
+			{233, ErrInvalidUser},
+		}
+		for _, e := range tb {
+			err := CheckUserGrant(db, desk, e.user_id)
+			if err != e.err {
+				t.Errorf("user %d: expected: %+v, got: %+v", e.user_id, e.err, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode"
+)
+
+// Full-text search runs on an in-process inverted index over title,
+// author, description and comment of books, so it works the same on
+// all backends. Each store gets its own index, built from `ListBooks`
+// on the first search and kept in sync by `NewBook` and `UpdateBook`.
+// Books added or removed by other processes sharing the database, such
+// as `catmgrd import`, are noticed by the next search, which rebuilds
+// the index. Other changes by them are seen after SIGHUP or restart.
+//
+// Queries are made of words, "quoted phrases" and prefixes ending with
+// `*`. Books must match all of them, and are ranked by BM25 with
+// matches in titles and authors weighted more.
+
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// `fullTextWeights` are the weights of title, author, description and
+// comment in that order.
+var fullTextWeights = [...]float64{3, 2, 1, 1}
+
+// Positions in different fields are `fieldGap` apart, so that phrases
+// never span two fields.
+const fieldGap = 1 << 20
+
+// `FullTextIndex` maps terms to the positions where they occur in each
+// book. It is safe for concurrent use.
+type FullTextIndex struct {
+	mu       sync.Mutex
+	postings map[string]map[int][]int
+	docs     map[int][]string // distinct terms of each book
+	lengths  map[int]int
+	total    int
+	terms    []string // sorted terms for prefixes, nil if stale
+}
+
+func NewFullTextIndex() *FullTextIndex {
+	return &FullTextIndex{
+		postings: map[string]map[int][]int{},
+		docs:     map[int][]string{},
+		lengths:  map[int]int{},
+	}
+}
+
+// `tokenize` splits `text` into lower case words of letters and
+// digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// `fullTextFields` returns the indexed fields of `b`. Placeholders of
+// missing values, such as "(no title)", are left out.
+func fullTextFields(b Book) [4]string {
+	fields := [4]string{b.Title, b.Author, b.Description, b.Comment}
+	for i, name := range [...]string{"title", "author", "description", "comment"} {
+		if fields[i] == "(no "+name+")" {
+			fields[i] = ""
+		}
+	}
+	return fields
+}
+
+// `Put` indexes `b`, replacing what was indexed for it before.
+func (x *FullTextIndex) Put(b Book) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(b.BookID)
+
+	length := 0
+	for field, text := range fullTextFields(b) {
+		for i, term := range tokenize(text) {
+			docs, ok := x.postings[term]
+			if !ok {
+				docs = map[int][]int{}
+				x.postings[term] = docs
+				x.terms = nil
+			}
+			if len(docs[b.BookID]) == 0 {
+				x.docs[b.BookID] = append(x.docs[b.BookID], term)
+			}
+			docs[b.BookID] = append(docs[b.BookID], field*fieldGap+i)
+			length++
+		}
+	}
+
+	x.lengths[b.BookID] = length
+	x.total += length
+}
+
+// `Remove` drops `book_id` from the index.
+func (x *FullTextIndex) Remove(book_id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(book_id)
+}
+
+// `span` returns the number of indexed books and the greatest book ID
+// among them.
+func (x *FullTextIndex) span() (int, int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	last := 0
+	for book_id := range x.lengths {
+		if book_id > last {
+			last = book_id
+		}
+	}
+	return len(x.lengths), last
+}
+
+func (x *FullTextIndex) remove(book_id int) {
+	for _, term := range x.docs[book_id] {
+		docs := x.postings[term]
+		delete(docs, book_id)
+		if len(docs) == 0 {
+			delete(x.postings, term)
+			x.terms = nil
+		}
+	}
+	x.total -= x.lengths[book_id]
+	delete(x.docs, book_id)
+	delete(x.lengths, book_id)
+}
+
+// `fullTextClause` is a word, a phrase or a prefix of a query.
+type fullTextClause struct {
+	words  []string
+	prefix bool
+}
+
+// `parseFullText` splits `query` into clauses.
+//
+// Returns `ErrInvalidQuery` if there is no word in `query`, or a quote
+// is not closed.
+func parseFullText(query string) ([]fullTextClause, error) {
+	var clauses []fullTextClause
+	for query != "" {
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				return nil, ErrInvalidQuery
+			}
+			words := tokenize(query[1 : end+1])
+			if len(words) > 0 {
+				clauses = append(clauses, fullTextClause{words: words})
+			}
+			query = query[end+2:]
+			continue
+		}
+
+		end := strings.IndexAny(query, " \t\r\n\"")
+		if end < 0 {
+			end = len(query)
+		}
+		if end == 0 {
+			query = query[1:]
+			continue
+		}
+		token := query[:end]
+		query = query[end:]
+
+		prefix := strings.HasSuffix(token, "*")
+		words := tokenize(strings.TrimSuffix(token, "*"))
+		if len(words) == 0 {
+			continue
+		}
+		// Punctuated words like "Song-Chun" are phrases, which are not
+		// matched by prefix
+		clauses = append(clauses, fullTextClause{
+			words:  words,
+			prefix: prefix && len(words) == 1,
+		})
+	}
+
+	if len(clauses) == 0 {
+		return nil, ErrInvalidQuery
+	}
+	return clauses, nil
+}
+
+// `Search` returns IDs of books matching `query`, the most relevant
+// first. Books of equal scores are ordered by book ID.
+//
+// May return `ErrInvalidQuery`.
+func (x *FullTextIndex) Search(query string) ([]int, error) {
+	clauses, err := parseFullText(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted terms are rebuilt here, so the index is locked for writing
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.terms == nil {
+		x.terms = make([]string, 0, len(x.postings))
+		for term := range x.postings {
+			x.terms = append(x.terms, term)
+		}
+		sort.Strings(x.terms)
+	}
+
+	n := float64(len(x.lengths))
+	avg_length := 1.0
+	if x.total > 0 {
+		avg_length = float64(x.total) / n
+	}
+
+	var scores map[int]float64
+	for _, c := range clauses {
+		freqs := x.match(c)
+		idf := math.Log(1 + (n-float64(len(freqs))+0.5)/(float64(len(freqs))+0.5))
+
+		next := map[int]float64{}
+		for book_id, tf := range freqs {
+			if scores != nil {
+				if _, ok := scores[book_id]; !ok {
+					continue
+				}
+			}
+			norm := 1 - bm25B + bm25B*float64(x.lengths[book_id])/avg_length
+			next[book_id] = scores[book_id] + idf*tf*(bm25K1+1)/(tf+bm25K1*norm)
+		}
+		scores = next
+	}
+
+	list := make([]int, 0, len(scores))
+	for book_id := range scores {
+		list = append(list, book_id)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := scores[list[i]], scores[list[j]]
+		if a != b {
+			return a > b
+		}
+		return list[i] < list[j]
+	})
+	return list, nil
+}
+
+// `match` returns the weighted frequencies of `c` in matching books.
+func (x *FullTextIndex) match(c fullTextClause) map[int]float64 {
+	freqs := map[int]float64{}
+	if c.prefix {
+		i := sort.SearchStrings(x.terms, c.words[0])
+		for ; i < len(x.terms) && strings.HasPrefix(x.terms[i], c.words[0]); i++ {
+			for book_id, positions := range x.postings[x.terms[i]] {
+				for _, p := range positions {
+					freqs[book_id] += fullTextWeights[p/fieldGap]
+				}
+			}
+		}
+		return freqs
+	}
+
+	for book_id, positions := range x.postings[c.words[0]] {
+		for _, p := range positions {
+			if x.phraseAt(book_id, c.words[1:], p+1) {
+				freqs[book_id] += fullTextWeights[p/fieldGap]
+			}
+		}
+	}
+	return freqs
+}
+
+// `phraseAt` tells whether `words` occur in `book_id` from position
+// `p` on.
+func (x *FullTextIndex) phraseAt(book_id int, words []string, p int) bool {
+	for i, word := range words {
+		positions := x.postings[word][book_id]
+		j := sort.SearchInts(positions, p+i)
+		if j == len(positions) || positions[j] != p+i {
+			return false
+		}
+	}
+	return true
+}
+
+var fullTextIndexes = struct {
+	sync.Mutex
+	m map[Store]*FullTextIndex
+}{m: map[Store]*FullTextIndex{}}
+
+// `fullTextIndex` returns the index of `db`, which is built on first
+// use, and built again if books have been added or removed meanwhile
+// by another process.
+func fullTextIndex(db Store) (*FullTextIndex, error) {
+	fullTextIndexes.Lock()
+	defer fullTextIndexes.Unlock()
+
+	x, ok := fullTextIndexes.m[db]
+	if ok {
+		count, err := db.CountBooks(nil)
+		if err != nil {
+			return nil, err
+		}
+		last, err := db.QueryBooks(nil, Page{Sort: BookSortKeys[0], Desc: true, Size: 1})
+		if err != nil {
+			return nil, err
+		}
+		last_id := 0
+		if len(last) > 0 {
+			last_id = last[0].BookID
+		}
+		if n, m := x.span(); n == count && m == last_id {
+			return x, nil
+		}
+	}
+
+	books, err := db.ListBooks()
+	if err != nil {
+		return nil, err
+	}
+	x = NewFullTextIndex()
+	for _, b := range books {
+		x.Put(b)
+	}
+	fullTextIndexes.m[db] = x
+	return x, nil
+}
+
+// `ResetFullTextIndexes` drops the indexes of all stores, which are
+// built again on the next search.
+func ResetFullTextIndexes() {
+	fullTextIndexes.Lock()
+	defer fullTextIndexes.Unlock()
+	fullTextIndexes.m = map[Store]*FullTextIndex{}
+}
+
+// `ResetFullTextOnSignal` resets the indexes whenever the process
+// receives SIGHUP, so that books changed by other processes are seen.
+func ResetFullTextOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			ResetFullTextIndexes()
+			log.Println("full-text index reset")
+		}
+	}()
+}
+
+// `reindexBook` updates `book_id` in the index of `db`, if it has been
+// built.
+func reindexBook(db Store, book_id int) error {
+	fullTextIndexes.Lock()
+	defer fullTextIndexes.Unlock()
+
+	x, ok := fullTextIndexes.m[db]
+	if !ok {
+		return nil
+	}
+
+	b, err := db.BookByID(book_id)
+	if err == ErrBookNotFound {
+		x.Remove(book_id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	x.Put(b)
+	return nil
+}
+
+// `SearchFullText` returns a page of books matching `query` (see
+// above), the most relevant first, with available counts per branch.
+// Pages are cut by position, as the only sort key is "relevance".
+//
+// May return `ErrInvalidQuery`, `ErrInvalidSortKey`,
+// `ErrInvalidPageSize` or `ErrInvalidCursor`.
+func SearchFullText(db Store, query string, req PageRequest) ([]Book, PageInfo, error) {
+	offset, size, err := pageOffset(req)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	x, err := fullTextIndex(db)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	ids, err := x.Search(query)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	info := PageInfo{Total: len(ids)}
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	ids = ids[offset:]
+	if len(ids) > size {
+		ids = ids[:size]
+		c := Cursor{Sort: "relevance", Value: strconv.Itoa(offset + size)}
+		info.NextCursor = c.Encode()
+	}
+
+	books := make([]Book, 0, len(ids))
+	for _, book_id := range ids {
+		b, err := db.BookByID(book_id)
+		if err == ErrBookNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		books = append(books, b)
+	}
+
+	err = fillBranches(db, books)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	return books, info, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFullTextIndex(t *testing.T) {
+	x := NewFullTextIndex()
+	for _, b := range []Book{
+		{BookID: 1, Title: "Graph Theory", Author: "Diestel, Reinhard", Description: "(no description)"},
+		{BookID: 2, Title: "Computational Geometry and Graph Theory", Comment: "conference"},
+		{BookID: 3, Title: "Algebra", Description: "Theory of graphs and groups, graph theory inside"},
+		{BookID: 4, Title: "Compiler Design", Author: "Wilhelm, Reinhard"},
+	} {
+		x.Put(b)
+	}
+
+	tb := []struct {
+		query string
+		ids   []int
+		err   error
+	}{
+		{"graph theory", []int{1, 2, 3}, nil},
+		{"GRAPH", []int{1, 2, 3}, nil},
+		{`"theory graph"`, []int{}, nil},
+		{`"graph theory" algebra`, []int{3}, nil},
+		{"reinhard", []int{1, 4}, nil},
+		{"comp*", []int{4, 2}, nil},
+		{"compu*", []int{2}, nil},
+		{"description", []int{}, nil},
+		{"geometry-and-graph", []int{2}, nil},
+		{"  ", nil, ErrInvalidQuery},
+		{`"graph`, nil, ErrInvalidQuery},
+	}
+	for _, e := range tb {
+		ids, err := x.Search(e.query)
+		if err != e.err {
+			t.Errorf("%q: expected: %+v, got: %+v", e.query, e.err, err)
+		}
+		if e.err == nil && !reflect.DeepEqual(ids, e.ids) {
+			t.Errorf("%q: expected: %v, got: %v", e.query, e.ids, ids)
+		}
+	}
+
+	// Updated books are reindexed
+	x.Put(Book{BookID: 1, Title: "Topology"})
+	x.Remove(4)
+	for query, expected := range map[string][]int{
+		"graph":    {2, 3},
+		"topology": {1},
+		"reinhard": {},
+	} {
+		ids, err := x.Search(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%q: expected: %v, got: %v", query, expected, ids)
+		}
+	}
+}
+
+func TestSearchFullText(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		books, _, err := SearchFullText(db, `"graph theory"`, PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Titles that are just the phrase come first
+		if len(books) != 11 || books[0].BookID != 15 || books[1].BookID != 13 {
+			t.Errorf("unexpected books: %+v", books)
+		}
+
+		word := randString(12)
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{Description: strp("about " + word)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		books, _, err = SearchFullText(db, word[:6]+"*", PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].BookID != book_id || books[0].AvailableCount != 1 ||
+			len(books[0].Branches) != 1 {
+			t.Errorf("unexpected books: %+v", books)
+		}
+
+		err = UpdateBook(db, book_id, 0, BookInfo{Description: strp("gone")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		books, _, err = SearchFullText(db, word, PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 0 {
+			t.Errorf("unexpected books: %+v", books)
+		}
+
+		_, _, err = SearchFullText(db, "", PageRequest{})
+		if err != ErrInvalidQuery {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidQuery, err)
+		}
+	})
+}
+
+func TestFullTextOtherProcess(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		search := func(word string) int {
+			t.Helper()
+			_, info, err := SearchFullText(db, word, PageRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return info.Total
+		}
+		search("graph")
+
+		// Store methods stand for another process, which does not
+		// update the index of this one
+		word := randString(12)
+		book_id, err := db.InsertBook()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.ModifyBook(book_id, BookInfo{Title: strp(word)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := search(word); n != 1 {
+			t.Errorf("new book not found, got %d results", n)
+		}
+
+		other := randString(12)
+		err = db.ModifyBook(book_id, BookInfo{Title: strp(other)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := search(other); n != 0 {
+			t.Errorf("expected stale index, got %d results", n)
+		}
+		ResetFullTextIndexes()
+		if n := search(other); n != 1 {
+			t.Errorf("changed book not found after reset, got %d results", n)
+		}
+	})
+}
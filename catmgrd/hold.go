@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Holds queue users for books without available copies. Holds on a
+// book are served first come, first served: a copy returned (or added
+// by `UpdateBook` and `AddItem`) is reserved for the earliest waiting
+// hold instead of the shelf, and kept for the holder for `PickupDays`
+// of the holder's circulation policy. Copies not picked up in time go
+// to the next holder. Expired holds are released lazily, whenever a
+// book is borrowed or holds are placed or listed.
+type HoldStatus string
+
+const (
+	HoldWaiting   HoldStatus = "waiting"
+	HoldReady     HoldStatus = "ready"
+	HoldFulfilled HoldStatus = "fulfilled"
+	HoldCancelled HoldStatus = "cancelled"
+	HoldExpired   HoldStatus = "expired"
+)
+
+// `Active` reports whether a hold in status `s` is still in the queue
+// or has a copy reserved.
+func (s HoldStatus) Active() bool {
+	return s == HoldWaiting || s == HoldReady
+}
+
+var ErrInvalidHoldID = errors.New("invalid hold ID")
+var ErrDuplicateHold = errors.New("you already hold this book")
+var ErrBookAvailable = errors.New("book is available, borrow it instead")
+var ErrHoldClosed = errors.New("hold is no longer active")
+
+// `PlaceHold` puts `user_id` at the end of the queue for `book_id`, and
+// returns the ID of the new hold. Holds can only be placed on books
+// without available copies.
+//
+// May return `ErrInvalidBookID`, `ErrDuplicateHold` or
+// `ErrBookAvailable`.
+func PlaceHold(db Store, user_id, book_id int) (int, error) {
+	now := time.Now()
+	hold_id := -1
+	err := db.Atomic(func(db Store) error {
+		err := expireHolds(db, now)
+		if err != nil {
+			return err
+		}
+
+		b, err := db.BookByID(book_id)
+		if err == ErrBookNotFound {
+			return ErrInvalidBookID
+		}
+		if err != nil {
+			return err
+		}
+
+		h, err := db.ActiveHold(user_id, book_id)
+		if err != nil {
+			return err
+		}
+		if h.HoldID != 0 {
+			return ErrDuplicateHold
+		}
+		if b.AvailableCount > 0 {
+			return ErrBookAvailable
+		}
+
+		hold_id, err = db.InsertHold(Hold{
+			UserID:    user_id,
+			BookID:    book_id,
+			Status:    HoldWaiting,
+			CreatedAt: now,
+		})
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return hold_id, nil
+}
+
+// `CheckoutHold` returns hold `hold_id`, with `Position` filled.
+//
+// May return `ErrInvalidHoldID`.
+func CheckoutHold(db Store, hold_id int) (Hold, error) {
+	h, err := db.HoldByID(hold_id)
+	if err != nil {
+		return Hold{}, err
+	}
+	return holdView(db, h)
+}
+
+// `ListHolds` returns waiting and ready holds of `user_id`, with their
+// positions in the queues.
+func ListHolds(db Store, user_id int) ([]Hold, error) {
+	var list []Hold
+	err := db.Atomic(func(db Store) error {
+		err := expireHolds(db, time.Now())
+		if err != nil {
+			return err
+		}
+
+		list, err = db.ListHolds(user_id)
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			list[i], err = holdView(db, list[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// `holdView` fills `Position` of `h`.
+func holdView(db Store, h Hold) (Hold, error) {
+	if h.Status != HoldWaiting {
+		h.Position = 0
+		return h, nil
+	}
+
+	count, err := db.CountHoldsBefore(h.BookID, h.HoldID)
+	if err != nil {
+		return Hold{}, err
+	}
+	h.Position = count + 1
+	return h, nil
+}
+
+// `CancelHold` removes hold `hold_id` from the queue. The copy
+// reserved for a ready hold goes to the next holder.
+//
+// May return `ErrInvalidHoldID` or `ErrHoldClosed`.
+//
+// NOTE: this function does not check `user_id`.
+func CancelHold(db Store, hold_id int) error {
+	return db.Atomic(func(db Store) error {
+		h, err := db.HoldByID(hold_id)
+		if err != nil {
+			return err
+		}
+		if !h.Status.Active() {
+			return ErrHoldClosed
+		}
+		return closeHold(db, h, HoldCancelled, time.Now())
+	})
+}
+
+// `closeHold` sets `h` to `status`, and releases its copy if reserved.
+func closeHold(db Store, h Hold, status HoldStatus, now time.Time) error {
+	item_id := h.ItemID
+	ready := h.Status == HoldReady
+	h.Status = status
+	h.ExpiresAt = time.Time{}
+	err := db.UpdateHold(h)
+	if err != nil || !ready {
+		return err
+	}
+	return releaseItem(db, item_id, h.BookID, now)
+}
+
+// `serveHolds` reserves available copies of `book_id` for waiting
+// holds, earliest first, until either runs out.
+func serveHolds(db Store, book_id int, now time.Time) error {
+	for {
+		h, err := db.NextHold(book_id)
+		if err != nil || h.HoldID == 0 {
+			return err
+		}
+
+		it, err := db.AvailableItem(book_id, 0)
+		if err != nil || it.ItemID == 0 {
+			return err
+		}
+		err = db.ChangeItemStatus(it.ItemID, ItemAvailable, ItemReserved)
+		if err == ErrNoAvailableBook {
+			// Taken by a concurrent borrow
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		u, err := db.UserByID(h.UserID)
+		if err != nil {
+			return err
+		}
+		p, err := db.TypePolicy(u.TypeID)
+		if err != nil {
+			return err
+		}
+		h.Status = HoldReady
+		h.ItemID = it.ItemID
+		h.ExpiresAt = now.Add(days(p.PickupDays))
+		err = db.UpdateHold(h)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// `expireHolds` expires ready holds not picked up before `now`.
+func expireHolds(db Store, now time.Time) error {
+	list, err := db.ExpiredHolds(now)
+	if err != nil {
+		return err
+	}
+	for _, h := range list {
+		err = closeHold(db, h, HoldExpired, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// `cancelUserHolds` cancels all holds of `user_id`.
+func cancelUserHolds(db Store, user_id int, now time.Time) error {
+	list, err := db.ListHolds(user_id)
+	if err != nil {
+		return err
+	}
+	for _, h := range list {
+		err = closeHold(db, h, HoldCancelled, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHold(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		var users [3]int
+		for i := range users {
+			var err error
+			users[i], err = AddUser(db, 3, randString(8), "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectAvailable := func(count int) {
+			t.Helper()
+			b, err := CheckoutBook(db, book_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.AvailableCount != count {
+				t.Errorf("expected %d available, got: %d", count, b.AvailableCount)
+			}
+		}
+		expectStatus := func(hold_id int, status HoldStatus) Hold {
+			t.Helper()
+			h, err := CheckoutHold(db, hold_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Status != status {
+				t.Errorf("expected: %s, got: %s", status, h.Status)
+			}
+			return h
+		}
+		borrow := func(user_id int) int {
+			t.Helper()
+			record_id, err := BorrowBook(db, user_id, book_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return record_id
+		}
+		hold := func(user_id int) int {
+			t.Helper()
+			hold_id, err := PlaceHold(db, user_id, book_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return hold_id
+		}
+
+		_, err = PlaceHold(db, users[0], book_id)
+		if err != ErrBookAvailable {
+			t.Errorf("expected: %+v, got: %+v", ErrBookAvailable, err)
+		}
+		_, err = PlaceHold(db, users[0], -1)
+		if err != ErrInvalidBookID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBookID, err)
+		}
+
+		record_id := borrow(users[0])
+		first := hold(users[1])
+		second := hold(users[2])
+		_, err = PlaceHold(db, users[1], book_id)
+		if err != ErrDuplicateHold {
+			t.Errorf("expected: %+v, got: %+v", ErrDuplicateHold, err)
+		}
+
+		list, err := ListHolds(db, users[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].HoldID != second || list[0].Position != 2 {
+			t.Errorf("unexpected holds: %+v", list)
+		}
+
+		// The returned copy is reserved for the first holder
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectAvailable(0)
+		h := expectStatus(first, HoldReady)
+		if h.ExpiresAt.Before(time.Now().Add(days(DefaultPolicy.PickupDays) - time.Minute)) {
+			t.Errorf("pickup window too short: %v", h.ExpiresAt)
+		}
+		if h := expectStatus(second, HoldWaiting); h.Position != 1 {
+			t.Errorf("expected position 1, got: %d", h.Position)
+		}
+		_, err = BorrowBook(db, users[2], book_id)
+		if err != ErrNoAvailableBook {
+			t.Errorf("expected: %+v, got: %+v", ErrNoAvailableBook, err)
+		}
+
+		record_id = borrow(users[1])
+		expectStatus(first, HoldFulfilled)
+
+		// Cancelling a ready hold puts the copy back into the pool
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(second, HoldReady)
+		err = CancelHold(db, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectAvailable(1)
+		err = CancelHold(db, second)
+		if err != ErrHoldClosed {
+			t.Errorf("expected: %+v, got: %+v", ErrHoldClosed, err)
+		}
+		err = CancelHold(db, -1)
+		if err != ErrInvalidHoldID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidHoldID, err)
+		}
+
+		// Copies not picked up in time are released
+		record_id = borrow(users[0])
+		third := hold(users[1])
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h = expectStatus(third, HoldReady)
+		h.ExpiresAt = time.Now().Add(-time.Hour)
+		err = db.UpdateHold(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err = ListHolds(db, users[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 0 {
+			t.Errorf("unexpected holds: %+v", list)
+		}
+		expectStatus(third, HoldExpired)
+		expectAvailable(1)
+
+		// New copies go to holders too
+		borrow(users[0])
+		fourth := hold(users[2])
+		err = UpdateBook(db, book_id, 1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(fourth, HoldReady)
+		expectAvailable(0)
+
+		// Deleting the holder releases the copy
+		_, err = DeleteUser(db, users[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectAvailable(1)
+	})
+}
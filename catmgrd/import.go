@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Books are imported in bulk from CSV, with a header row naming the
+// columns, from JSON Lines (NDJSON), one object per line, or from JSON
+// snapshots of `BookExport`. Columns and keys are `title`, `author`,
+// `isbn`, `count`, `description` and `comment`; `title` is required,
+// and `count` copies are added. Empty values are left out, so they do
+// not overwrite existing ones. `book_id` of exports is not imported,
+// but marks rows of exports, which may lack titles, and keep invalid
+// ISBNs of books stored before ISBNs were checked.
+//
+// All rows are checked before anything is written, including their
+// ISBNs (see isbn.go). Rows whose ISBN is already in the catalog, in
+// any formatting, are skipped or update the book, as told by the
+// conflict policy. Rows are written in batches of `ImportBatchSize`,
+// each in one transaction.
+
+var ErrInvalidImport = errors.New("invalid rows in import")
+var ErrInvalidImportFormat = errors.New("unknown import format")
+var ErrInvalidConflictPolicy = errors.New("unknown conflict policy")
+
+// Import formats.
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+	ImportJSON   = "json"
+)
+
+// Conflict policies for rows with existing ISBNs.
+const (
+	ConflictSkip   = "skip"
+	ConflictUpdate = "update"
+)
+
+const ImportBatchSize = 500
+
+// `importColumns` are the known columns of imports, in the order of
+// exports.
+var importColumns = []string{"book_id", "title", "author", "isbn", "count", "description", "comment"}
+
+// `ImportOptions` of `ImportBooks`. `OnConflict` is `ConflictSkip` if
+// empty. With `DryRun`, rows are checked and counted only.
+type ImportOptions struct {
+	Format     string
+	OnConflict string
+	DryRun     bool
+}
+
+// `ImportRow` is a book to be imported from line `Line`. `ISBN13` is
+// the ISBN-13 of `Info.ISBN`, if any, or `Info.ISBN` itself if it is a
+// `LegacyISBN` of an export.
+type ImportRow struct {
+	Line       int
+	Info       BookInfo
+	ISBN13     string
+	LegacyISBN bool
+	Count      int
+}
+
+// `ImportError` is a problem in line `Line` of an import.
+type ImportError struct {
+	Line int    `json:"line"`
+	Msg  string `json:"error"`
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// `ImportResult` counts books created, updated and skipped, or those
+// that would be in dry runs. `Errors` lists invalid rows.
+type ImportResult struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Skipped int           `json:"skipped"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+// `newImportRow` makes a row of `values` by column names. Returns the
+// problem as a message.
+func newImportRow(line int, values map[string]string) (ImportRow, string) {
+	row := ImportRow{Line: line}
+	for _, column := range importColumns {
+		value := strings.TrimSpace(values[column])
+		if value == "" {
+			continue
+		}
+
+		switch column {
+		case "title":
+			row.Info.Title = &value
+		case "author":
+			row.Info.Author = &value
+		case "isbn":
+			row.Info.ISBN = &value
+		case "description":
+			row.Info.Description = &value
+		case "comment":
+			row.Info.Comment = &value
+		case "count":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return ImportRow{}, fmt.Sprintf("invalid count: %#v", value)
+			}
+			row.Count = n
+		}
+	}
+
+	exported := strings.TrimSpace(values["book_id"]) != ""
+	if row.Info.Title == nil && !exported {
+		return ImportRow{}, "missing title"
+	}
+	if row.Info.ISBN != nil {
+		var err error
+		row.ISBN13, err = NormalizeISBN(*row.Info.ISBN)
+		if err != nil && exported {
+			row.ISBN13, row.LegacyISBN = *row.Info.ISBN, true
+		} else if err != nil {
+			return ImportRow{}, fmt.Sprintf("invalid ISBN: %#v", *row.Info.ISBN)
+		}
+	}
+	return row, ""
+}
+
+// `parseCSV` reads rows of a CSV import. Problems of rows are returned
+// as `ImportError`s, and the rest of the input is still read unless the
+// CSV itself is broken.
+func parseCSV(r io.Reader) ([]ImportRow, []ImportError) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, []ImportError{{1, "missing header"}}
+	}
+	if err != nil {
+		return nil, []ImportError{csvError(err)}
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if !contains(importColumns, header[i]) {
+			return nil, []ImportError{{1, fmt.Sprintf("unknown column %#v", name)}}
+		}
+	}
+
+	var rows []ImportRow
+	var errs []ImportError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, errs
+		}
+		if err != nil {
+			return rows, append(errs, csvError(err))
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			errs = append(errs, ImportError{line, fmt.Sprintf(
+				"expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+		values := map[string]string{}
+		for i, value := range record {
+			values[header[i]] = value
+		}
+		row, msg := newImportRow(line, values)
+		if msg != "" {
+			errs = append(errs, ImportError{line, msg})
+			continue
+		}
+		rows = append(rows, row)
+	}
+}
+
+func csvError(err error) ImportError {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return ImportError{pe.Line, pe.Err.Error()}
+	}
+	return ImportError{0, err.Error()}
+}
+
+// `parseNDJSON` reads rows of a JSON Lines import. Blank lines are
+// ignored.
+func parseNDJSON(r io.Reader) ([]ImportRow, []ImportError) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	var rows []ImportRow
+	var errs []ImportError
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var object map[string]interface{}
+		err := json.Unmarshal(data, &object)
+		if err != nil {
+			errs = append(errs, ImportError{line, "invalid JSON object"})
+			continue
+		}
+
+		row, msg := newJSONImportRow(line, object)
+		if msg != "" {
+			errs = append(errs, ImportError{line, msg})
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, ImportError{line + 1, err.Error()})
+	}
+	return rows, errs
+}
+
+// `newJSONImportRow` is `newImportRow` of a JSON object.
+func newJSONImportRow(line int, object map[string]interface{}) (ImportRow, string) {
+	values := map[string]string{}
+	for key, v := range object {
+		if !contains(importColumns, key) {
+			return ImportRow{}, fmt.Sprintf("unknown key %#v", key)
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			values[key] = v
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return ImportRow{}, fmt.Sprintf("invalid value of %#v", key)
+		}
+	}
+	return newImportRow(line, values)
+}
+
+// `parseJSON` reads rows of a JSON snapshot, in which `books` is an
+// array of objects as in JSON Lines. Other keys are ignored. The
+// snapshot is read as a whole.
+func parseJSON(r io.Reader) ([]ImportRow, []ImportError) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, []ImportError{{0, err.Error()}}
+	}
+	lineAt := func(offset int64) int {
+		offset += int64(len(data[offset:]) - len(bytes.TrimLeft(data[offset:], " \t\r\n,")))
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	fail := func() ([]ImportRow, []ImportError) {
+		return nil, []ImportError{{lineAt(dec.InputOffset()), "expected a JSON snapshot"}}
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return fail()
+	}
+
+	var rows []ImportRow
+	var errs []ImportError
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fail()
+		}
+		if key != "books" {
+			var skipped json.RawMessage
+			if dec.Decode(&skipped) != nil {
+				return fail()
+			}
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return fail()
+		}
+		for dec.More() {
+			line := lineAt(dec.InputOffset())
+			var v interface{}
+			if dec.Decode(&v) != nil {
+				return rows, append(errs, ImportError{line, "invalid JSON"})
+			}
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				errs = append(errs, ImportError{line, "invalid JSON object"})
+				continue
+			}
+
+			row, msg := newJSONImportRow(line, object)
+			if msg != "" {
+				errs = append(errs, ImportError{line, msg})
+				continue
+			}
+			rows = append(rows, row)
+		}
+		if _, err := dec.Token(); err != nil {
+			return fail()
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fail()
+	}
+	return rows, errs
+}
+
+// `ImportBooks` imports books from `r` (see above). Books are indexed
+// for full-text search once their batch is written.
+//
+// Returns `ErrInvalidImport` with problems in `ImportResult.Errors` if
+// any row is invalid, in which case nothing is written. May also return
+// `ErrInvalidImportFormat` or `ErrInvalidConflictPolicy`. If writing
+// fails, batches written before stay, and are counted in the result.
+func ImportBooks(db Store, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if opts.OnConflict != ConflictSkip && opts.OnConflict != ConflictUpdate {
+		return ImportResult{}, ErrInvalidConflictPolicy
+	}
+
+	var rows []ImportRow
+	var errs []ImportError
+	switch opts.Format {
+	case ImportCSV:
+		rows, errs = parseCSV(r)
+	case ImportNDJSON:
+		rows, errs = parseNDJSON(r)
+	case ImportJSON:
+		rows, errs = parseJSON(r)
+	default:
+		return ImportResult{}, ErrInvalidImportFormat
+	}
+
+	// An ISBN can only be imported once, in any formatting
+	seen := map[string]int{}
+	for _, row := range rows {
+		if row.ISBN13 == "" {
+			continue
+		}
+		if line, ok := seen[row.ISBN13]; ok {
+			errs = append(errs, ImportError{row.Line, fmt.Sprintf(
+				"duplicate ISBN %#v, first in line %d", *row.Info.ISBN, line)})
+			continue
+		}
+		seen[row.ISBN13] = row.Line
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return ImportResult{Errors: errs}, ErrInvalidImport
+	}
+
+	var result ImportResult
+	if opts.DryRun {
+		for _, row := range rows {
+			_, exists, err := importedBook(db, row)
+			if err != nil {
+				return ImportResult{}, err
+			}
+			result.count(exists, opts.OnConflict)
+		}
+		return result, nil
+	}
+
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > ImportBatchSize {
+			batch = batch[:ImportBatchSize]
+		}
+		rows = rows[len(batch):]
+
+		var counts ImportResult
+		var changed []int
+		err := db.Atomic(func(tx Store) error {
+			counts, changed = ImportResult{}, nil
+			for _, row := range batch {
+				book_id, exists, err := importRow(tx, row, opts.OnConflict)
+				if err != nil {
+					return err
+				}
+				counts.count(exists, opts.OnConflict)
+				if book_id > 0 {
+					changed = append(changed, book_id)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		result.Created += counts.Created
+		result.Updated += counts.Updated
+		result.Skipped += counts.Skipped
+		for _, book_id := range changed {
+			err = reindexBook(db, book_id)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// `count` adds a row whose ISBN `exists` or not to `r`.
+func (r *ImportResult) count(exists bool, policy string) {
+	switch {
+	case !exists:
+		r.Created++
+	case policy == ConflictUpdate:
+		r.Updated++
+	default:
+		r.Skipped++
+	}
+}
+
+// `importedBook` returns the book with the ISBN of `row`, if any.
+func importedBook(db Store, row ImportRow) (int, bool, error) {
+	if row.ISBN13 == "" {
+		return 0, false, nil
+	}
+	b, err := db.BookByISBN(row.ISBN13)
+	if err == ErrBookNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return b.BookID, true, nil
+}
+
+// `importRow` writes `row` in transaction `tx`. Returns the book
+// created or updated, which is 0 if skipped, and whether its ISBN
+// existed. Legacy ISBNs are stored as they are, which `UpdateBook`
+// refuses.
+func importRow(tx Store, row ImportRow, policy string) (int, bool, error) {
+	book_id, exists, err := importedBook(tx, row)
+	if err != nil {
+		return 0, false, err
+	}
+	if exists && policy == ConflictSkip {
+		return 0, true, nil
+	}
+
+	if !exists {
+		book_id, err = tx.InsertBook()
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	info := row.Info
+	if row.LegacyISBN {
+		info.ISBN = nil
+	}
+	err = UpdateBook(tx, book_id, row.Count, info)
+	if err == nil && row.LegacyISBN {
+		err = tx.ModifyBook(book_id, BookInfo{ISBN: row.Info.ISBN, ISBNDisplay: row.Info.ISBN})
+	}
+	return book_id, exists, err
+}
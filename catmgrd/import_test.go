@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportBooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		word := randString(10)
+		isbn := randISBN()
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 1, BookInfo{Title: strp(word + " old"), ISBN: &isbn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total, err := db.CountBooks(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := fmt.Sprintf("Title,author,isbn,count,comment\n"+
+			"%[1]s one,Knuth,,2,\n"+
+			"\"%[1]s, two\",,%[2]s,3,donated\n"+
+			"%[1]s three,,,,\n", word, isbn)
+
+		// Invalid rows stop the whole import
+		bad := data + ",Nobody,,1,\n" + word + " four,,,-1,\n" + word + " five,,\n" +
+			word + " six,,," + "0,\n" + word + " seven,," + strings.ReplaceAll(isbn, "-", "") + ",1,\n" +
+			word + " eight,,978-0-00-000000-1,1,\n"
+		result, err := ImportBooks(db, strings.NewReader(bad), ImportOptions{Format: ImportCSV})
+		if err != ErrInvalidImport {
+			t.Fatalf("expected: %+v, got: %+v", ErrInvalidImport, err)
+		}
+		lines := []int{}
+		for _, e := range result.Errors {
+			lines = append(lines, e.Line)
+		}
+		if !reflect.DeepEqual(lines, []int{5, 6, 7, 9, 10}) {
+			t.Errorf("unexpected errors: %+v", result.Errors)
+		}
+
+		expectBooks := func(count int) {
+			t.Helper()
+			n, err := db.CountBooks(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != total+count {
+				t.Errorf("expected %d new books, got: %d", count, n-total)
+			}
+		}
+		expectBooks(0)
+
+		tb := []struct {
+			opts     ImportOptions
+			expected ImportResult
+			books    int
+		}{
+			{ImportOptions{ImportCSV, "", true}, ImportResult{Created: 2, Skipped: 1}, 0},
+			{ImportOptions{ImportCSV, ConflictUpdate, true}, ImportResult{Created: 2, Updated: 1}, 0},
+			{ImportOptions{ImportCSV, ConflictSkip, false}, ImportResult{Created: 2, Skipped: 1}, 2},
+			{ImportOptions{ImportCSV, ConflictUpdate, false}, ImportResult{Created: 2, Updated: 1}, 4},
+		}
+		for _, e := range tb {
+			result, err := ImportBooks(db, strings.NewReader(data), e.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, e.expected) {
+				t.Errorf("%+v: expected: %+v, got: %+v", e.opts, e.expected, result)
+			}
+			expectBooks(e.books)
+		}
+
+		b, err := CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Title != word+", two" || b.Comment != "donated" || b.AvailableCount != 4 ||
+			b.Author != "(no author)" {
+			t.Errorf("unexpected book: %+v", b)
+		}
+
+		// Imported books can be searched right away
+		books, info, err := QueryBooks(db, "title:"+word+" author:knuth", PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Total != 2 || books[0].AvailableCount != 2 {
+			t.Errorf("unexpected books: %+v", books)
+		}
+		_, info, err = SearchFullText(db, word+" three", PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Total != 2 {
+			t.Errorf("expected 2 results, got: %d", info.Total)
+		}
+
+		ndjson := fmt.Sprintf(`{"title": "%[1]s eight", "count": 1, "isbn": null}`+"\n\n"+
+			`{"title": "%[1]s nine", "shelf": 3}`+"\n"+
+			`{"title": "%[1]s ten", "count": 1.5}`+"\n"+
+			`["%[1]s"]`+"\n", word)
+		result, err = ImportBooks(db, strings.NewReader(ndjson), ImportOptions{Format: ImportNDJSON})
+		if err != ErrInvalidImport {
+			t.Fatalf("expected: %+v, got: %+v", ErrInvalidImport, err)
+		}
+		lines = []int{}
+		for _, e := range result.Errors {
+			lines = append(lines, e.Line)
+		}
+		if !reflect.DeepEqual(lines, []int{3, 4, 5}) {
+			t.Errorf("unexpected errors: %+v", result.Errors)
+		}
+
+		ndjson = strings.SplitN(ndjson, "\n", 2)[0]
+		result, err = ImportBooks(db, strings.NewReader(ndjson), ImportOptions{Format: ImportNDJSON})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Created != 1 {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		expectBooks(5)
+
+		_, err = ImportBooks(db, strings.NewReader(data), ImportOptions{Format: "xml"})
+		if err != ErrInvalidImportFormat {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidImportFormat, err)
+		}
+		_, err = ImportBooks(db, strings.NewReader(data), ImportOptions{ImportCSV, "replace", false})
+		if err != ErrInvalidConflictPolicy {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidConflictPolicy, err)
+		}
+		result, err = ImportBooks(db, strings.NewReader("title,shelf\n"), ImportOptions{Format: ImportCSV})
+		if err != ErrInvalidImport || len(result.Errors) != 1 || result.Errors[0].Line != 1 {
+			t.Errorf("unexpected result: %+v, %+v", result, err)
+		}
+
+		snapshot := `{"exported_at": "2020-01-01T00:00:00Z", "books": [
+{"book_id": 1, "title": "a"},
+3,
+{"title": ""}], "total": 3}`
+		result, err = ImportBooks(db, strings.NewReader(snapshot), ImportOptions{Format: ImportJSON, DryRun: true})
+		if err != ErrInvalidImport || !reflect.DeepEqual(result.Errors, []ImportError{
+			{3, "invalid JSON object"}, {4, "missing title"},
+		}) {
+			t.Errorf("unexpected result: %+v, %+v", result, err)
+		}
+		result, err = ImportBooks(db, strings.NewReader(`["a"]`), ImportOptions{Format: ImportJSON})
+		if err != ErrInvalidImport || len(result.Errors) != 1 {
+			t.Errorf("unexpected result: %+v, %+v", result, err)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ISBNs are stored as ISBN-13 without hyphens, which is what books are
+// looked up by, and shown as they were entered. ISBN-10s are converted
+// to ISBN-13s. ISBNs in databases of older versions are converted by
+// migration 0014, except invalid ones, which are reported and left as
+// they are.
+
+var ErrInvalidISBN = errors.New("invalid ISBN")
+var ErrDuplicateISBN = errors.New("ISBN already in use")
+
+// `isbnLabel` matches labels such as "ISBN-13:" before ISBNs.
+var isbnLabel = regexp.MustCompile(`(?i)^isbn(-?1[03])?:?\s*`)
+
+// `NormalizeISBN` returns the ISBN-13 of `isbn`, which is an ISBN-10 or
+// ISBN-13 with or without hyphens, spaces and a label "ISBN".
+//
+// Returns `ErrInvalidISBN` if `isbn` is malformed or its check digit is
+// wrong.
+func NormalizeISBN(isbn string) (string, error) {
+	isbn = isbnLabel.ReplaceAllString(strings.TrimSpace(isbn), "")
+	isbn = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, isbn)
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i := 0; i < 10; i++ {
+			var d int
+			switch c := isbn[i]; {
+			case '0' <= c && c <= '9':
+				d = int(c - '0')
+			case i == 9 && (c == 'X' || c == 'x'):
+				d = 10
+			default:
+				return "", ErrInvalidISBN
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", ErrInvalidISBN
+		}
+
+		isbn = "978" + isbn[:9]
+		return isbn + string(rune('0'+ean13Check(isbn))), nil
+
+	case 13:
+		if !isDigits(isbn) || (!strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979")) {
+			return "", ErrInvalidISBN
+		}
+		if ean13Check(isbn[:12]) != int(isbn[12]-'0') {
+			return "", ErrInvalidISBN
+		}
+		return isbn, nil
+
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// `ean13Check` returns the check digit of the first 12 digits of an
+// ISBN-13.
+func ean13Check(digits string) int {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// `isCanonicalISBN` reports whether `isbn` is stored as `NormalizeISBN`
+// returns, rather than left as is by migration 0014.
+func isCanonicalISBN(isbn string) bool {
+	v, err := NormalizeISBN(isbn)
+	return err == nil && v == isbn
+}
+
+// `normalizeStoredISBNs` converts ISBNs in table Book to ISBN-13s in
+// migration 0014. Invalid ISBNs, and those of books with the same
+// ISBN-13 as an earlier book, are reported and left as they are.
+func normalizeStoredISBNs(tx *sql.Tx, dialect Dialect) ([]string, error) {
+	rows, err := tx.Query("SELECT book_id, isbn FROM Book WHERE isbn IS NOT NULL ORDER BY book_id")
+	if err != nil {
+		return nil, err
+	}
+	type stored struct {
+		book_id int
+		isbn    string
+	}
+	var list []stored
+	for rows.Next() {
+		var e stored
+		err := rows.Scan(&e.book_id, &e.isbn)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// ISBN-13s already stored are taken first
+	taken := map[string]int{}
+	for _, e := range list {
+		if isCanonicalISBN(e.isbn) {
+			taken[e.isbn] = e.book_id
+		}
+	}
+
+	var notes []string
+	for _, e := range list {
+		isbn, err := NormalizeISBN(e.isbn)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("book %d: invalid ISBN %#v left as is", e.book_id, e.isbn))
+			continue
+		}
+		if isbn == e.isbn {
+			continue
+		}
+		if book_id, ok := taken[isbn]; ok {
+			notes = append(notes, fmt.Sprintf("book %d: ISBN %#v left as is, as book %d has ISBN %s",
+				e.book_id, e.isbn, book_id, isbn))
+			continue
+		}
+
+		_, err = tx.Exec(dialect.Rebind("UPDATE Book SET isbn = ? WHERE book_id = ?"), isbn, e.book_id)
+		if err != nil {
+			return nil, err
+		}
+		taken[isbn] = e.book_id
+	}
+	return notes, nil
+}
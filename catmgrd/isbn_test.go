@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var isbnCounter = rand.Intn(1e8)
+
+// `randISBN` returns a valid ISBN-13 not used before in this run.
+func randISBN() string {
+	isbnCounter++
+	digits := fmt.Sprintf("979%09d", isbnCounter%1e9)
+	return fmt.Sprintf("%s-%d", digits, ean13Check(digits))
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tb := []struct {
+		isbn     string
+		expected string
+	}{
+		{"978-3-030-41704-8", "9783030417048"},
+		{"9783030417048", "9783030417048"},
+		{" 978 3 030 41704 8 ", "9783030417048"},
+		{"ISBN 978-3-030-41704-8", "9783030417048"},
+		{"isbn-13: 9783030417048", "9783030417048"},
+		{"0-306-40615-2", "9780306406157"},
+		{"ISBN-10: 0306406152", "9780306406157"},
+		{"0-8044-2957-X", "9780804429573"},
+		{"080442957x", "9780804429573"},
+		{"979-10-90636-07-1", "9791090636071"},
+		{"978-3-030-41704-9", ""},
+		{"0-306-40615-3", ""},
+		{"X-306-40615-2", ""},
+		{"977-3-030-41704-8", ""},
+		{"2197-182X", ""},
+		{"978-3-030-41704-8a", ""},
+		{"", ""},
+	}
+	for _, e := range tb {
+		isbn, err := NormalizeISBN(e.isbn)
+		if e.expected == "" {
+			if err != ErrInvalidISBN {
+				t.Errorf("%q: expected: %+v, got: %q, %+v", e.isbn, ErrInvalidISBN, isbn, err)
+			}
+			continue
+		}
+		if err != nil || isbn != e.expected {
+			t.Errorf("%q: expected: %q, got: %q, %+v", e.isbn, e.expected, isbn, err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		isbn := randISBN()
+		if _, err := NormalizeISBN(isbn); err != nil {
+			t.Errorf("%q: %+v", isbn, err)
+		}
+	}
+}
+
+func TestBookISBN(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isbn13 := randISBN()
+		digits := strings.ReplaceAll(isbn13, "-", "")
+		err = UpdateBook(db, book_id, 0, BookInfo{ISBN: strp(" " + isbn13 + " ")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := CheckoutBook(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.ISBN != isbn13 || b.ISBN13 != digits {
+			t.Errorf("unexpected ISBNs: %q, %q", b.ISBN, b.ISBN13)
+		}
+
+		for _, isbn := range []string{isbn13, digits, "ISBN " + digits} {
+			b, err := CheckoutISBN(db, isbn)
+			if err != nil || b.BookID != book_id {
+				t.Errorf("%q: unexpected book: %+v, %+v", isbn, b, err)
+			}
+		}
+		b, err = CheckoutISBN(db, "978-0-306-40615-7")
+		if err != ErrBookNotFound {
+			t.Errorf("expected: %+v, got: %+v", ErrBookNotFound, err)
+		}
+		// Invalid ISBNs of samples are matched as they are
+		b, err = CheckoutISBN(db, "2197-182X")
+		if err != nil || b.Title != "Cornerstones" || b.ISBN13 != "" {
+			t.Errorf("unexpected book: %+v, %+v", b, err)
+		}
+
+		for _, query := range []string{"isbn:=" + digits, "isbn:=ISBN" + isbn13, "isbn:" + isbn13, "isbn:" + digits[3:]} {
+			books, _, err := QueryBooks(db, query, PageRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(books) != 1 || books[0].BookID != book_id {
+				t.Errorf("%q: unexpected books: %+v", query, books)
+			}
+		}
+
+		other, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tb := []struct {
+			isbn string
+			err  error
+		}{
+			{"978-3-030-41704-9", ErrInvalidISBN},
+			{"2197-182X", ErrInvalidISBN},
+			{digits, ErrDuplicateISBN},
+			{"0-306-40615-2", nil},
+			{"", nil},
+			{"", nil},
+		}
+		for _, e := range tb {
+			err := UpdateBook(db, other, 0, BookInfo{ISBN: &e.isbn})
+			if err != e.err {
+				t.Errorf("%q: expected: %+v, got: %+v", e.isbn, e.err, err)
+			}
+		}
+		b, err = CheckoutBook(db, other)
+		if err != nil || b.ISBN != "(no isbn)" || b.ISBN13 != "" {
+			t.Errorf("unexpected book: %+v, %+v", b, err)
+		}
+
+		// Books keep their own ISBNs
+		err = UpdateBook(db, book_id, 0, BookInfo{ISBN: &digits})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Items are physical copies of books, each identified by a unique
+// barcode. Available counts of books are derived from item status:
+// only available items can be borrowed, and a copy kept for a ready
+// hold (see hold.go) is reserved. Borrowed items are loaned until
+// returned, and in transit if returned at another branch until
+// received at home (see branch.go). Lost and withdrawn items are out
+// of circulation.
+type ItemStatus string
+
+const (
+	ItemAvailable ItemStatus = "available"
+	ItemLoaned    ItemStatus = "loaned"
+	ItemReserved  ItemStatus = "reserved"
+	ItemInTransit ItemStatus = "in_transit"
+	ItemLost      ItemStatus = "lost"
+	ItemWithdrawn ItemStatus = "withdrawn"
+)
+
+// `MaxBarcodeLength` is the size of the barcode column.
+const MaxBarcodeLength = 64
+
+var ErrInvalidItemID = errors.New("invalid item ID")
+var ErrInvalidBarcode = errors.New("invalid barcode")
+var ErrDuplicateBarcode = errors.New("barcode already in use")
+var ErrItemNotAvailable = errors.New("item is not available")
+var ErrInvalidItemStatus = errors.New("invalid item status")
+var ErrItemInUse = errors.New("item is on loan, reserved or in transit")
+
+// `checkBarcode` returns `ErrInvalidBarcode` if `barcode` is empty,
+// too long, or contains spaces.
+func checkBarcode(barcode string) error {
+	if barcode == "" || len(barcode) > MaxBarcodeLength ||
+		strings.ContainsAny(barcode, " \t\r\n") {
+		return ErrInvalidBarcode
+	}
+	return nil
+}
+
+// `AddItem` adds an available copy of `book_id` with `barcode` to
+// `branch_id`, acquired at `acquired_at`. A barcode "<book_id>-<n>" is
+// generated if `barcode` is empty. The copy goes to the next holder of
+// the book if any.
+//
+// May return `ErrInvalidBookID`, `ErrInvalidBranchID`,
+// `ErrInvalidBarcode` or `ErrDuplicateBarcode`.
+func AddItem(db Store, book_id, branch_id int, barcode string, acquired_at time.Time) (Item, error) {
+	var it Item
+	err := db.Atomic(func(db Store) error {
+		_, err := db.BookByID(book_id)
+		if err == ErrBookNotFound {
+			return ErrInvalidBookID
+		}
+		if err != nil {
+			return err
+		}
+		err = checkBranch(db, branch_id)
+		if err != nil {
+			return err
+		}
+
+		if barcode == "" {
+			list, err := newItems(db, book_id, branch_id, 1, acquired_at)
+			if err != nil {
+				return err
+			}
+			it = list[0]
+		} else {
+			err = checkBarcode(barcode)
+			if err != nil {
+				return err
+			}
+			_, err = db.ItemByBarcode(barcode)
+			if err == nil {
+				return ErrDuplicateBarcode
+			}
+			if err != ErrInvalidBarcode {
+				return err
+			}
+
+			it = Item{
+				BookID:     book_id,
+				BranchID:   branch_id,
+				Barcode:    barcode,
+				Status:     ItemAvailable,
+				AcquiredAt: acquired_at,
+			}
+			it.ItemID, err = db.InsertItem(it)
+			if err != nil {
+				return err
+			}
+		}
+
+		return serveHolds(db, book_id, time.Now())
+	})
+	if err != nil {
+		return Item{}, err
+	}
+
+	return db.ItemByID(it.ItemID)
+}
+
+// `newItems` adds `count` available copies of `book_id` with generated
+// barcodes to `branch_id`. Holds are not served.
+func newItems(db Store, book_id, branch_id, count int, acquired_at time.Time) ([]Item, error) {
+	existing, err := db.ListItems(book_id)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(existing)
+	list := make([]Item, 0, count)
+	for len(list) < count {
+		n++
+		barcode := fmt.Sprintf("%d-%d", book_id, n)
+		_, err := db.ItemByBarcode(barcode)
+		if err == nil {
+			continue
+		}
+		if err != ErrInvalidBarcode {
+			return nil, err
+		}
+
+		it := Item{
+			BookID:     book_id,
+			BranchID:   branch_id,
+			Barcode:    barcode,
+			Status:     ItemAvailable,
+			AcquiredAt: acquired_at,
+		}
+		it.ItemID, err = db.InsertItem(it)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, it)
+	}
+	return list, nil
+}
+
+// `withdrawItems` withdraws `count` available copies of `book_id`.
+//
+// Returns `ErrNoAvailableBook` if there are not enough of them.
+func withdrawItems(db Store, book_id, count int) error {
+	for i := 0; i < count; i++ {
+		it, err := db.AvailableItem(book_id, 0)
+		if err != nil {
+			return err
+		}
+		if it.ItemID == 0 {
+			return ErrNoAvailableBook
+		}
+
+		err = db.ChangeItemStatus(it.ItemID, ItemAvailable, ItemWithdrawn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// `CheckoutItem` returns the item with `barcode`.
+//
+// May return `ErrInvalidBarcode`.
+func CheckoutItem(db Store, barcode string) (Item, error) {
+	return db.ItemByBarcode(barcode)
+}
+
+// `ListItems` returns all copies of `book_id`, including those out of
+// circulation.
+//
+// May return `ErrInvalidBookID`.
+func ListItems(db Store, book_id int) ([]Item, error) {
+	_, err := db.BookByID(book_id)
+	if err == ErrBookNotFound {
+		return nil, ErrInvalidBookID
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return db.ListItems(book_id)
+}
+
+// `SetItemStatus` marks the item with `barcode` as available, lost or
+// withdrawn. Items on loan, reserved for a hold or in transit are
+// changed by borrowing, returning, holds and `ReceiveItem` only. Items
+// put back go to the next holder of the book if any.
+//
+// May return `ErrInvalidBarcode`, `ErrInvalidItemStatus` or
+// `ErrItemInUse`.
+func SetItemStatus(db Store, barcode string, status ItemStatus) error {
+	if status != ItemAvailable && status != ItemLost && status != ItemWithdrawn {
+		return ErrInvalidItemStatus
+	}
+
+	return db.Atomic(func(db Store) error {
+		it, err := db.ItemByBarcode(barcode)
+		if err != nil {
+			return err
+		}
+		if it.Status == ItemLoaned || it.Status == ItemReserved ||
+			it.Status == ItemInTransit {
+			return ErrItemInUse
+		}
+		if it.Status == status {
+			return nil
+		}
+
+		if status == ItemAvailable {
+			return releaseItem(db, it.ItemID, it.BookID, time.Now())
+		}
+		err = db.ChangeItemStatus(it.ItemID, it.Status, status)
+		if err == ErrNoAvailableBook {
+			return ErrItemInUse
+		}
+		return err
+	})
+}
+
+// `releaseItem` puts item `item_id` of `book_id` back on the shelf,
+// where it goes to the next holder if any.
+func releaseItem(db Store, item_id, book_id int, now time.Time) error {
+	err := db.SetItemStatus(item_id, ItemAvailable)
+	if err != nil {
+		return err
+	}
+	return serveHolds(db, book_id, now)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestItem(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		var users [2]int
+		for i := range users {
+			var err error
+			users[i], err = AddUser(db, 3, randString(8), "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectAvailable := func(count int) {
+			t.Helper()
+			b, err := CheckoutBook(db, book_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.AvailableCount != count {
+				t.Errorf("expected %d available, got: %d", count, b.AvailableCount)
+			}
+		}
+		expectStatus := func(barcode string, status ItemStatus) {
+			t.Helper()
+			it, err := CheckoutItem(db, barcode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if it.Status != status {
+				t.Errorf("expected: %s, got: %s", status, it.Status)
+			}
+		}
+
+		barcode := "T" + randString(12)
+		it, err := AddItem(db, book_id, DefaultBranchID, barcode, parseDate("2020-01-01"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.Barcode != barcode || it.BookID != book_id || it.Status != ItemAvailable ||
+			!it.AcquiredAt.Equal(parseDate("2020-01-01")) {
+			t.Errorf("unexpected item: %+v", it)
+		}
+		generated, err := AddItem(db, book_id, DefaultBranchID, "", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generated.Barcode != fmt.Sprintf("%d-2", book_id) {
+			t.Errorf("unexpected barcode: %q", generated.Barcode)
+		}
+		expectAvailable(2)
+
+		tb := []struct {
+			book_id int
+			barcode string
+			err     error
+		}{
+			{book_id, barcode, ErrDuplicateBarcode},
+			{book_id, "a b", ErrInvalidBarcode},
+			{-1, "T" + randString(12), ErrInvalidBookID},
+		}
+		for _, e := range tb {
+			_, err = AddItem(db, e.book_id, DefaultBranchID, e.barcode, time.Now())
+			if err != e.err {
+				t.Errorf("expected: %+v, got: %+v", e.err, err)
+			}
+		}
+
+		// Specific copies are borrowed and returned by barcode
+		record_id, err := BorrowItem(db, users[0], barcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := CheckoutItemLoan(db, barcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.RecordID != record_id || r.Barcode != barcode || r.ItemID != it.ItemID {
+			t.Errorf("unexpected record: %+v", r)
+		}
+		expectStatus(barcode, ItemLoaned)
+		expectAvailable(1)
+
+		_, err = BorrowItem(db, users[1], barcode)
+		if err != ErrItemNotAvailable {
+			t.Errorf("expected: %+v, got: %+v", ErrItemNotAvailable, err)
+		}
+		_, err = BorrowItem(db, users[1], "nonexistent")
+		if err != ErrInvalidBarcode {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBarcode, err)
+		}
+		err = SetItemStatus(db, barcode, ItemLost)
+		if err != ErrItemInUse {
+			t.Errorf("expected: %+v, got: %+v", ErrItemInUse, err)
+		}
+
+		err = ReturnBook(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CheckoutItemLoan(db, barcode)
+		if err != ErrAlreadyReturned {
+			t.Errorf("expected: %+v, got: %+v", ErrAlreadyReturned, err)
+		}
+		expectStatus(barcode, ItemAvailable)
+		expectAvailable(2)
+
+		// Copies out of circulation are not counted
+		err = SetItemStatus(db, barcode, ItemLost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetItemStatus(db, barcode, ItemLoaned)
+		if err != ErrInvalidItemStatus {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidItemStatus, err)
+		}
+		expectAvailable(1)
+		err = UpdateBook(db, book_id, -2, BookInfo{})
+		if err != ErrNoAvailableBook {
+			t.Errorf("expected: %+v, got: %+v", ErrNoAvailableBook, err)
+		}
+		err = UpdateBook(db, book_id, -1, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(generated.Barcode, ItemWithdrawn)
+		expectAvailable(0)
+
+		// Found copies go to holders
+		hold_id, err := PlaceHold(db, users[1], book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetItemStatus(db, barcode, ItemAvailable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(barcode, ItemReserved)
+		h, err := CheckoutHold(db, hold_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Status != HoldReady || h.ItemID != it.ItemID {
+			t.Errorf("unexpected hold: %+v", h)
+		}
+		_, err = BorrowItem(db, users[0], barcode)
+		if err != ErrItemNotAvailable {
+			t.Errorf("expected: %+v, got: %+v", ErrItemNotAvailable, err)
+		}
+		_, err = BorrowItem(db, users[1], barcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list, err := ListItems(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Status != ItemLoaned || list[1].Status != ItemWithdrawn {
+			t.Errorf("unexpected items: %+v", list)
+		}
+		_, err = ListItems(db, -1)
+		if err != ErrInvalidBookID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBookID, err)
+		}
+	})
+}
+
+func TestBorrowItemConcurrently(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		var users [8]int
+		for i := range users {
+			var err error
+			users[i], err = AddUser(db, 3, randString(8), "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AddItem(db, book_id, DefaultBranchID, "", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The only copy is lent once, however the borrows interleave
+		var wg sync.WaitGroup
+		errs := make([]error, len(users))
+		for i := range users {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = BorrowBook(db, users[i], book_id)
+			}(i)
+		}
+		wg.Wait()
+
+		lent := 0
+		for _, err := range errs {
+			if err == nil {
+				lent++
+			}
+		}
+		if lent != 1 {
+			t.Errorf("expected 1 loan, got: %d (%v)", lent, errs)
+		}
+		items, err := ListItems(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Status != ItemLoaned {
+			t.Errorf("unexpected items: %+v", items)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// Failed password attempts are counted per user and per remote
+// address, in table AuthFailure. Once the count reaches
+// `LockoutThreshold`, the subject is locked for `LockoutBase`, and the
+// lock time doubles on every further failure, up to `LockoutMax`.
+// Counters are forgotten `LockoutReset` after the last failure.
+// A successful login resets the counter of the user, but not that of
+// the address, so one cannot clear it with an account of one's own.
+var (
+	LockoutThreshold = 5
+	LockoutBase      = time.Minute
+	LockoutMax       = time.Hour
+	LockoutReset     = 24 * time.Hour
+)
+
+var ErrAccountLocked = errors.New("too many failed attempts, try again later")
+
+func userSubject(user_id int) string {
+	return fmt.Sprintf("user:%d", user_id)
+}
+
+// `addrSubject` drops the port of `addr` if present.
+func addrSubject(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil {
+		addr = host
+	}
+	return "addr:" + addr
+}
+
+// `lockDuration` is how long a subject is locked after `failures`
+// failed attempts.
+func lockDuration(failures int) time.Duration {
+	if failures < LockoutThreshold {
+		return 0
+	}
+
+	d := LockoutBase
+	for i := LockoutThreshold; i < failures && d < LockoutMax; i++ {
+		d *= 2
+	}
+	if d > LockoutMax {
+		d = LockoutMax
+	}
+	return d
+}
+
+// `checkLocked` returns `ErrAccountLocked` if any of `subjects` is
+// locked at `now`.
+func checkLocked(db Store, subjects []string, now time.Time) error {
+	for _, subject := range subjects {
+		f, err := db.AuthFailure(subject)
+		if err != nil {
+			return err
+		}
+		if now.Before(f.LockedUntil) {
+			return ErrAccountLocked
+		}
+	}
+	return nil
+}
+
+// `recordFailure` increases failure counters of `subjects`, and locks
+// them if necessary.
+func recordFailure(db Store, subjects []string, now time.Time) error {
+	return db.Atomic(func(db Store) error {
+		for _, subject := range subjects {
+			f, err := db.AuthFailure(subject)
+			if err != nil {
+				return err
+			}
+
+			if now.Sub(f.LastFailure) > LockoutReset {
+				f.Failures = 0
+			}
+			f.Failures++
+			f.LastFailure = now
+			if d := lockDuration(f.Failures); d > 0 {
+				f.LockedUntil = now.Add(d)
+				log.Printf("lockout: %s locked for %v after %d failures", subject, d, f.Failures)
+			}
+
+			err = db.SetAuthFailure(f)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// `Unlock` clears failure counters of `user_id` (if positive) and
+// `addr` (if not empty).
+func Unlock(db Store, user_id int, addr string) error {
+	return db.Atomic(func(db Store) error {
+		if user_id > 0 {
+			err := db.DeleteAuthFailure(userSubject(user_id))
+			if err != nil {
+				return err
+			}
+		}
+		if addr != "" {
+			err := db.DeleteAuthFailure(addrSubject(addr))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
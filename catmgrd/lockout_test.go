@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	tb := []struct {
+		failures int
+		d        time.Duration
+	}{
+		{0, 0},
+		{LockoutThreshold - 1, 0},
+		{LockoutThreshold, LockoutBase},
+		{LockoutThreshold + 1, 2 * LockoutBase},
+		{LockoutThreshold + 2, 4 * LockoutBase},
+		{LockoutThreshold + 100, LockoutMax},
+	}
+
+	for _, e := range tb {
+		if d := lockDuration(e.failures); d != e.d {
+			t.Errorf("%d: expected: %v, got: %v", e.failures, e.d, d)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		name := randString(16)
+		user_id, err := AddUser(db, 3, name, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := "10.0.0.1:23333"
+		for i := 0; i < LockoutThreshold; i++ {
+			_, err := AuthUserFrom(db, addr, name, "wrong", PermissionSet{})
+			if err != ErrInvalidPassword {
+				t.Errorf("attempt %d: expected: %+v, got: %+v", i, ErrInvalidPassword, err)
+			}
+		}
+
+		// locked even with the right password
+		_, err = AuthUserFrom(db, "10.0.0.2:23333", name, "123456", PermissionSet{})
+		if err != ErrAccountLocked {
+			t.Errorf("expected: %+v, got: %+v", ErrAccountLocked, err)
+		}
+		// the address is locked for all users
+		_, err = AuthUserFrom(db, "10.0.0.1:12345", "riteme", "123456", PermissionSet{})
+		if err != ErrAccountLocked {
+			t.Errorf("expected: %+v, got: %+v", ErrAccountLocked, err)
+		}
+		_, err = AuthUserFrom(db, "10.0.0.2:23333", "riteme", "123456", PermissionSet{})
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+
+		err = Unlock(db, user_id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUserFrom(db, "10.0.0.2:23333", name, "123456", PermissionSet{})
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+		_, err = AuthUserFrom(db, addr, name, "123456", PermissionSet{})
+		if err != ErrAccountLocked {
+			t.Errorf("expected: %+v, got: %+v", ErrAccountLocked, err)
+		}
+
+		err = Unlock(db, 0, "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthUserFrom(db, addr, name, "123456", PermissionSet{})
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+
+		// unknown users count against the address only
+		for i := 0; i < LockoutThreshold; i++ {
+			AuthUserFrom(db, "10.0.0.3", "nobody", "123456", PermissionSet{})
+		}
+		_, err = AuthUserFrom(db, "10.0.0.3", name, "123456", PermissionSet{})
+		if err != ErrAccountLocked {
+			t.Errorf("expected: %+v, got: %+v", ErrAccountLocked, err)
+		}
+		_, err = AuthUser(db, name, "123456", PermissionSet{})
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+	})
+}
@@ -1,46 +1,159 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-var db *sql.DB
+var db Store
 
 func main() {
 	addr := flag.String("listen", ":10777", "address for catmgrd server listening to")
+	auto_migrate := flag.Bool("auto-migrate", false, "apply pending schema migrations before serving")
+	tls_cert := flag.String("tls-cert", "", "TLS certificate file in PEM format, reloaded on SIGHUP")
+	tls_key := flag.String("tls-key", "", "TLS private key file in PEM format, reloaded on SIGHUP")
+	tls_self_signed := flag.Bool("tls-self-signed", false, "serve HTTPS with a throwaway self-signed certificate (for development only)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  catmgrd [flags]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  catmgrd migrate up|down|status [version]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  catmgrd import [-format csv|ndjson|json] [-on-conflict skip|update] [-dry-run] file\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  catmgrd export [-format csv|ndjson|json] [-section section -keyword keyword] [file]\n\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	config, err := LoadMySQLConfig("catmgrd.json")
+	config, err := LoadConfig("catmgrd.json")
 	if err != nil {
 		panic(err)
 	}
 
-	db, err = ConnectMySQL(config)
+	if config.LegacyPasswordCutoff != "" {
+		LegacyPasswordCutoff, err = time.Parse("2006-01-02", config.LegacyPasswordCutoff)
+		if err != nil {
+			log.Fatal("invalid legacy_password_cutoff: ", err)
+		}
+	}
+	if config.SessionLifetime != "" {
+		SessionLifetime, err = time.ParseDuration(config.SessionLifetime)
+		if err != nil || SessionLifetime <= 0 {
+			log.Fatal("invalid session_lifetime: ", config.SessionLifetime)
+		}
+	}
+	if config.LockoutThreshold > 0 {
+		LockoutThreshold = config.LockoutThreshold
+	}
+
+	conn, dialect, err := ConnectDatabase(config.DatabaseConfig)
 	if err != nil {
 		panic(err)
 	}
+	store := NewSQLStore(conn, dialect)
+	db = store
+
+	if flag.NArg() > 0 {
+		err = runCommand(store, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *auto_migrate {
+		done, err := store.MigrateUp(-1)
+		for _, m := range done {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+			for _, note := range m.Notes {
+				log.Printf("  %s", note)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	current, latest, err := store.CheckSchema()
+	if err == ErrSchemaTooNew {
+		log.Fatalf("%v (database: %d, catmgrd: %d)", err, current, latest)
+	}
+	if err != nil {
+		log.Fatalf("%v. run \"catmgrd migrate up\" or start with -auto-migrate", err)
+	}
+	log.Printf("database schema version: %d/%d", current, latest)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handleRoot)
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/logout", handleLogout)
 	mux.HandleFunc("/new", handleNew)
 	mux.HandleFunc("/update", handleUpdate)
+	mux.HandleFunc("/import", handleImport)
+	mux.HandleFunc("/export", handleExport)
 	mux.HandleFunc("/adduser", handleAddUser)
+	mux.HandleFunc("/usertype/new", handleNewUserType)
+	mux.HandleFunc("/usertype/list", handleListUserTypes)
+	mux.HandleFunc("/usertype/update", handleUpdateUserType)
+	mux.HandleFunc("/usertype/delete", handleDeleteUserType)
+	mux.HandleFunc("/policy/show", handleShowPolicy)
+	mux.HandleFunc("/policy/update", handleUpdatePolicy)
+	mux.HandleFunc("/policy/book/show", handleShowBookPolicy)
+	mux.HandleFunc("/policy/book/update", handleUpdateBookPolicy)
+	mux.HandleFunc("/deluser", handleDelUser)
+	mux.HandleFunc("/deactivate", handleDeactivate)
+	mux.HandleFunc("/reactivate", handleReactivate)
+	mux.HandleFunc("/expire", handleExpire)
+	mux.HandleFunc("/settype", handleSetType)
+	mux.HandleFunc("/passwd", handlePasswd)
+	mux.HandleFunc("/unlock", handleUnlock)
+	mux.HandleFunc("/apikey/new", handleNewAPIKey)
+	mux.HandleFunc("/apikey/list", handleListAPIKeys)
+	mux.HandleFunc("/apikey/revoke", handleRevokeAPIKey)
+	mux.HandleFunc("/item/add", handleAddItem)
+	mux.HandleFunc("/item/list", handleListItems)
+	mux.HandleFunc("/item/status", handleSetItemStatus)
+	mux.HandleFunc("/item/receive", handleReceiveItem)
+	mux.HandleFunc("/branch/new", handleNewBranch)
+	mux.HandleFunc("/branch/list", handleListBranches)
 	mux.HandleFunc("/show", handleShow)
 	mux.HandleFunc("/list", handleList)
 	mux.HandleFunc("/borrow", handleBorrow)
+	mux.HandleFunc("/hold", handleHold)
+	mux.HandleFunc("/hold/list", handleListHolds)
+	mux.HandleFunc("/hold/cancel", handleCancelHold)
+	mux.HandleFunc("/fine/balance", handleFineBalance)
+	mux.HandleFunc("/fine/pay", handlePayFine)
+	mux.HandleFunc("/fine/waive", handleWaiveFine)
 	mux.HandleFunc("/extend", handleExtend)
 	mux.HandleFunc("/return", handleReturn)
 
-	log.Println("start catmgrd")
-	log.Fatal(http.ListenAndServe(*addr, mux))
+	ResetFullTextOnSignal()
+
+	if *tls_cert == "" && *tls_key == "" {
+		*tls_cert = config.TLSCert
+		*tls_key = config.TLSKey
+	}
+	server := &http.Server{Addr: *addr, Handler: mux}
+	use_tls, err := ConfigureTLS(server, *tls_cert, *tls_key, *tls_self_signed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if use_tls {
+		log.Println("start catmgrd (HTTPS)")
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	} else {
+		log.Println("start catmgrd")
+		log.Fatal(server.ListenAndServe())
+	}
 }
 
 var MErrDecodePayload = NewMError("failed to decode payload")
@@ -51,6 +164,61 @@ func handleRoot(resp http.ResponseWriter, req *http.Request) {
 	SendJSON(resp, MHello{"ok", "Hello, world!"})
 }
 
+func handleLogin(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/login\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	token, session, err := Login(db, req.RemoteAddr, params.User, params.Password)
+	if err == ErrInvalidUser || err == ErrInvalidPassword ||
+		err == ErrLegacyPassword || err == ErrAccountLocked ||
+		err == ErrInactiveUser || err == ErrExpiredUser ||
+		err == ErrMustChangePassword {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during login"))
+	} else {
+		log.Printf("new session: %d (user %d)", session.SessionID, session.UserID)
+		SendJSON(resp, MLogin{"ok", session.UserID, token, session.ExpiresAt})
+	}
+}
+
+func handleLogout(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/logout\"")
+
+	var params struct {
+		All bool `json:"all"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	token, ok := bearerToken(req)
+	if !ok {
+		SendJSON(resp, NewMError("missing bearer token"))
+		return
+	}
+
+	err := Logout(db, token, params.All)
+	if err == ErrInvalidSession {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during logout"))
+	} else {
+		SendJSON(resp, MHello{"ok", "logged out"})
+	}
+}
+
 func handleNew(resp http.ResponseWriter, req *http.Request) {
 	log.Println(req.RemoteAddr, "access \"/new\"")
 
@@ -58,8 +226,10 @@ func handleNew(resp http.ResponseWriter, req *http.Request) {
 		User     interface{} `json:"user"`
 		Password string      `json:"password"`
 	}
-	if !DecodePayload(resp, req, &params) ||
-		!AuthRequest(resp, req, params.User, params.Password, Permission{Update: true}) {
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermBookCreate); !ok {
 		return
 	}
 
@@ -87,8 +257,10 @@ func handleUpdate(resp http.ResponseWriter, req *http.Request) {
 		Description *string     `json:"description"`
 		Comment     *string     `json:"comment"`
 	}
-	if !DecodePayload(resp, req, &params) ||
-		!AuthRequest(resp, req, params.User, params.Password, Permission{Update: true}) {
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermBookUpdate); !ok {
 		return
 	}
 
@@ -105,7 +277,8 @@ func handleUpdate(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	err := UpdateBook(db, params.BookID, diff, info)
-	if err == ErrInvalidBookID {
+	if err == ErrInvalidBookID || err == ErrNoAvailableBook ||
+		err == ErrInvalidISBN || err == ErrDuplicateISBN {
 		log.Println(err)
 		SendJSON(resp, err)
 	} else if err != nil {
@@ -117,6 +290,80 @@ func handleUpdate(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func handleImport(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/import\"")
+
+	var params struct {
+		User       interface{} `json:"user"`
+		Password   string      `json:"password"`
+		Format     string      `json:"format"`
+		Data       string      `json:"data"`
+		OnConflict string      `json:"on_conflict"`
+		DryRun     bool        `json:"dry_run"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermBookCreate, PermBookUpdate); !ok {
+		return
+	}
+
+	opts := ImportOptions{params.Format, params.OnConflict, params.DryRun}
+	result, err := ImportBooks(db, strings.NewReader(params.Data), opts)
+	if err == ErrInvalidImport {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, MImport{"failed", err.Error(), params.DryRun, result})
+	} else if err == ErrInvalidImportFormat || err == ErrInvalidConflictPolicy {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during importing books"))
+	} else {
+		if !params.DryRun {
+			log.Printf("import books: %d created, %d updated, %d skipped",
+				result.Created, result.Updated, result.Skipped)
+		}
+		SendJSON(resp, MImport{"ok", "", params.DryRun, result})
+	}
+}
+
+func handleExport(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/export\"")
+
+	var params struct {
+		Format  string `json:"format"`
+		Section string `json:"section"`
+		Keyword string `json:"keyword"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	export, err := NewBookExport(db, params.Format, ExportFilter{params.Section, params.Keyword})
+	if err == ErrInvalidExportFormat || err == ErrInvalidExportSection ||
+		errors.Is(err, ErrInvalidQuery) {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+		return
+	}
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during exporting books"))
+		return
+	}
+
+	// Books are streamed, so later errors only cut the export short
+	resp.Header().Set("Content-Type", export.ContentType())
+	resp.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"catalog.%s\"", params.Format))
+	n, err := export.Write(resp)
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+	}
+	log.Printf("export books: %d", n)
+}
+
 func handleAddUser(resp http.ResponseWriter, req *http.Request) {
 	log.Println(req.RemoteAddr, "access \"/adduser\"")
 
@@ -142,16 +389,25 @@ func handleAddUser(resp http.ResponseWriter, req *http.Request) {
 		SendJSON(resp, NewMError("missing field: new_password"))
 		return
 	}
-	if !AuthRequest(resp, req, params.User, params.Password, Permission{AddUser: true}) {
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserCreate)
+	if !ok {
 		return
 	}
 
 	type_id, err := GetUserTypeID(db, *params.NewUserType)
+	if err == nil {
+		err = CheckTypeGrant(db, u, type_id)
+	}
 	if err == ErrInvalidUserType {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, NewMError("invalid user type"))
 		return
 	}
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("add user of type %d", type_id))
+		SendJSON(resp, err)
+		return
+	}
 	if err != nil {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, NewMError("error occurred during examining user type"))
@@ -163,206 +419,1478 @@ func handleAddUser(resp http.ResponseWriter, req *http.Request) {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, NewMError("error occurred during adding user"))
 	} else {
-		log.Printf("user added: %d", user_id)
+		log.Printf("user added: %d (by %d)", user_id, u.UserID)
 		SendJSON(resp, MAddUser{"ok", user_id})
 	}
 }
 
-func handleShow(resp http.ResponseWriter, req *http.Request) {
-	log.Println(req.RemoteAddr, "access \"/show\"")
+func handleNewUserType(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/usertype/new\"")
 
 	var params struct {
-		Section string `json:"section"`
-		Keyword string `json:"keyword"`
+		User        interface{}   `json:"user"`
+		Password    string        `json:"password"`
+		TypeName    string        `json:"type_name"`
+		Permissions PermissionSet `json:"permissions"`
 	}
 	if !DecodePayload(resp, req, &params) {
 		return
 	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserTypeManage)
+	if !ok {
+		return
+	}
 
-	books := make([]Book, 1)
-	var err error
-	switch params.Section {
-	case "book_id":
-		book_id, parse_err := strconv.ParseInt(params.Keyword, 10, 32)
-		if parse_err != nil {
-			log.Println(req.RemoteAddr, err)
-			SendJSON(resp, NewMError("invalid book ID"))
-			return
-		}
-		books[0], err = CheckoutBook(db, int(book_id))
-	case "isbn":
-		books[0], err = CheckoutISBN(db, params.Keyword)
-	case "title":
-		books, err = SearchBookByTitle(db, params.Keyword)
-	case "author":
-		books, err = SearchBookByAuthor(db, params.Keyword)
-	default:
-		SendJSON(resp, NewMError(fmt.Sprintf("unknown section name: %#v", params.Section)))
+	if CheckGrant(u, params.Permissions) != nil {
+		logEscalation(req, u, fmt.Sprintf("add user type with %v", params.Permissions))
+		SendJSON(resp, ErrPrivilegeEscalation)
 		return
 	}
 
-	if err == ErrBookNotFound {
+	type_id, err := NewUserType(db, UserType{TypeName: params.TypeName, Perm: params.Permissions})
+	if err == ErrEmptyTypeName || err == ErrDuplicateUserType ||
+		err == ErrUnknownPermission {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, err)
 	} else if err != nil {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during retrieving book information"))
+		SendJSON(resp, NewMError("an error occurred during adding user type"))
 	} else {
-		SendJSON(resp, MBookList{"ok", books})
+		log.Printf("user type added: %d (by %d)", type_id, u.UserID)
+		SendJSON(resp, MUserType{"ok", type_id})
 	}
 }
 
-func handleList(resp http.ResponseWriter, req *http.Request) {
-	log.Println(req.RemoteAddr, "access \"/list\"")
+func handleListUserTypes(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/usertype/list\"")
 
 	var params struct {
 		User     interface{} `json:"user"`
 		Password string      `json:"password"`
-		Target   interface{} `json:"target"`
-		Filter   string      `json:"filter"`
-		Limit    *int        `json:"limit"`
 	}
 	if !DecodePayload(resp, req, &params) {
 		return
 	}
-
-	limit := 100
-	if params.Limit != nil {
-		limit = *params.Limit
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermUserTypeManage); !ok {
+		return
 	}
 
-	user_id, err := ObtainUserID(params.User)
-	if err == ErrInvalidUser {
+	list, err := ListUserTypes(db)
+	if err != nil {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving user types"))
+	} else {
+		SendJSON(resp, MUserTypeList{"ok", list})
+	}
+}
+
+func handleUpdateUserType(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/usertype/update\"")
+
+	var params struct {
+		User        interface{}    `json:"user"`
+		Password    string         `json:"password"`
+		TypeID      int            `json:"type_id"`
+		TypeName    *string        `json:"type_name"`
+		Permissions *PermissionSet `json:"permissions"`
+	}
+	if !DecodePayload(resp, req, &params) {
 		return
 	}
-	if err != nil {
-		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during retrieving user"))
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserTypeManage)
+	if !ok {
 		return
 	}
 
-	target_id, err := ObtainUserID(params.Target)
-	if err == ErrInvalidUser {
+	// Missing fields are left unchanged
+	err := db.Atomic(func(db Store) error {
+		t, err := db.UserTypeByID(params.TypeID)
+		if err != nil {
+			return err
+		}
+		// Both old and new permissions must be within those of `u`
+		err = CheckGrant(u, t.Perm)
+		if err != nil {
+			return err
+		}
+		if params.TypeName != nil {
+			t.TypeName = *params.TypeName
+		}
+		if params.Permissions != nil {
+			t.Perm = *params.Permissions
+		}
+		err = CheckGrant(u, t.Perm)
+		if err != nil {
+			return err
+		}
+		return UpdateUserType(db, t)
+	})
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("update user type %d", params.TypeID))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUserType || err == ErrEmptyTypeName ||
+		err == ErrDuplicateUserType || err == ErrLastAdminType ||
+		err == ErrUnknownPermission {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, err)
-		return
-	}
-	if err != nil {
+	} else if err != nil {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during retrieving user"))
-		return
+		SendJSON(resp, NewMError("an error occurred during updating user type"))
+	} else {
+		log.Printf("user type updated: %d (by %d)", params.TypeID, u.UserID)
+		SendJSON(resp, MUserType{"ok", params.TypeID})
 	}
+}
 
-	perms := Permission{Inspect: user_id != target_id}
-	if !AuthRequest(resp, req, user_id, params.Password, perms) {
+func handleDeleteUserType(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/usertype/delete\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		TypeID   int         `json:"type_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
 		return
 	}
-
-	today := time.Now()
-	filter := ""
-	args := []interface{}{}
-	switch params.Filter {
-	case "all":
-		filter = ""
-	case "not-returned":
-		filter = "return_date IS NULL"
-	case "overdue":
-		filter = "return_date IS NULL AND deadline < ?"
-		args = []interface{}{today}
-	default:
-		SendJSON(resp, NewMError("invalid filter type"))
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserTypeManage)
+	if !ok {
 		return
 	}
 
-	list, err := CheckoutHistory(db, target_id, limit, filter, args...)
-	if err != nil {
+	err := db.Atomic(func(db Store) error {
+		err := CheckTypeGrant(db, u, params.TypeID)
+		if err != nil {
+			return err
+		}
+		return DeleteUserType(db, params.TypeID)
+	})
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("delete user type %d", params.TypeID))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUserType || err == ErrUserTypeInUse || err == ErrLastAdminType {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during retrieving borrow history"))
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during deleting user type"))
 	} else {
-		SendJSON(resp, MRecordList{"ok", list})
+		log.Printf("user type deleted: %d (by %d)", params.TypeID, u.UserID)
+		SendJSON(resp, MUserType{"ok", params.TypeID})
 	}
 }
 
-func handleBorrow(resp http.ResponseWriter, req *http.Request) {
-	log.Println(req.RemoteAddr, "access \"/borrow\"")
+func handleShowPolicy(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/policy/show\"")
 
 	var params struct {
 		User     interface{} `json:"user"`
 		Password string      `json:"password"`
-		BookID   int         `json:"book_id"`
+		TypeID   int         `json:"type_id"`
 	}
-	if !DecodePayload(resp, req, &params) ||
-		!AuthRequest(resp, req, params.User, params.Password, Permission{Borrow: true}) {
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermPolicyManage); !ok {
 		return
 	}
 
-	user_id, err := ObtainUserID(params.User)
-	if err != nil {
+	p, err := GetPolicy(db, params.TypeID)
+	if err == ErrInvalidUserType {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during retrieving user"))
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving policy"))
+	} else {
+		SendJSON(resp, MPolicy{"ok", params.TypeID, p})
+	}
+}
+
+func handleUpdatePolicy(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/policy/update\"")
+
+	var params struct {
+		User             interface{} `json:"user"`
+		Password         string      `json:"password"`
+		TypeID           int         `json:"type_id"`
+		LoanDays         *int        `json:"loan_days"`
+		FinalDays        *int        `json:"final_days"`
+		ExtendWindowDays *int        `json:"extend_window_days"`
+		ExtendDays       *int        `json:"extend_days"`
+		MaxOverdue       *int        `json:"max_overdue"`
+		MaxLoans         *int        `json:"max_loans"`
+		PickupDays       *int        `json:"pickup_days"`
+		FineRate         *int        `json:"fine_rate"`
+		FineCap          *int        `json:"fine_cap"`
+		MaxBalance       *int        `json:"max_balance"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermPolicyManage)
+	if !ok {
 		return
 	}
 
-	record_id, err := BorrowBook(db, user_id, params.BookID)
-	if err == ErrInvalidBookID || err == ErrSuspendedUser ||
-		err == ErrNoAvailableBook {
+	// Missing fields are left unchanged
+	var p Policy
+	err := db.Atomic(func(db Store) error {
+		var err error
+		p, err = GetPolicy(db, params.TypeID)
+		if err != nil {
+			return err
+		}
+		if params.LoanDays != nil {
+			p.LoanDays = *params.LoanDays
+		}
+		if params.FinalDays != nil {
+			p.FinalDays = *params.FinalDays
+		}
+		if params.ExtendWindowDays != nil {
+			p.ExtendWindowDays = *params.ExtendWindowDays
+		}
+		if params.ExtendDays != nil {
+			p.ExtendDays = *params.ExtendDays
+		}
+		if params.MaxOverdue != nil {
+			p.MaxOverdue = *params.MaxOverdue
+		}
+		if params.MaxLoans != nil {
+			p.MaxLoans = *params.MaxLoans
+		}
+		if params.PickupDays != nil {
+			p.PickupDays = *params.PickupDays
+		}
+		if params.FineRate != nil {
+			p.FineRate = *params.FineRate
+		}
+		if params.FineCap != nil {
+			p.FineCap = *params.FineCap
+		}
+		if params.MaxBalance != nil {
+			p.MaxBalance = *params.MaxBalance
+		}
+		return SetPolicy(db, params.TypeID, p)
+	})
+	if err == ErrInvalidUserType || err == ErrInvalidPolicy {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, err)
 	} else if err != nil {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during borrowing book"))
+		SendJSON(resp, NewMError("an error occurred during updating policy"))
 	} else {
-		log.Printf("new record: %d", record_id)
-		SendJSON(resp, MRecord{"ok", record_id})
+		log.Printf("policy updated: type %d (by %d)", params.TypeID, u.UserID)
+		SendJSON(resp, MPolicy{"ok", params.TypeID, p})
 	}
 }
 
-func handleExtend(resp http.ResponseWriter, req *http.Request) {
-	log.Println(req.RemoteAddr, "access \"/extend\"")
+func handleShowBookPolicy(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/policy/book/show\"")
 
 	var params struct {
 		User     interface{} `json:"user"`
 		Password string      `json:"password"`
-		RecordID int         `json:"record_id"`
+		BookID   int         `json:"book_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
 	}
-	if !DecodePayload(resp, req, &params) ||
-		!AuthRequest(resp, req, params.User, params.Password, Permission{}) ||
-		!CheckRecordID(resp, req, params.RecordID, params.User) {
+	if _, ok := AuthRequest(resp, req, params.User, params.Password, PermPolicyManage); !ok {
 		return
 	}
 
-	err := ExtendDeadline(db, params.RecordID)
-	if err == ErrAlreadyReturned || err == ErrOverdue ||
-		err == ErrNotExtensible || err == ErrFinalDeadline {
+	o, err := GetBookPolicy(db, params.BookID)
+	if err == ErrInvalidBookID {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, err)
 	} else if err != nil {
 		log.Println(req.RemoteAddr, err)
-		SendJSON(resp, NewMError("an error occurred during extending deadline"))
+		SendJSON(resp, NewMError("an error occurred during retrieving policy"))
 	} else {
-		log.Printf("extend deadline: %d", params.RecordID)
-		SendJSON(resp, MRecord{"ok", params.RecordID})
+		SendJSON(resp, MBookPolicy{"ok", params.BookID, o})
 	}
 }
 
-func handleReturn(resp http.ResponseWriter, req *http.Request) {
-	log.Println(req.RemoteAddr, "access \"/return\"")
+func handleUpdateBookPolicy(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/policy/book/update\"")
 
 	var params struct {
-		User     interface{} `json:"user"`
-		Password string      `json:"password"`
-		RecordID int         `json:"record_id"`
+		User     interface{}    `json:"user"`
+		Password string         `json:"password"`
+		BookID   int            `json:"book_id"`
+		Override PolicyOverride `json:"override"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermPolicyManage)
+	if !ok {
+		return
+	}
+
+	// The override is replaced as a whole, and {} removes it
+	err := SetBookPolicy(db, params.BookID, params.Override)
+	if err == ErrInvalidBookID || err == ErrInvalidPolicy {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during updating policy"))
+	} else {
+		log.Printf("policy updated: book %d (by %d)", params.BookID, u.UserID)
+		SendJSON(resp, MBookPolicy{"ok", params.BookID, params.Override})
+	}
+}
+
+// `authUserAdmin` authenticates an administrator (i.e. with
+// `PermUserManage`) who operates on user `target`, and returns the
+// administrator and ID of the target. Administrators cannot operate on
+// themselves, or on users with permissions beyond their own (see
+// `CheckGrant`).
+func authUserAdmin(resp http.ResponseWriter, req *http.Request, user interface{}, password string, target interface{}) (User, int, bool) {
+	u, ok := AuthRequest(resp, req, user, password, PermUserManage)
+	if !ok {
+		return User{}, -1, false
+	}
+
+	target_id, err := ObtainUserID(target)
+	var t User
+	if err == nil {
+		t, err = db.UserByID(target_id)
+	}
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+		return User{}, -1, false
+	}
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving user"))
+		return User{}, -1, false
+	}
+	if t.UserID == u.UserID {
+		SendJSON(resp, NewMError("cannot operate on yourself"))
+		return User{}, -1, false
+	}
+	if CheckGrant(u, t.Perm) != nil {
+		logEscalation(req, u, fmt.Sprintf("manage user %d", t.UserID))
+		SendJSON(resp, ErrPrivilegeEscalation)
+		return User{}, -1, false
+	}
+
+	return u, t.UserID, true
+}
+
+func handleDelUser(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/deluser\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	admin, target_id, ok := authUserAdmin(resp, req, params.User, params.Password, params.Target)
+	if !ok {
+		return
+	}
+
+	anonymized, err := DeleteUser(db, target_id)
+	if err == ErrInvalidUser || err == ErrUnreturnedBooks {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during deleting user"))
+	} else if anonymized {
+		log.Printf("user anonymized: %d (by %d)", target_id, admin.UserID)
+		SendJSON(resp, MDelUser{"ok", target_id, true})
+	} else {
+		log.Printf("user deleted: %d (by %d)", target_id, admin.UserID)
+		SendJSON(resp, MDelUser{"ok", target_id, false})
+	}
+}
+
+func handleDeactivate(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/deactivate\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	admin, target_id, ok := authUserAdmin(resp, req, params.User, params.Password, params.Target)
+	if !ok {
+		return
+	}
+
+	err := DeactivateUser(db, target_id)
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during deactivating user"))
+	} else {
+		log.Printf("user deactivated: %d (by %d)", target_id, admin.UserID)
+		SendJSON(resp, MAddUser{"ok", target_id})
+	}
+}
+
+func handleReactivate(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/reactivate\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	admin, target_id, ok := authUserAdmin(resp, req, params.User, params.Password, params.Target)
+	if !ok {
+		return
+	}
+
+	err := ReactivateUser(db, target_id)
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during reactivating user"))
+	} else {
+		log.Printf("user reactivated: %d (by %d)", target_id, admin.UserID)
+		SendJSON(resp, MAddUser{"ok", target_id})
+	}
+}
+
+func handleExpire(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/expire\"")
+
+	var params struct {
+		User      interface{} `json:"user"`
+		Password  string      `json:"password"`
+		Target    interface{} `json:"target"`
+		ExpiresAt string      `json:"expires_at"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	var expires_at time.Time
+	if params.ExpiresAt != "" {
+		var err error
+		expires_at, err = time.Parse("2006-01-02", params.ExpiresAt)
+		if err != nil {
+			SendJSON(resp, NewMError("invalid date, expected YYYY-MM-DD"))
+			return
+		}
+	}
+
+	admin, target_id, ok := authUserAdmin(resp, req, params.User, params.Password, params.Target)
+	if !ok {
+		return
+	}
+
+	err := SetUserExpiry(db, target_id, expires_at)
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during setting expiry date"))
+	} else {
+		log.Printf("user expiry set: %d, %#v (by %d)", target_id, params.ExpiresAt, admin.UserID)
+		SendJSON(resp, MAddUser{"ok", target_id})
+	}
+}
+
+func handleSetType(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/settype\"")
+
+	var params struct {
+		User        interface{} `json:"user"`
+		Password    string      `json:"password"`
+		Target      interface{} `json:"target"`
+		NewUserType *string     `json:"new_user_type"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if params.NewUserType == nil {
+		SendJSON(resp, NewMError("missing field: new_user_type"))
+		return
+	}
+	admin, target_id, ok := authUserAdmin(resp, req, params.User, params.Password, params.Target)
+	if !ok {
+		return
+	}
+
+	type_id, err := GetUserTypeID(db, *params.NewUserType)
+	if err == nil {
+		err = CheckTypeGrant(db, admin, type_id)
+	}
+	if err == nil {
+		err = SetUserType(db, target_id, type_id)
+	}
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, admin, fmt.Sprintf("change user %d to type %d", target_id, type_id))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUser || err == ErrInvalidUserType {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during changing user type"))
+	} else {
+		log.Printf("user type changed: %d, %d (by %d)", target_id, type_id, admin.UserID)
+		SendJSON(resp, MAddUser{"ok", target_id})
+	}
+}
+
+func handlePasswd(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/passwd\"")
+
+	var params struct {
+		User        interface{} `json:"user"`
+		Password    string      `json:"password"`
+		NewPassword *string     `json:"new_password"`
+		Target      interface{} `json:"target"`
+		MustChange  bool        `json:"must_change"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if params.NewPassword == nil {
+		SendJSON(resp, NewMError("missing field: new_password"))
+		return
+	}
+
+	var err error
+	var user_id int
+	if params.Target != nil {
+		// Reset password of another user
+		u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserManage)
+		if !ok {
+			return
+		}
+
+		user_id, err = ObtainUserID(params.Target)
+		var t User
+		if err == nil {
+			t, err = db.UserByID(user_id)
+		}
+		if err == nil {
+			err = CheckGrant(u, t.Perm)
+		}
+		if err == ErrPrivilegeEscalation {
+			logEscalation(req, u, fmt.Sprintf("reset password of user %d", user_id))
+		}
+		if err == nil {
+			err = ResetPassword(db, user_id, *params.NewPassword, params.MustChange)
+		}
+		if err == nil {
+			log.Printf("password reset: %d (by %d)", user_id, u.UserID)
+		}
+	} else {
+		// Change own password. The old password is always required,
+		// even if the request has a session token.
+		user := params.User
+		if _, ok := bearerToken(req); ok && user == nil {
+			u, ok := AuthRequest(resp, req, nil, "")
+			if !ok {
+				return
+			}
+			user = u.UserID
+		}
+
+		err = ChangePassword(db, req.RemoteAddr, user, params.Password, *params.NewPassword)
+		if err == nil {
+			log.Printf("password changed: %v", user)
+		}
+	}
+
+	if err == ErrInvalidUser || err == ErrInvalidPassword ||
+		err == ErrLegacyPassword || err == ErrAccountLocked ||
+		err == ErrInactiveUser || err == ErrExpiredUser ||
+		err == ErrEmptyPassword || err == ErrPrivilegeEscalation {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during changing password"))
+	} else {
+		SendJSON(resp, MHello{"ok", "password changed"})
+	}
+}
+
+func handleUnlock(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/unlock\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+		Address  string      `json:"address"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if params.Target == nil && params.Address == "" {
+		SendJSON(resp, NewMError("missing field: target or address"))
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermUserManage)
+	if !ok {
+		return
+	}
+
+	target_id := 0
+	if params.Target != nil {
+		var err error
+		target_id, err = ObtainUserID(params.Target)
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, ErrInvalidUser)
+			return
+		}
+	}
+
+	err := Unlock(db, target_id, params.Address)
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during unlocking"))
+	} else {
+		log.Printf("lockout: user %d, address %#v unlocked by %d", target_id, params.Address, u.UserID)
+		SendJSON(resp, MHello{"ok", "unlocked"})
+	}
+}
+
+func handleNewAPIKey(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/apikey/new\"")
+
+	var params struct {
+		User     interface{}   `json:"user"`
+		Password string        `json:"password"`
+		Owner    interface{}   `json:"owner"`
+		Name     string        `json:"name"`
+		Scope    PermissionSet `json:"scope"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermAPIKeyManage)
+	if !ok {
+		return
+	}
+
+	owner_id := u.UserID
+	if params.Owner != nil {
+		var err error
+		owner_id, err = ObtainUserID(params.Owner)
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, ErrInvalidUser)
+			return
+		}
+	}
+
+	err := CheckKeyOwner(db, u, owner_id)
+	if err == nil && CheckGrant(u, params.Scope) != nil {
+		err = ErrPrivilegeEscalation
+	}
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("create API key of user %d with %v", owner_id, params.Scope))
+		SendJSON(resp, err)
+		return
+	}
+	if err == ErrInvalidUser || err == ErrPermissionDenied {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+		return
+	}
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during creating API key"))
+		return
+	}
+
+	key, k, err := NewAPIKey(db, owner_id, params.Name, params.Scope)
+	if err == ErrInvalidUser || err == ErrInvalidScope ||
+		err == ErrUnknownPermission {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during creating API key"))
+	} else {
+		log.Printf("new API key: %d (user %d, by %d)", k.KeyID, k.UserID, u.UserID)
+		SendJSON(resp, MNewAPIKey{"ok", k.KeyID, key})
+	}
+}
+
+func handleListAPIKeys(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/apikey/list\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Owner    interface{} `json:"owner"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermAPIKeyManage)
+	if !ok {
+		return
+	}
+
+	owner_id := 0
+	if params.Owner != nil {
+		var err error
+		owner_id, err = ObtainUserID(params.Owner)
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, ErrInvalidUser)
+			return
+		}
+	}
+
+	list, err := ListAPIKeys(db, u, owner_id)
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("list API keys of user %d", owner_id))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUser || err == ErrPermissionDenied {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving API keys"))
+	} else {
+		SendJSON(resp, MAPIKeyList{"ok", list})
+	}
+}
+
+func handleRevokeAPIKey(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/apikey/revoke\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		KeyID    int         `json:"key_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermAPIKeyManage)
+	if !ok {
+		return
+	}
+
+	err := RevokeAPIKey(db, u, params.KeyID)
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("revoke API key %d", params.KeyID))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidAPIKey || err == ErrPermissionDenied {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during revoking API key"))
+	} else {
+		log.Printf("revoke API key: %d (by %d)", params.KeyID, u.UserID)
+		SendJSON(resp, MAPIKey{"ok", params.KeyID})
+	}
+}
+
+func handleAddItem(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/item/add\"")
+
+	var params struct {
+		User       interface{} `json:"user"`
+		Password   string      `json:"password"`
+		BookID     int         `json:"book_id"`
+		BranchID   int         `json:"branch_id"`
+		Barcode    string      `json:"barcode"`
+		AcquiredAt string      `json:"acquired_at"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	if params.BranchID == 0 {
+		params.BranchID = DefaultBranchID
+	}
+
+	// Defaults to today
+	acquired_at := time.Now()
+	if params.AcquiredAt != "" {
+		var err error
+		acquired_at, err = time.Parse("2006-01-02", params.AcquiredAt)
+		if err != nil {
+			SendJSON(resp, NewMError("invalid date, expected YYYY-MM-DD"))
+			return
+		}
+	}
+
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermBookUpdate)
+	if !ok {
+		return
+	}
+
+	it, err := AddItem(db, params.BookID, params.BranchID, params.Barcode, acquired_at)
+	if err == ErrInvalidBookID || err == ErrInvalidBranchID ||
+		err == ErrInvalidBarcode || err == ErrDuplicateBarcode {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during adding item"))
+	} else {
+		log.Printf("new item: %d, %#v of book %d (by %d)", it.ItemID, it.Barcode, it.BookID, u.UserID)
+		SendJSON(resp, MItem{"ok", it.ItemID, it.Barcode})
+	}
+}
+
+func handleListItems(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/item/list\"")
+
+	var params struct {
+		BookID int `json:"book_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	list, err := ListItems(db, params.BookID)
+	if err == ErrInvalidBookID {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving items"))
+	} else {
+		SendJSON(resp, MItemList{"ok", list})
+	}
+}
+
+func handleSetItemStatus(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/item/status\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Barcode  string      `json:"barcode"`
+		Status   ItemStatus  `json:"status"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermBookUpdate)
+	if !ok {
+		return
+	}
+
+	err := SetItemStatus(db, params.Barcode, params.Status)
+	var it Item
+	if err == nil {
+		it, err = CheckoutItem(db, params.Barcode)
+	}
+	if err == ErrInvalidBarcode || err == ErrInvalidItemStatus ||
+		err == ErrItemInUse {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during updating item"))
+	} else {
+		log.Printf("item status set: %#v, %s (by %d)", it.Barcode, params.Status, u.UserID)
+		SendJSON(resp, MItem{"ok", it.ItemID, it.Barcode})
+	}
+}
+
+func handleReceiveItem(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/item/receive\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Barcode  string      `json:"barcode"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermRecordReturnAny)
+	if !ok {
+		return
+	}
+
+	err := ReceiveItem(db, params.Barcode)
+	var it Item
+	if err == nil {
+		it, err = CheckoutItem(db, params.Barcode)
+	}
+	if err == ErrInvalidBarcode || err == ErrNotInTransit {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during receiving item"))
+	} else {
+		log.Printf("item received: %#v at %d (by %d)", it.Barcode, it.BranchID, u.UserID)
+		SendJSON(resp, MItem{"ok", it.ItemID, it.Barcode})
+	}
+}
+
+func handleNewBranch(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/branch/new\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Name     string      `json:"name"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermBranchManage)
+	if !ok {
+		return
+	}
+
+	branch_id, err := NewBranch(db, params.Name)
+	if err == ErrInvalidBranchName || err == ErrDuplicateBranch {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during adding branch"))
+	} else {
+		log.Printf("branch added: %d (by %d)", branch_id, u.UserID)
+		SendJSON(resp, MBranch{"ok", branch_id})
+	}
+}
+
+func handleListBranches(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/branch/list\"")
+
+	list, err := ListBranches(db)
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving branches"))
+	} else {
+		SendJSON(resp, MBranchList{"ok", list})
+	}
+}
+
+func handleShow(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/show\"")
+
+	var params struct {
+		Section  string `json:"section"`
+		Keyword  string `json:"keyword"`
+		Sort     string `json:"sort"`
+		Cursor   string `json:"cursor"`
+		PageSize int    `json:"page_size"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	// Lists are returned in pages (see page.go)
+	page := PageRequest{params.Sort, params.Cursor, params.PageSize}
+	books := make([]Book, 1)
+	info := PageInfo{Total: 1}
+	var err error
+	switch params.Section {
+	case "book_id":
+		book_id, parse_err := strconv.ParseInt(params.Keyword, 10, 32)
+		if parse_err != nil {
+			log.Println(req.RemoteAddr, parse_err)
+			SendJSON(resp, NewMError("invalid book ID"))
+			return
+		}
+		books[0], err = CheckoutBook(db, int(book_id))
+	case "isbn":
+		books[0], err = CheckoutISBN(db, params.Keyword)
+	case "title", "author":
+		q := &BookQuery{Field: params.Section, Cmp: ":", Text: params.Keyword}
+		books, info, err = PageBooks(db, q, page)
+	case "fulltext":
+		books, info, err = SearchFullText(db, params.Keyword, page)
+	case "query":
+		books, info, err = QueryBooks(db, params.Keyword, page)
+	default:
+		SendJSON(resp, NewMError(fmt.Sprintf("unknown section name: %#v", params.Section)))
+		return
+	}
+
+	if err == ErrBookNotFound || errors.Is(err, ErrInvalidQuery) ||
+		err == ErrInvalidSortKey || err == ErrInvalidPageSize ||
+		err == ErrInvalidCursor {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving book information"))
+	} else {
+		SendJSON(resp, MBookList{"ok", books, info.NextCursor, info.Total})
+	}
+}
+
+func handleList(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/list\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+		Filter   string      `json:"filter"`
+		Limit    *int        `json:"limit"`
+		Sort     string      `json:"sort"`
+		Cursor   string      `json:"cursor"`
+		PageSize int         `json:"page_size"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	// `limit` is the page size of older clients
+	page := PageRequest{params.Sort, params.Cursor, params.PageSize}
+	if page.Size == 0 && params.Limit != nil {
+		page.Size = *params.Limit
+	}
+
+	target_id, err := ObtainUserID(params.Target)
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+		return
+	}
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving user"))
+		return
+	}
+
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok {
+		return
+	}
+	if u.UserID != target_id && !u.Perm.Has(PermRecordInspectAny) {
+		log.Println(req.RemoteAddr, ErrPermissionDenied)
+		SendJSON(resp, ErrPermissionDenied)
+		return
+	}
+
+	today := time.Now()
+	var filter RecordFilter
+	switch params.Filter {
+	case "all":
+		filter = RecordFilter{}
+	case "not-returned":
+		filter = RecordFilter{NotReturned: true}
+	case "overdue":
+		filter = RecordFilter{NotReturned: true, DueBefore: today}
+	default:
+		SendJSON(resp, NewMError("invalid filter type"))
+		return
+	}
+
+	list, info, err := CheckoutHistoryPage(db, target_id, filter, page)
+	if err == ErrInvalidSortKey || err == ErrInvalidPageSize || err == ErrInvalidCursor {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving borrow history"))
+	} else {
+		SendJSON(resp, MRecordList{"ok", list, info.NextCursor, info.Total})
+	}
+}
+
+func handleBorrow(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/borrow\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		BookID   int         `json:"book_id"`
+		Barcode  string      `json:"barcode"`
+		BranchID int         `json:"branch_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermRecordBorrow)
+	if !ok {
+		return
+	}
+
+	// A specific copy can be borrowed by its barcode
+	var record_id int
+	var err error
+	if params.Barcode != "" {
+		record_id, err = BorrowItem(db, u.UserID, params.Barcode)
+	} else {
+		record_id, err = BorrowBookAt(db, u.UserID, params.BookID, params.BranchID)
+	}
+	if err == ErrInvalidBookID || errors.Is(err, ErrSuspendedUser) ||
+		err == ErrTooManyLoans || err == ErrFinesOwed ||
+		err == ErrNoAvailableBook || err == ErrInvalidBarcode ||
+		err == ErrItemNotAvailable || err == ErrInvalidBranchID {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during borrowing book"))
+	} else {
+		log.Printf("new record: %d", record_id)
+		SendJSON(resp, MRecord{"ok", record_id})
+	}
+}
+
+func handleHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/hold\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		BookID   int         `json:"book_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermRecordBorrow)
+	if !ok {
+		return
+	}
+
+	hold_id, err := PlaceHold(db, u.UserID, params.BookID)
+	if err == ErrInvalidBookID || err == ErrDuplicateHold ||
+		err == ErrBookAvailable {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during placing hold"))
+	} else {
+		log.Printf("new hold: %d", hold_id)
+		SendJSON(resp, MHold{"ok", hold_id})
+	}
+}
+
+func handleListHolds(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/hold/list\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok {
+		return
+	}
+
+	// Defaults to the caller
+	target_id := u.UserID
+	if params.Target != nil {
+		var err error
+		target_id, err = ObtainUserID(params.Target)
+		if err == ErrInvalidUser {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, err)
+			return
+		}
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, NewMError("an error occurred during retrieving user"))
+			return
+		}
+	}
+	if u.UserID != target_id && !u.Perm.Has(PermRecordInspectAny) {
+		log.Println(req.RemoteAddr, ErrPermissionDenied)
+		SendJSON(resp, ErrPermissionDenied)
+		return
+	}
+
+	list, err := ListHolds(db, target_id)
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving holds"))
+	} else {
+		SendJSON(resp, MHoldList{"ok", list})
+	}
+}
+
+func handleCancelHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/hold/cancel\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		HoldID   int         `json:"hold_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok {
+		return
+	}
+
+	h, err := CheckoutHold(db, params.HoldID)
+	if err == ErrInvalidHoldID {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+		return
+	}
+	if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during examining hold"))
+		return
+	}
+	if h.UserID != u.UserID && !u.Perm.Has(PermRecordReturnAny) {
+		SendJSON(resp, NewMError("your user ID does not match that of the hold"))
+		return
+	}
+
+	err = CancelHold(db, params.HoldID)
+	if err == ErrInvalidHoldID || err == ErrHoldClosed {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during cancelling hold"))
+	} else {
+		log.Printf("hold cancelled: %d (by %d)", params.HoldID, u.UserID)
+		SendJSON(resp, MHold{"ok", params.HoldID})
+	}
+}
+
+func handleFineBalance(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/fine/balance\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok {
+		return
+	}
+
+	// Defaults to the caller
+	target_id := u.UserID
+	if params.Target != nil {
+		var err error
+		target_id, err = ObtainUserID(params.Target)
+		if err == ErrInvalidUser {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, err)
+			return
+		}
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, NewMError("an error occurred during retrieving user"))
+			return
+		}
+	}
+	if u.UserID != target_id && !u.Perm.Has(PermFineManage) {
+		log.Println(req.RemoteAddr, ErrPermissionDenied)
+		SendJSON(resp, ErrPermissionDenied)
+		return
+	}
+
+	b, err := GetFineBalance(db, target_id, time.Now())
+	var list []Fine
+	if err == nil {
+		list, err = ListFines(db, target_id)
+	}
+	if err == ErrInvalidUser {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during retrieving fines"))
+	} else {
+		SendJSON(resp, MFineBalance{"ok", target_id, b, list})
+	}
+}
+
+func handlePayFine(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/fine/pay\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+		Amount   int         `json:"amount"`
+		Note     string      `json:"note"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermFineManage)
+	if !ok {
+		return
+	}
+
+	target_id, err := ObtainUserID(params.Target)
+	if err == nil {
+		err = CheckUserGrant(db, u, target_id)
+	}
+	fine_id := -1
+	if err == nil {
+		fine_id, err = PayFine(db, target_id, u.UserID, params.Amount, params.Note)
+	}
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("pay fines of user %d", target_id))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUser || err == ErrInvalidAmount ||
+		err == ErrExceedsBalance {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during recording payment"))
+	} else {
+		log.Printf("fine payment recorded: %d for user %d (by %d)", fine_id, target_id, u.UserID)
+		SendJSON(resp, MFine{"ok", fine_id})
+	}
+}
+
+func handleWaiveFine(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/fine/waive\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		Target   interface{} `json:"target"`
+		RecordID int         `json:"record_id"`
+		Amount   int         `json:"amount"`
+		Note     string      `json:"note"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password, PermFineManage)
+	if !ok {
+		return
+	}
+
+	target_id, err := ObtainUserID(params.Target)
+	if err == nil {
+		err = CheckUserGrant(db, u, target_id)
+	}
+	fine_id := -1
+	if err == nil {
+		fine_id, err = WaiveFine(db, target_id, u.UserID, params.RecordID, params.Amount, params.Note)
+	}
+	if err == ErrPrivilegeEscalation {
+		logEscalation(req, u, fmt.Sprintf("waive fines of user %d", target_id))
+		SendJSON(resp, err)
+	} else if err == ErrInvalidUser || err == ErrInvalidRecordID ||
+		err == ErrInvalidAmount || err == ErrExceedsBalance {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during recording waiver"))
+	} else {
+		log.Printf("fine waiver recorded: %d for user %d (by %d)", fine_id, target_id, u.UserID)
+		SendJSON(resp, MFine{"ok", fine_id})
+	}
+}
+
+func handleExtend(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/extend\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		RecordID int         `json:"record_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok || !CheckRecordID(resp, req, params.RecordID, u, "") {
+		return
+	}
+
+	err := ExtendDeadline(db, params.RecordID)
+	if err == ErrAlreadyReturned || err == ErrOverdue ||
+		err == ErrNotExtensible || err == ErrFinalDeadline {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, err)
+	} else if err != nil {
+		log.Println(req.RemoteAddr, err)
+		SendJSON(resp, NewMError("an error occurred during extending deadline"))
+	} else {
+		log.Printf("extend deadline: %d", params.RecordID)
+		SendJSON(resp, MRecord{"ok", params.RecordID})
+	}
+}
+
+func handleReturn(resp http.ResponseWriter, req *http.Request) {
+	log.Println(req.RemoteAddr, "access \"/return\"")
+
+	var params struct {
+		User     interface{} `json:"user"`
+		Password string      `json:"password"`
+		RecordID int         `json:"record_id"`
+		Barcode  string      `json:"barcode"`
+		BranchID int         `json:"branch_id"`
+	}
+	if !DecodePayload(resp, req, &params) {
+		return
+	}
+	u, ok := AuthRequest(resp, req, params.User, params.Password)
+	if !ok {
+		return
+	}
+
+	// Copies can be returned by barcode instead of record ID
+	if params.Barcode != "" {
+		r, err := CheckoutItemLoan(db, params.Barcode)
+		if err == ErrInvalidBarcode || err == ErrAlreadyReturned {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, err)
+			return
+		}
+		if err != nil {
+			log.Println(req.RemoteAddr, err)
+			SendJSON(resp, NewMError("an error occurred during examining item"))
+			return
+		}
+		params.RecordID = r.RecordID
 	}
-	if !DecodePayload(resp, req, &params) ||
-		!AuthRequest(resp, req, params.User, params.Password, Permission{}) ||
-		!CheckRecordID(resp, req, params.RecordID, params.User) {
+	if !CheckRecordID(resp, req, params.RecordID, u, PermRecordReturnAny) {
 		return
 	}
 
-	err := ReturnBook(db, params.RecordID)
-	if err == ErrInvalidRecordID || err == ErrAlreadyReturned {
+	// Copies returned at another branch than their home are in transit
+	err := ReturnBookAt(db, params.RecordID, params.BranchID)
+	if err == ErrInvalidRecordID || err == ErrAlreadyReturned ||
+		err == ErrInvalidBranchID {
 		log.Println(req.RemoteAddr, err)
 		SendJSON(resp, err)
 	} else if err != nil {
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration files are named "<version>_<name>.up.sql" and
+// "<version>_<name>.down.sql", one directory per dialect.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// `Notes` are reported by the hook of the migration when it is
+// applied (see `migrationHooks`).
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	Notes   []string
+}
+
+// `migrationHooks` run after the up scripts of their versions, in the
+// same transactions, for changes that SQL cannot express. They return
+// notes for the administrator.
+var migrationHooks = map[int]func(tx *sql.Tx, dialect Dialect) ([]string, error){
+	14: normalizeStoredISBNs,
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this catmgrd")
+
+// `LoadMigrations` returns all migrations of `dialect` sorted by version.
+func LoadMigrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", dialect.Name())
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := index[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			index[version] = m
+		}
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("conflicting names for migration %d: %s, %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	list := []Migration{}
+	for _, m := range index {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) must have both up and down files", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// `LatestSchemaVersion` is the version of the last migration of
+// `dialect`, i.e. the schema version this binary works with.
+func LatestSchemaVersion(dialect Dialect) (int, error) {
+	list, err := LoadMigrations(dialect)
+	if err != nil {
+		return -1, err
+	}
+	if len(list) == 0 {
+		return 0, nil
+	}
+	return list[len(list)-1].Version, nil
+}
+
+// `splitStatements` splits `script` at semicolons outside string
+// literals, quoted identifiers and comments. Empty statements are dropped.
+func splitStatements(script string) []string {
+	var list []string
+	start := 0
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(script[i+1:], c)
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j + 1
+			}
+		case strings.HasPrefix(script[i:], "--"):
+			j := strings.IndexByte(script[i:], '\n')
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j
+			}
+		case c == ';':
+			list = append(list, script[start:i])
+			start = i + 1
+		}
+	}
+	list = append(list, script[start:])
+
+	stmts := []string{}
+	for _, stmt := range list {
+		if !isBlankSQL(stmt) {
+			stmts = append(stmts, strings.TrimSpace(stmt))
+		}
+	}
+	return stmts
+}
+
+// `isBlankSQL` reports whether `stmt` contains nothing but comments.
+func isBlankSQL(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *SQLStore) ensureMigrationTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			version INT NOT NULL PRIMARY KEY,
+			name VARCHAR(128) NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`)
+	return err
+}
+
+// `appliedMigrations` returns applied versions and their timestamps.
+func (s *SQLStore) appliedMigrations() (map[int]time.Time, error) {
+	err := s.ensureMigrationTable()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var applied_at time.Time
+		err := rows.Scan(&version, &applied_at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = applied_at.UTC()
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// `SchemaVersion` returns the highest applied migration version of the
+// database, or 0 if none has been applied.
+func (s *SQLStore) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return -1, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// `MigrationStatus` lists all known migrations and whether they have
+// been applied.
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	list, err := LoadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []MigrationStatus{}
+	for _, m := range list {
+		applied_at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{m, ok, applied_at})
+	}
+	return status, nil
+}
+
+// `runMigration` executes the up or down script of `m` and updates
+// schema_migrations in one transaction, and returns the notes of the
+// hook of `m` if any. MySQL commits DDL statements implicitly, so a
+// failed migration may be left half-applied there.
+func (s *SQLStore) runMigration(m Migration, up bool) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	for _, stmt := range splitStatements(script) {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+		}
+	}
+
+	var notes []string
+	if hook, ok := migrationHooks[m.Version]; ok && up {
+		notes, err = hook(tx, s.dialect)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(s.dialect.Rebind(
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec(s.dialect.Rebind(
+			"DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, tx.Commit()
+}
+
+// `MigrateUp` applies all pending migrations with versions not greater
+// than `target`. A negative `target` means the latest version.
+// Returns the migrations applied.
+func (s *SQLStore) MigrateUp(target int) ([]Migration, error) {
+	list, err := LoadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, m := range list {
+		if target >= 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		m.Notes, err = s.runMigration(m, true)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// `MigrateDown` reverts applied migrations with versions greater than
+// `target`, latest first. Returns the migrations reverted.
+func (s *SQLStore) MigrateDown(target int) ([]Migration, error) {
+	list, err := LoadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		_, err := s.runMigration(m, false)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// `CheckSchema` compares the schema version of the database with the
+// latest migration. It returns the two versions, and an error if they
+// differ. `ErrSchemaTooNew` is returned when the database is newer.
+func (s *SQLStore) CheckSchema() (int, int, error) {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	latest, err := LatestSchemaVersion(s.dialect)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	if current > latest {
+		return current, latest, ErrSchemaTooNew
+	}
+	if current < latest {
+		return current, latest, fmt.Errorf(
+			"database schema (version %d) is behind catmgrd (version %d)", current, latest)
+	}
+
+	return current, latest, nil
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- comment; not a statement
+CREATE TABLE A(x VARCHAR(8) DEFAULT ';');
+INSERT INTO A VALUES ('a;b'); -- trailing; comment
+
+  -- only comment
+`
+	expect := []string{
+		"-- comment; not a statement\nCREATE TABLE A(x VARCHAR(8) DEFAULT ';')",
+		"INSERT INTO A VALUES ('a;b')",
+	}
+
+	got := splitStatements(script)
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected: %#v, got: %#v", expect, got)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{"mysql", "sqlite", "postgres"} {
+		dialect, _ := GetDialect(driver)
+		list, err := LoadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 || list[0].Version != 1 {
+			t.Fatalf("%s: migrations must start from version 1", driver)
+		}
+		for i, m := range list {
+			if m.Version != i+1 {
+				t.Errorf("%s: expected version %d, got %d", driver, i+1, m.Version)
+			}
+		}
+	}
+
+	mysql, _ := GetDialect("mysql")
+	latest, _ := LatestSchemaVersion(mysql)
+	for _, driver := range []string{"sqlite", "postgres"} {
+		dialect, _ := GetDialect(driver)
+		v, _ := LatestSchemaVersion(dialect)
+		if v != latest {
+			t.Errorf("%s: latest version is %d, but %d for mysql", driver, v, latest)
+		}
+	}
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE TABLE (?:IF NOT EXISTS )?(\S+?)\s*\((.*)\)$`)
+	alterTablePattern  = regexp.MustCompile(`(?i)^ALTER TABLE (\S+)`)
+	uniquePattern      = regexp.MustCompile(`(?i)(?:PRIMARY KEY|UNIQUE)\s*\(([^)]*)\)`)
+	uniqueIndexPattern = regexp.MustCompile(`(?i)^CREATE UNIQUE INDEX \S+ ON (\S+?)\s*\(([^)]*)\)`)
+	referencePattern   = regexp.MustCompile(`(?i)REFERENCES\s+(\S+?)\s*\(([^)]*)\)`)
+)
+
+// `sqlKey` normalizes a table name or list of columns.
+func sqlKey(s string) string {
+	return strings.ToLower(strings.NewReplacer("\"", "", "`", "", " ", "").Replace(s))
+}
+
+// `TestMigrationForeignKeys` checks that foreign keys of the MySQL and
+// PostgreSQL migrations reference primary keys or unique keys, which
+// both require, without a database server to run them on.
+func TestMigrationForeignKeys(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres"} {
+		dialect, _ := GetDialect(driver)
+		list, err := LoadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := map[string]bool{} // "table(columns)"
+		for _, m := range list {
+			for _, stmt := range splitStatements(m.Up) {
+				var lines []string
+				for _, line := range strings.Split(stmt, "\n") {
+					if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+						lines = append(lines, line)
+					}
+				}
+				stmt = strings.TrimSpace(strings.Join(lines, "\n"))
+
+				var table string
+				if g := createTablePattern.FindStringSubmatch(stmt); g != nil {
+					table = sqlKey(g[1])
+					for _, def := range strings.Split(g[2], ",\n") {
+						def = strings.TrimSpace(def)
+						upper := strings.ToUpper(def)
+						if !strings.HasPrefix(upper, "PRIMARY KEY") && !strings.HasPrefix(upper, "UNIQUE") &&
+							(strings.Contains(upper, " PRIMARY KEY") || strings.Contains(upper, " UNIQUE")) {
+							keys[table+"("+sqlKey(strings.Fields(def)[0])+")"] = true
+						}
+					}
+				} else if g := alterTablePattern.FindStringSubmatch(stmt); g != nil {
+					table = sqlKey(g[1])
+				} else if g := uniqueIndexPattern.FindStringSubmatch(stmt); g != nil {
+					keys[sqlKey(g[1])+"("+sqlKey(g[2])+")"] = true
+				}
+				if table != "" {
+					for _, g := range uniquePattern.FindAllStringSubmatch(stmt, -1) {
+						keys[table+"("+sqlKey(g[1])+")"] = true
+					}
+				}
+
+				for _, g := range referencePattern.FindAllStringSubmatch(stmt, -1) {
+					key := sqlKey(g[1]) + "(" + sqlKey(g[2]) + ")"
+					if !keys[key] {
+						t.Errorf("%s: %04d_%s: %s is not a unique key", driver, m.Version, m.Name, key)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catmgrd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, dialect, err := ConnectDatabase(DatabaseConfig{
+		Driver: "sqlite",
+		File:   filepath.Join(dir, "migrate.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLStore(conn, dialect)
+	defer s.Close()
+
+	latest, err := LatestSchemaVersion(dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = s.CheckSchema()
+	if err == nil {
+		t.Error("empty database should be behind")
+	}
+
+	done, err := s.MigrateUp(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != latest {
+		t.Errorf("expected %d migrations applied, got %d", latest, len(done))
+	}
+	current, _, err := s.CheckSchema()
+	if err != nil || current != latest {
+		t.Fatalf("expected version %d, got %d (%v)", latest, current, err)
+	}
+
+	done, err = s.MigrateUp(-1)
+	if err != nil || len(done) != 0 {
+		t.Errorf("expected nothing applied, got %d (%v)", len(done), err)
+	}
+
+	_, err = s.MigrateDown(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err = s.SchemaVersion()
+	if err != nil || current != 0 {
+		t.Fatalf("expected version 0, got %d (%v)", current, err)
+	}
+
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.Applied {
+			t.Errorf("migration %d should not be applied", m.Version)
+		}
+	}
+
+	// all tables must have been dropped, so that migrating up again works
+	_, err = s.MigrateUp(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadSamples(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Old permission flags must be converted by migration 0008.
+func TestMigratePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catmgrd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, dialect, err := ConnectDatabase(DatabaseConfig{
+		Driver: "sqlite",
+		File:   filepath.Join(dir, "migrate.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLStore(conn, dialect)
+	defer s.Close()
+
+	_, err = s.MigrateUp(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"INSERT INTO UserType VALUES (1, 'librarian', true, false, false, true)",
+		"INSERT INTO UserType VALUES (2, 'reader', false, false, true, false)",
+		"INSERT INTO User (type_id, name, token) VALUES (1, 'alice', 'x')",
+		`INSERT INTO ApiKey
+			(user_id, name, key_hash, can_update, can_adduser, can_borrow, can_inspect, created_at)
+		VALUES (1, 'kiosk', 'x', false, false, false, true, '2020-01-01 00:00:00')`,
+	} {
+		_, err = s.exec(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = s.MigrateUp(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb := []struct {
+		type_id int
+		perm    PermissionSet
+	}{
+		{1, NewPermissionSet(
+			PermBookCreate, PermBookUpdate, PermBookDelete,
+			PermRecordReturnAny, PermRecordInspectAny)},
+		{2, PermissionSet{PermRecordBorrow}},
+	}
+	for _, e := range tb {
+		ut, err := s.UserTypeByID(e.type_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ut.Perm, e.perm) {
+			t.Errorf("type %d: expected: %v, got: %v", e.type_id, e.perm, ut.Perm)
+		}
+	}
+	k, err := s.APIKeyByHash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(k.Scope, PermissionSet{PermRecordInspectAny}) {
+		t.Errorf("unexpected scope: %v", k.Scope)
+	}
+
+	_, err = s.MigrateDown(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var update, borrow, inspect bool
+	err = s.queryRow(
+		"SELECT can_update, can_borrow, can_inspect FROM UserType WHERE type_id = 1").
+		Scan(&update, &borrow, &inspect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update || borrow || !inspect {
+		t.Errorf("flags not restored: %v, %v, %v", update, borrow, inspect)
+	}
+}
+
+// Available counts must be converted to items by migration 0012.
+func TestMigrateItems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catmgrd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, dialect, err := ConnectDatabase(DatabaseConfig{
+		Driver: "sqlite",
+		File:   filepath.Join(dir, "migrate.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLStore(conn, dialect)
+	defer s.Close()
+
+	_, err = s.MigrateUp(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"INSERT INTO UserType (type_name) VALUES ('reader')",
+		"INSERT INTO User (type_id, name, token) VALUES (1, 'alice', 'x')",
+		"INSERT INTO User (type_id, name, token) VALUES (1, 'bob', 'x')",
+		"INSERT INTO Book (title, available_count) VALUES ('a', 2)",
+		"INSERT INTO Book (title, available_count) VALUES ('b', 0)",
+		`INSERT INTO Record
+			(user_id, book_id, borrow_date, deadline, final_deadline)
+		VALUES (1, 1, '2020-01-01', '2020-02-01', '2020-03-01')`,
+		`INSERT INTO Hold
+			(user_id, book_id, status, created_at, expires_at)
+		VALUES (2, 2, 'ready', '2020-01-01 00:00:00', '2999-01-01 00:00:00')`,
+	} {
+		_, err = s.exec(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Later migrations are applied too, as the store expects the latest
+	// schema. Existing items belong to the default branch (0013).
+	_, err = s.MigrateUp(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb := []struct {
+		book_id int
+		count   int
+		items   []string
+	}{
+		{1, 2, []string{"1-1", "1-2", "1-R1"}},
+		{2, 0, []string{"2-H1"}},
+	}
+	for _, e := range tb {
+		b, err := s.BookByID(e.book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.AvailableCount != e.count {
+			t.Errorf("book %d: expected %d available, got: %d", e.book_id, e.count, b.AvailableCount)
+		}
+
+		list, err := s.ListItems(e.book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var barcodes []string
+		for _, it := range list {
+			barcodes = append(barcodes, it.Barcode)
+		}
+		sort.Strings(barcodes)
+		if !reflect.DeepEqual(barcodes, e.items) {
+			t.Errorf("book %d: expected: %v, got: %v", e.book_id, e.items, barcodes)
+		}
+	}
+	r, err := s.RecordByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Barcode != "1-R1" {
+		t.Errorf("record not linked to its item: %+v", r)
+	}
+	h, err := s.HoldByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := s.ItemByID(h.ItemID)
+	if err != nil || it.Barcode != "2-H1" || it.Status != ItemReserved ||
+		it.BranchID != DefaultBranchID {
+		t.Errorf("hold not linked to its item: %+v (%v)", it, err)
+	}
+
+	_, err = s.MigrateDown(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = s.queryRow("SELECT available_count FROM Book WHERE book_id = 1").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 available, got: %d", count)
+	}
+}
+
+func TestMigrateISBNs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catmgrd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, dialect, err := ConnectDatabase(DatabaseConfig{
+		Driver: "sqlite",
+		File:   filepath.Join(dir, "migrate.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLStore(conn, dialect)
+	defer s.Close()
+
+	_, err = s.MigrateUp(13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isbns := []string{"978-3-030-41704-8", "0-306-40615-2", "2197-182X", "9783030417048", ""}
+	for _, isbn := range isbns {
+		_, err = s.exec("INSERT INTO Book (title, isbn) VALUES ('a', NULLIF(?, ''))", isbn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Invalid ISBNs and duplicates are reported, not rewritten
+	done, err := s.MigrateUp(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notes []string
+	for _, m := range done {
+		notes = append(notes, m.Notes...)
+	}
+	expected := []string{
+		`book 1: ISBN "978-3-030-41704-8" left as is, as book 4 has ISBN 9783030417048`,
+		`book 3: invalid ISBN "2197-182X" left as is`,
+	}
+	if !reflect.DeepEqual(notes, expected) {
+		t.Errorf("expected: %q, got: %q", expected, notes)
+	}
+
+	tb := []struct {
+		isbn   string
+		isbn13 string
+	}{
+		{"978-3-030-41704-8", ""},
+		{"0-306-40615-2", "9780306406157"},
+		{"2197-182X", ""},
+		{"9783030417048", "9783030417048"},
+		{"(no isbn)", ""},
+	}
+	for i, e := range tb {
+		b, err := s.BookByID(i + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.ISBN != e.isbn || b.ISBN13 != e.isbn13 {
+			t.Errorf("book %d: expected: %q, %q, got: %q, %q", i+1, e.isbn, e.isbn13, b.ISBN, b.ISBN13)
+		}
+	}
+	b, err := CheckoutISBN(s, "978-0-306-40615-7")
+	if err != nil || b.BookID != 2 {
+		t.Errorf("unexpected book: %+v (%v)", b, err)
+	}
+
+	_, err = s.MigrateDown(13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var isbn string
+	err = s.queryRow("SELECT isbn FROM Book WHERE book_id = 2").Scan(&isbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isbn != isbns[1] {
+		t.Errorf("expected: %q, got: %q", isbns[1], isbn)
+	}
+}
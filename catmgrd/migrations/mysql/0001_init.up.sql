@@ -1,3 +1,6 @@
+-- Initial schema. `IF NOT EXISTS` lets databases created before
+-- migrations were introduced adopt this version.
+
 CREATE TABLE IF NOT EXISTS UserType(
     type_id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     type_name VARCHAR(32) UNIQUE,
@@ -39,4 +42,4 @@ CREATE TABLE IF NOT EXISTS Record(
         REFERENCES User(user_id),
     FOREIGN KEY (book_id)
         REFERENCES Book(book_id)
-);
\ No newline at end of file
+);
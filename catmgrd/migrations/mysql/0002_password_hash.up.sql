@@ -0,0 +1,4 @@
+-- Password tokens are PHC strings of argon2id now (see password.go),
+-- which do not fit in CHAR(40). Legacy SHA-1 tokens are kept as is.
+
+ALTER TABLE User MODIFY token VARCHAR(255) NOT NULL;
@@ -0,0 +1,44 @@
+-- Permissions without an old flag are lost. A flag is set if
+-- book.update, user.create, record.borrow or record.inspect.any is
+-- granted respectively.
+
+ALTER TABLE UserType ADD COLUMN can_update BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE UserType ADD COLUMN can_adduser BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE UserType ADD COLUMN can_borrow BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE UserType ADD COLUMN can_inspect BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ApiKey ADD COLUMN can_update BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ApiKey ADD COLUMN can_adduser BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ApiKey ADD COLUMN can_borrow BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ApiKey ADD COLUMN can_inspect BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE UserType SET
+    can_update = EXISTS (
+        SELECT 1 FROM RolePermission r
+        WHERE r.type_id = UserType.type_id AND r.perm_name = 'book.update'),
+    can_adduser = EXISTS (
+        SELECT 1 FROM RolePermission r
+        WHERE r.type_id = UserType.type_id AND r.perm_name = 'user.create'),
+    can_borrow = EXISTS (
+        SELECT 1 FROM RolePermission r
+        WHERE r.type_id = UserType.type_id AND r.perm_name = 'record.borrow'),
+    can_inspect = EXISTS (
+        SELECT 1 FROM RolePermission r
+        WHERE r.type_id = UserType.type_id AND r.perm_name = 'record.inspect.any');
+
+UPDATE ApiKey SET
+    can_update = EXISTS (
+        SELECT 1 FROM ApiKeyPermission r
+        WHERE r.key_id = ApiKey.key_id AND r.perm_name = 'book.update'),
+    can_adduser = EXISTS (
+        SELECT 1 FROM ApiKeyPermission r
+        WHERE r.key_id = ApiKey.key_id AND r.perm_name = 'user.create'),
+    can_borrow = EXISTS (
+        SELECT 1 FROM ApiKeyPermission r
+        WHERE r.key_id = ApiKey.key_id AND r.perm_name = 'record.borrow'),
+    can_inspect = EXISTS (
+        SELECT 1 FROM ApiKeyPermission r
+        WHERE r.key_id = ApiKey.key_id AND r.perm_name = 'record.inspect.any');
+
+DROP TABLE ApiKeyPermission;
+DROP TABLE RolePermission;
+DROP TABLE Permission;
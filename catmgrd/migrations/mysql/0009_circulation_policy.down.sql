@@ -0,0 +1,6 @@
+DELETE FROM ApiKeyPermission WHERE perm_name = 'policy.manage';
+DELETE FROM RolePermission WHERE perm_name = 'policy.manage';
+DELETE FROM Permission WHERE perm_name = 'policy.manage';
+
+DROP TABLE BookPolicy;
+DROP TABLE CirculationPolicy;
@@ -0,0 +1,3 @@
+ALTER TABLE CirculationPolicy DROP COLUMN pickup_days;
+
+DROP TABLE Hold;
@@ -0,0 +1,16 @@
+-- Only available items are counted, as reserved copies were not.
+-- Lost and withdrawn items are forgotten.
+
+ALTER TABLE Book ADD COLUMN available_count INT NOT NULL DEFAULT 0 CHECK(available_count >= 0);
+
+UPDATE Book
+SET available_count = (
+    SELECT COUNT(*) FROM Item
+    WHERE Item.book_id = Book.book_id AND status = 'available');
+
+ALTER TABLE Hold DROP FOREIGN KEY hold_item;
+ALTER TABLE Hold DROP COLUMN item_id;
+ALTER TABLE Record DROP FOREIGN KEY record_item;
+ALTER TABLE Record DROP COLUMN item_id;
+
+DROP TABLE Item;
@@ -0,0 +1,84 @@
+-- Physical copies (items) of books, each with a unique barcode.
+-- Availability is derived from item status instead of
+-- Book.available_count:
+--   available  on the shelf
+--   loaned     borrowed, by the unreturned record with its `item_id`
+--   reserved   kept for the ready hold with its `item_id`
+--   lost, withdrawn
+--
+-- Existing copies are converted as follows, with generated barcodes:
+--   available_count      -> available items "<book_id>-<n>"
+--   unreturned records   -> loaned items "<book_id>-R<record_id>"
+--   ready holds          -> reserved items "<book_id>-H<hold_id>"
+
+CREATE TABLE Item(
+    item_id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    book_id INT NOT NULL,
+    barcode VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    acquired_at DATE NOT NULL,
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
+
+CREATE INDEX item_book_status ON Item(book_id, status);
+
+ALTER TABLE Record
+    ADD COLUMN item_id INT,
+    ADD CONSTRAINT record_item FOREIGN KEY (item_id) REFERENCES Item(item_id);
+ALTER TABLE Hold
+    ADD COLUMN item_id INT,
+    ADD CONSTRAINT hold_item FOREIGN KEY (item_id) REFERENCES Item(item_id);
+
+-- Numbers from 1 to the largest count (up to 999999), for generating
+-- one row per copy.
+CREATE TABLE ItemSeq(i INT NOT NULL PRIMARY KEY);
+
+INSERT INTO ItemSeq (i)
+SELECT n FROM (
+    SELECT 1 + a.d + 10 * b.d + 100 * c.d + 1000 * d.d + 10000 * e.d + 100000 * f.d AS n
+    FROM
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a,
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b,
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c,
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) d,
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) e,
+        (SELECT 0 AS d UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4
+         UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) f
+) t
+WHERE n <= (SELECT MAX(available_count) FROM Book);
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT b.book_id, CONCAT(b.book_id, '-', s.i), 'available', CURRENT_DATE
+FROM Book b JOIN ItemSeq s ON s.i <= b.available_count;
+
+DROP TABLE ItemSeq;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, CONCAT(book_id, '-R', record_id), 'loaned', borrow_date
+FROM Record
+WHERE return_date IS NULL;
+
+UPDATE Record
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = CONCAT(Record.book_id, '-R', Record.record_id))
+WHERE return_date IS NULL;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, CONCAT(book_id, '-H', hold_id), 'reserved', CURRENT_DATE
+FROM Hold
+WHERE status = 'ready';
+
+UPDATE Hold
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = CONCAT(Hold.book_id, '-H', Hold.hold_id))
+WHERE status = 'ready';
+
+ALTER TABLE Book DROP COLUMN available_count;
@@ -0,0 +1,18 @@
+-- Items in transit are put back on the shelf.
+
+DELETE FROM ApiKeyPermission WHERE perm_name = 'branch.manage';
+DELETE FROM RolePermission WHERE perm_name = 'branch.manage';
+DELETE FROM Permission WHERE perm_name = 'branch.manage';
+
+UPDATE Item SET status = 'available' WHERE status = 'in_transit';
+
+ALTER TABLE Record
+    DROP FOREIGN KEY record_return_branch,
+    DROP FOREIGN KEY record_borrow_branch;
+ALTER TABLE Record
+    DROP COLUMN return_branch_id,
+    DROP COLUMN borrow_branch_id;
+ALTER TABLE Item DROP FOREIGN KEY item_branch;
+ALTER TABLE Item DROP COLUMN branch_id;
+
+DROP TABLE Branch;
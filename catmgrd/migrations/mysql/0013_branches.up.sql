@@ -0,0 +1,30 @@
+-- Library branches. Every item belongs to a home branch, and existing
+-- items go to branch 1 "main". Records remember the branches where
+-- books were borrowed and returned, NULL for older records. Items
+-- returned at another branch are 'in_transit' until received back at
+-- their home branches.
+
+CREATE TABLE Branch(
+    branch_id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE
+);
+
+INSERT INTO Branch (name) VALUES ('main');
+
+ALTER TABLE Item
+    ADD COLUMN branch_id INT NOT NULL DEFAULT 1,
+    ADD CONSTRAINT item_branch FOREIGN KEY (branch_id) REFERENCES Branch(branch_id);
+ALTER TABLE Record
+    ADD COLUMN borrow_branch_id INT,
+    ADD COLUMN return_branch_id INT,
+    ADD CONSTRAINT record_borrow_branch FOREIGN KEY (borrow_branch_id) REFERENCES Branch(branch_id),
+    ADD CONSTRAINT record_return_branch FOREIGN KEY (return_branch_id) REFERENCES Branch(branch_id);
+
+-- Types that add books also open branches.
+INSERT INTO Permission (perm_name, description) VALUES
+    ('branch.manage', 'add branches');
+
+INSERT INTO RolePermission (type_id, perm_name)
+SELECT type_id, 'branch.manage'
+FROM RolePermission
+WHERE perm_name = 'book.create';
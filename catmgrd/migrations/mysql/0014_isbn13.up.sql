@@ -0,0 +1,6 @@
+-- ISBNs are stored as ISBN-13s without hyphens in isbn, and shown as
+-- entered from isbn_display. catmgrd converts valid ISBNs after this
+-- script, and reports invalid ones, which are left as they are.
+
+ALTER TABLE Book ADD COLUMN isbn_display VARCHAR(128);
+UPDATE Book SET isbn_display = isbn;
@@ -0,0 +1,4 @@
+DROP TABLE Record;
+DROP TABLE Book;
+DROP TABLE "User";
+DROP TABLE UserType;
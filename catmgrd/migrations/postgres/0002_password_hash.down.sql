@@ -0,0 +1,4 @@
+-- Fails if any user already has a new password hash, since it does not
+-- fit in CHAR(40). Reset their passwords before migrating down.
+
+ALTER TABLE "User" ALTER COLUMN token TYPE CHAR(40);
@@ -0,0 +1,9 @@
+-- Counters of failed password attempts, per user ("user:<user_id>")
+-- and per remote address ("addr:<address>"). See lockout.go.
+
+CREATE TABLE AuthFailure(
+    subject VARCHAR(160) NOT NULL PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- Set when an administrator resets a password and requires the user
+-- to change it on next login.
+
+ALTER TABLE "User" ADD COLUMN must_change BOOLEAN NOT NULL DEFAULT false;
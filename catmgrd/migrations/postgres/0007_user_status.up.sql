@@ -0,0 +1,5 @@
+-- Deactivated or expired users cannot log in. NULL `expires_at`
+-- means the account never expires.
+
+ALTER TABLE "User" ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE "User" ADD COLUMN expires_at TIMESTAMP;
@@ -0,0 +1,37 @@
+-- Circulation policies of user types, and overrides of loan periods
+-- per book. Types without a policy use `DefaultPolicy` in policy.go,
+-- which has the same values as older versions: books are due in 30
+-- days and must be returned in 90 days, extensions of 30 days are
+-- allowed in the last 7 days, and users with more than 3 overdue books
+-- are suspended. NULL columns of BookPolicy are not overridden.
+
+CREATE TABLE CirculationPolicy(
+    type_id INT NOT NULL PRIMARY KEY,
+    loan_days INT NOT NULL,
+    final_days INT NOT NULL,
+    extend_window_days INT NOT NULL,
+    extend_days INT NOT NULL,
+    max_overdue INT NOT NULL,
+    max_loans INT NOT NULL,
+    FOREIGN KEY (type_id)
+        REFERENCES UserType(type_id)
+);
+
+CREATE TABLE BookPolicy(
+    book_id INT NOT NULL PRIMARY KEY,
+    loan_days INT,
+    final_days INT,
+    extend_window_days INT,
+    extend_days INT,
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
+
+-- Types that manage user types manage their policies as well.
+INSERT INTO Permission (perm_name, description) VALUES
+    ('policy.manage', 'modify circulation policies of user types and books');
+
+INSERT INTO RolePermission (type_id, perm_name)
+SELECT type_id, 'policy.manage'
+FROM RolePermission
+WHERE perm_name = 'usertype.manage';
@@ -0,0 +1,22 @@
+-- Holds on books. Holds on a book are served in order of `hold_id`.
+-- A hold is 'waiting' in the queue until a copy is returned, then
+-- 'ready' until `expires_at`, the end of the pickup window. Closed
+-- holds are 'fulfilled', 'cancelled' or 'expired'.
+
+CREATE TABLE Hold(
+    hold_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    book_id INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    FOREIGN KEY (user_id)
+        REFERENCES "User"(user_id),
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
+
+CREATE INDEX hold_book_status ON Hold(book_id, status);
+CREATE INDEX hold_user ON Hold(user_id);
+
+ALTER TABLE CirculationPolicy ADD COLUMN pickup_days INT NOT NULL DEFAULT 3;
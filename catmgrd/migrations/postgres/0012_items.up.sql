@@ -0,0 +1,55 @@
+-- Physical copies (items) of books, each with a unique barcode.
+-- Availability is derived from item status instead of
+-- Book.available_count:
+--   available  on the shelf
+--   loaned     borrowed, by the unreturned record with its `item_id`
+--   reserved   kept for the ready hold with its `item_id`
+--   lost, withdrawn
+--
+-- Existing copies are converted as follows, with generated barcodes:
+--   available_count      -> available items "<book_id>-<n>"
+--   unreturned records   -> loaned items "<book_id>-R<record_id>"
+--   ready holds          -> reserved items "<book_id>-H<hold_id>"
+
+CREATE TABLE Item(
+    item_id SERIAL PRIMARY KEY,
+    book_id INT NOT NULL,
+    barcode VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    acquired_at DATE NOT NULL,
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
+
+CREATE INDEX item_book_status ON Item(book_id, status);
+
+ALTER TABLE Record ADD COLUMN item_id INT REFERENCES Item(item_id);
+ALTER TABLE Hold ADD COLUMN item_id INT REFERENCES Item(item_id);
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT b.book_id, CONCAT(b.book_id, '-', n), 'available', CURRENT_DATE
+FROM Book b, generate_series(1, b.available_count) n;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, CONCAT(book_id, '-R', record_id), 'loaned', borrow_date
+FROM Record
+WHERE return_date IS NULL;
+
+UPDATE Record
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = CONCAT(Record.book_id, '-R', Record.record_id))
+WHERE return_date IS NULL;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, CONCAT(book_id, '-H', hold_id), 'reserved', CURRENT_DATE
+FROM Hold
+WHERE status = 'ready';
+
+UPDATE Hold
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = CONCAT(Hold.book_id, '-H', Hold.hold_id))
+WHERE status = 'ready';
+
+ALTER TABLE Book DROP COLUMN available_count;
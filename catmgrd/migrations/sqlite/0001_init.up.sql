@@ -0,0 +1,48 @@
+-- Initial schema, same as mysql/0001_init.up.sql.
+-- Foreign keys are enforced only if `PRAGMA foreign_keys` is on,
+-- which `catmgrd` does for every connection.
+
+CREATE TABLE IF NOT EXISTS UserType(
+    type_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type_name VARCHAR(32) UNIQUE,
+    can_update BOOLEAN NOT NULL DEFAULT false,
+    can_adduser BOOLEAN NOT NULL DEFAULT false,
+    can_borrow BOOLEAN NOT NULL DEFAULT false,
+    can_inspect BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS User(
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type_id INTEGER NOT NULL,
+    name VARCHAR(128) NOT NULL UNIQUE,
+    token CHAR(40) NOT NULL,
+    FOREIGN KEY (type_id)
+        REFERENCES UserType(type_id)
+);
+
+CREATE TABLE IF NOT EXISTS Book(
+    book_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title VARCHAR(256),
+    author VARCHAR(128),
+    isbn VARCHAR(128) UNIQUE,
+    available_count INTEGER NOT NULL CHECK(available_count >= 0),
+    description TEXT,
+    comment TEXT
+);
+
+-- SQLite allows AUTOINCREMENT only on a single-column INTEGER PRIMARY KEY,
+-- so (record_id, user_id, book_id) is not the primary key here.
+-- `record_id` alone is unique anyway.
+CREATE TABLE IF NOT EXISTS Record(
+    record_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL,
+    return_date DATE,
+    borrow_date DATE NOT NULL,
+    deadline DATE NOT NULL CHECK(deadline >= borrow_date),
+    final_deadline DATE NOT NULL CHECK(final_deadline >= deadline),
+    FOREIGN KEY (user_id)
+        REFERENCES User(user_id),
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
@@ -0,0 +1 @@
+-- Nothing to revert, see 0002_password_hash.up.sql.
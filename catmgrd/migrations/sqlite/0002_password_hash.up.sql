@@ -0,0 +1,3 @@
+-- Password tokens are PHC strings of argon2id now (see password.go).
+-- SQLite does not enforce the length of CHAR(40), so there is nothing
+-- to change. This migration keeps versions in line with other dialects.
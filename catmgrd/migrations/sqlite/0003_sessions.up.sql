@@ -0,0 +1,14 @@
+-- Login sessions. Only SHA-256 hashes of session tokens are stored.
+
+CREATE TABLE Session(
+    session_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    FOREIGN KEY (user_id)
+        REFERENCES User(user_id)
+);
+
+CREATE INDEX session_expires_at ON Session(expires_at);
@@ -0,0 +1,19 @@
+-- API keys for service accounts. Only SHA-256 hashes of keys are
+-- stored. Scope columns are the permissions granted to the key, which
+-- are further limited by the permissions of its user.
+
+CREATE TABLE ApiKey(
+    key_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    can_update BOOLEAN NOT NULL DEFAULT false,
+    can_adduser BOOLEAN NOT NULL DEFAULT false,
+    can_borrow BOOLEAN NOT NULL DEFAULT false,
+    can_inspect BOOLEAN NOT NULL DEFAULT false,
+    created_at DATETIME NOT NULL,
+    last_used DATETIME,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    FOREIGN KEY (user_id)
+        REFERENCES User(user_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE User DROP COLUMN must_change;
@@ -0,0 +1,2 @@
+ALTER TABLE User DROP COLUMN expires_at;
+ALTER TABLE User DROP COLUMN active;
@@ -0,0 +1,73 @@
+-- Named permissions replace the can_* columns of UserType and ApiKey.
+-- User types act as roles, whose permissions are in RolePermission.
+-- Adding a permission only needs a new row in Permission.
+--
+-- The old flags are converted as follows:
+--   can_update  -> book.create, book.update, book.delete, record.return.any
+--   can_adduser -> user.create, user.manage, usertype.manage, apikey.manage
+--   can_borrow  -> record.borrow
+--   can_inspect -> record.inspect.any
+
+CREATE TABLE Permission(
+    perm_name VARCHAR(64) NOT NULL PRIMARY KEY,
+    description VARCHAR(256) NOT NULL
+);
+
+INSERT INTO Permission (perm_name, description) VALUES
+    ('book.create', 'add new books'),
+    ('book.update', 'modify book information and counts'),
+    ('book.delete', 'remove books'),
+    ('record.borrow', 'borrow books'),
+    ('record.inspect.any', 'list borrow records of any user'),
+    ('record.return.any', 'return books borrowed by any user'),
+    ('user.create', 'add new users'),
+    ('user.manage', 'reset passwords, unlock, deactivate and delete users'),
+    ('usertype.manage', 'create, modify and delete user types'),
+    ('apikey.manage', 'create, list and revoke API keys');
+
+CREATE TABLE RolePermission(
+    type_id INTEGER NOT NULL,
+    perm_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (type_id, perm_name),
+    FOREIGN KEY (type_id)
+        REFERENCES UserType(type_id),
+    FOREIGN KEY (perm_name)
+        REFERENCES Permission(perm_name)
+);
+
+CREATE TABLE ApiKeyPermission(
+    key_id INTEGER NOT NULL,
+    perm_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (key_id, perm_name),
+    FOREIGN KEY (key_id)
+        REFERENCES ApiKey(key_id),
+    FOREIGN KEY (perm_name)
+        REFERENCES Permission(perm_name)
+);
+
+INSERT INTO RolePermission (type_id, perm_name)
+SELECT t.type_id, p.perm_name
+FROM UserType t, Permission p
+WHERE
+    (t.can_update AND p.perm_name IN ('book.create', 'book.update', 'book.delete', 'record.return.any')) OR
+    (t.can_adduser AND p.perm_name IN ('user.create', 'user.manage', 'usertype.manage', 'apikey.manage')) OR
+    (t.can_borrow AND p.perm_name = 'record.borrow') OR
+    (t.can_inspect AND p.perm_name = 'record.inspect.any');
+
+INSERT INTO ApiKeyPermission (key_id, perm_name)
+SELECT k.key_id, p.perm_name
+FROM ApiKey k, Permission p
+WHERE
+    (k.can_update AND p.perm_name IN ('book.create', 'book.update', 'book.delete', 'record.return.any')) OR
+    (k.can_adduser AND p.perm_name IN ('user.create', 'user.manage', 'usertype.manage', 'apikey.manage')) OR
+    (k.can_borrow AND p.perm_name = 'record.borrow') OR
+    (k.can_inspect AND p.perm_name = 'record.inspect.any');
+
+ALTER TABLE UserType DROP COLUMN can_update;
+ALTER TABLE UserType DROP COLUMN can_adduser;
+ALTER TABLE UserType DROP COLUMN can_borrow;
+ALTER TABLE UserType DROP COLUMN can_inspect;
+ALTER TABLE ApiKey DROP COLUMN can_update;
+ALTER TABLE ApiKey DROP COLUMN can_adduser;
+ALTER TABLE ApiKey DROP COLUMN can_borrow;
+ALTER TABLE ApiKey DROP COLUMN can_inspect;
@@ -0,0 +1,9 @@
+DELETE FROM ApiKeyPermission WHERE perm_name = 'fine.manage';
+DELETE FROM RolePermission WHERE perm_name = 'fine.manage';
+DELETE FROM Permission WHERE perm_name = 'fine.manage';
+
+ALTER TABLE CirculationPolicy DROP COLUMN max_balance;
+ALTER TABLE CirculationPolicy DROP COLUMN fine_cap;
+ALTER TABLE CirculationPolicy DROP COLUMN fine_rate;
+
+DROP TABLE Fine;
@@ -0,0 +1,39 @@
+-- Ledger of overdue fines. Amounts are in the smallest currency unit
+-- (e.g. cents). A 'charge' is posted when an overdue book is returned,
+-- and 'payment' and 'waiver' entries are recorded by administrators;
+-- the balance of a user is charges minus the others. `admin_id` is the
+-- administrator who recorded the entry, NULL for charges. It is not a
+-- foreign key, so that administrators can still be removed.
+
+CREATE TABLE Fine(
+    fine_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    record_id INTEGER,
+    kind VARCHAR(16) NOT NULL,
+    amount INTEGER NOT NULL,
+    created_at DATETIME NOT NULL,
+    admin_id INTEGER,
+    note VARCHAR(256) NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id)
+        REFERENCES User(user_id),
+    FOREIGN KEY (record_id)
+        REFERENCES Record(record_id)
+);
+
+CREATE INDEX fine_user ON Fine(user_id);
+
+-- Daily rate, cap per loan and the balance above which users cannot
+-- borrow. Zero disables each of them, so no fines are charged by
+-- default.
+ALTER TABLE CirculationPolicy ADD COLUMN fine_rate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE CirculationPolicy ADD COLUMN fine_cap INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE CirculationPolicy ADD COLUMN max_balance INTEGER NOT NULL DEFAULT 0;
+
+-- Types that return books of others work at the desk, and take payments.
+INSERT INTO Permission (perm_name, description) VALUES
+    ('fine.manage', 'view fines of any user, record payments and waivers');
+
+INSERT INTO RolePermission (type_id, perm_name)
+SELECT type_id, 'fine.manage'
+FROM RolePermission
+WHERE perm_name = 'record.return.any';
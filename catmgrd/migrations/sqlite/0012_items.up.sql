@@ -0,0 +1,63 @@
+-- Physical copies (items) of books, each with a unique barcode.
+-- Availability is derived from item status instead of
+-- Book.available_count:
+--   available  on the shelf
+--   loaned     borrowed, by the unreturned record with its `item_id`
+--   reserved   kept for the ready hold with its `item_id`
+--   lost, withdrawn
+--
+-- Existing copies are converted as follows, with generated barcodes:
+--   available_count      -> available items "<book_id>-<n>"
+--   unreturned records   -> loaned items "<book_id>-R<record_id>"
+--   ready holds          -> reserved items "<book_id>-H<hold_id>"
+--
+-- SQLite cannot drop columns used in foreign keys, so `item_id` of
+-- Record and Hold are not declared as such here.
+
+CREATE TABLE Item(
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    barcode VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    acquired_at DATE NOT NULL,
+    FOREIGN KEY (book_id)
+        REFERENCES Book(book_id)
+);
+
+CREATE INDEX item_book_status ON Item(book_id, status);
+
+ALTER TABLE Record ADD COLUMN item_id INTEGER;
+ALTER TABLE Hold ADD COLUMN item_id INTEGER;
+
+WITH RECURSIVE Seq(i) AS (
+    SELECT 1
+    UNION ALL
+    SELECT i + 1 FROM Seq WHERE i < (SELECT MAX(available_count) FROM Book)
+)
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT b.book_id, b.book_id || '-' || s.i, 'available', CURRENT_DATE
+FROM Book b JOIN Seq s ON s.i <= b.available_count;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, book_id || '-R' || record_id, 'loaned', borrow_date
+FROM Record
+WHERE return_date IS NULL;
+
+UPDATE Record
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = Record.book_id || '-R' || Record.record_id)
+WHERE return_date IS NULL;
+
+INSERT INTO Item (book_id, barcode, status, acquired_at)
+SELECT book_id, book_id || '-H' || hold_id, 'reserved', CURRENT_DATE
+FROM Hold
+WHERE status = 'ready';
+
+UPDATE Hold
+SET item_id = (
+    SELECT item_id FROM Item
+    WHERE barcode = Hold.book_id || '-H' || Hold.hold_id)
+WHERE status = 'ready';
+
+ALTER TABLE Book DROP COLUMN available_count;
@@ -0,0 +1,13 @@
+-- Items in transit are put back on the shelf.
+
+DELETE FROM ApiKeyPermission WHERE perm_name = 'branch.manage';
+DELETE FROM RolePermission WHERE perm_name = 'branch.manage';
+DELETE FROM Permission WHERE perm_name = 'branch.manage';
+
+UPDATE Item SET status = 'available' WHERE status = 'in_transit';
+
+ALTER TABLE Record DROP COLUMN return_branch_id;
+ALTER TABLE Record DROP COLUMN borrow_branch_id;
+ALTER TABLE Item DROP COLUMN branch_id;
+
+DROP TABLE Branch;
@@ -0,0 +1,28 @@
+-- Library branches. Every item belongs to a home branch, and existing
+-- items go to branch 1 "main". Records remember the branches where
+-- books were borrowed and returned, NULL for older records. Items
+-- returned at another branch are 'in_transit' until received back at
+-- their home branches.
+--
+-- SQLite cannot drop columns used in foreign keys, so the new columns
+-- are not declared as such here.
+
+CREATE TABLE Branch(
+    branch_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(64) NOT NULL UNIQUE
+);
+
+INSERT INTO Branch (name) VALUES ('main');
+
+ALTER TABLE Item ADD COLUMN branch_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE Record ADD COLUMN borrow_branch_id INTEGER;
+ALTER TABLE Record ADD COLUMN return_branch_id INTEGER;
+
+-- Types that add books also open branches.
+INSERT INTO Permission (perm_name, description) VALUES
+    ('branch.manage', 'add branches');
+
+INSERT INTO RolePermission (type_id, perm_name)
+SELECT type_id, 'branch.manage'
+FROM RolePermission
+WHERE perm_name = 'book.create';
@@ -0,0 +1,4 @@
+-- ISBNs are put back as entered.
+
+UPDATE Book SET isbn = isbn_display WHERE isbn_display IS NOT NULL;
+ALTER TABLE Book DROP COLUMN isbn_display;
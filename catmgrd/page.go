@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lists of books and records are returned in pages. Rows are ordered by
+// a sort key, and then by ID so that the order is stable even if keys
+// are equal. Every page but the last comes with a cursor, which is
+// passed back for the next page. Cursors remember the key and ID of
+// the last row of the page, rather than an offset, so pages neither
+// skip nor repeat rows when others are added or removed meanwhile.
+
+// `DefaultPageSize` is also the former default limit of "/list".
+const DefaultPageSize = 100
+const MaxPageSize = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidSortKey = errors.New("invalid sort key")
+var ErrInvalidPageSize = errors.New("invalid page size")
+
+// Sort keys of books and records. The first ones are the IDs.
+var BookSortKeys = []string{"book_id", "title", "author", "isbn"}
+var RecordSortKeys = []string{"record_id", "borrow_date", "deadline"}
+
+// `dateSortKeys` have dates as cursor values.
+var dateSortKeys = []string{"borrow_date", "deadline"}
+
+// `Page` selects a page of a list in `Store`. `Size` 0 means all rows.
+// Rows after `After` are returned if it is not nil.
+type Page struct {
+	Sort  string // "" for the default order of the list
+	Desc  bool
+	Size  int
+	After *Cursor
+}
+
+// `Cursor` is the end of a page: the sort key of its last row, and the
+// ID of that row. Keys of books are as shown, e.g. "(no title)" for
+// books without titles, and dates are in RFC 3339.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"i"`
+}
+
+// `Encode` returns the opaque form of `c` sent to clients.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// `DecodeCursor` parses a cursor from `Encode`.
+//
+// May return `ErrInvalidCursor`.
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	_, err = c.Key()
+	if err != nil {
+		return Cursor{}, err
+	}
+	return c, nil
+}
+
+// `Key` returns the sort key of `c`: nil for IDs, `time.Time` for
+// dates, and strings otherwise.
+//
+// May return `ErrInvalidCursor`.
+func (c Cursor) Key() (interface{}, error) {
+	switch {
+	case c.Sort == BookSortKeys[0] || c.Sort == RecordSortKeys[0]:
+		return nil, nil
+	case contains(dateSortKeys, c.Sort):
+		t, err := time.Parse(time.RFC3339, c.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return t, nil
+	default:
+		return c.Value, nil
+	}
+}
+
+// `PageRequest` is a page asked by clients. `Sort` is a sort key,
+// prefixed with "-" for descending order, and `Cursor` is from the
+// previous page. A zero `Size` means `DefaultPageSize`.
+type PageRequest struct {
+	Sort   string
+	Cursor string
+	Size   int
+}
+
+// `PageInfo` comes with a page. `NextCursor` is empty on the last
+// page, and `Total` is the number of rows in all pages.
+type PageInfo struct {
+	NextCursor string
+	Total      int
+}
+
+// `newPage` checks `req` against sort keys `keys`. `def` is the sort
+// used if neither `req.Sort` nor the cursor has one.
+//
+// May return `ErrInvalidSortKey`, `ErrInvalidPageSize` or
+// `ErrInvalidCursor`.
+func newPage(req PageRequest, keys []string, def string) (Page, error) {
+	p := Page{Size: req.Size}
+	if p.Size == 0 {
+		p.Size = DefaultPageSize
+	}
+	if p.Size < 0 || p.Size > MaxPageSize {
+		return Page{}, ErrInvalidPageSize
+	}
+
+	var c Cursor
+	if req.Cursor != "" {
+		var err error
+		c, err = DecodeCursor(req.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		p.After = &c
+	}
+
+	sort := req.Sort
+	if sort == "" && p.After != nil {
+		sort = c.Sort
+		if c.Desc {
+			sort = "-" + sort
+		}
+	}
+	if sort == "" {
+		sort = def
+	}
+	if strings.HasPrefix(sort, "-") {
+		p.Desc = true
+		sort = sort[1:]
+	}
+	if !contains(keys, sort) {
+		return Page{}, ErrInvalidSortKey
+	}
+	p.Sort = sort
+
+	// Cursors of another order point nowhere
+	if p.After != nil && (c.Sort != p.Sort || c.Desc != p.Desc) {
+		return Page{}, ErrInvalidCursor
+	}
+	return p, nil
+}
+
+// `nextCursor` returns the cursor after a row with `id` and sort key
+// `key` (see `Cursor.Key`).
+func (p Page) nextCursor(id int, key interface{}) string {
+	c := Cursor{Sort: p.Sort, Desc: p.Desc, ID: id}
+	switch v := key.(type) {
+	case time.Time:
+		c.Value = v.UTC().Format(time.RFC3339)
+	case string:
+		c.Value = v
+	}
+	return c.Encode()
+}
+
+// `bookSortKey` returns the sort key `key` of `b`.
+func bookSortKey(b Book, key string) interface{} {
+	switch key {
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "isbn":
+		return b.ISBN
+	default:
+		return nil
+	}
+}
+
+// `recordSortKey` returns the sort key `key` of `r`.
+func recordSortKey(r Record, key string) interface{} {
+	switch key {
+	case "borrow_date":
+		return r.BorrowDate
+	case "deadline":
+		return r.DueDate
+	default:
+		return nil
+	}
+}
+
+// `PageBooks` returns a page of books matching `q` (see query.go), or
+// all books if `q` is nil, with available counts per branch. Books are
+// ordered by book ID by default.
+//
+// May return `ErrInvalidSortKey`, `ErrInvalidPageSize` or
+// `ErrInvalidCursor`.
+func PageBooks(db Store, q *BookQuery, req PageRequest) ([]Book, PageInfo, error) {
+	p, err := newPage(req, BookSortKeys, "book_id")
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	var info PageInfo
+	info.Total, err = db.CountBooks(q)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	// One more book tells whether there is a next page
+	size := p.Size
+	p.Size++
+	books, err := db.QueryBooks(q, p)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	if len(books) > size {
+		books = books[:size]
+		last := books[size-1]
+		info.NextCursor = p.nextCursor(last.BookID, bookSortKey(last, p.Sort))
+	}
+
+	err = fillBranches(db, books)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	return books, info, nil
+}
+
+// `CheckoutHistoryPage` is `CheckoutHistory` in pages. Records are
+// ordered by borrow date, latest first, by default.
+//
+// May return `ErrInvalidSortKey`, `ErrInvalidPageSize` or
+// `ErrInvalidCursor`.
+func CheckoutHistoryPage(db Store, user_id int, filter RecordFilter, req PageRequest) ([]Record, PageInfo, error) {
+	p, err := newPage(req, RecordSortKeys, "-borrow_date")
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	var info PageInfo
+	info.Total, err = db.CountRecords(user_id, filter)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	size := p.Size
+	p.Size++
+	list, err := db.ListRecords(user_id, filter, p)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	if len(list) > size {
+		list = list[:size]
+		last := list[size-1]
+		info.NextCursor = p.nextCursor(last.RecordID, recordSortKey(last, p.Sort))
+	}
+
+	return list, info, nil
+}
+
+// `pageOffset` returns the offset in a cursor of full-text results,
+// which are ordered by relevance only and paged by position.
+func pageOffset(req PageRequest) (int, int, error) {
+	size := req.Size
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size < 0 || size > MaxPageSize {
+		return 0, 0, ErrInvalidPageSize
+	}
+	if req.Sort != "" && req.Sort != "relevance" {
+		return 0, 0, ErrInvalidSortKey
+	}
+	if req.Cursor == "" {
+		return 0, size, nil
+	}
+
+	c, err := DecodeCursor(req.Cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, err := strconv.Atoi(c.Value)
+	if c.Sort != "relevance" || err != nil || offset < 0 {
+		return 0, 0, ErrInvalidCursor
+	}
+	return offset, size, nil
+}
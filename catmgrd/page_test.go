@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPageBooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		word := randString(10)
+		q := &BookQuery{Field: "title", Cmp: ":", Text: word}
+		titles := map[int]string{}
+		addBook := func(suffix string) int {
+			t.Helper()
+			book_id, err := NewBook(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			title := word + " " + suffix
+			err = UpdateBook(db, book_id, 0, BookInfo{Title: &title})
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles[book_id] = title
+			return book_id
+		}
+		var ids []int
+		for _, suffix := range []string{"c", "a", "b", "a", "c", "b", "a"} {
+			ids = append(ids, addBook(suffix))
+		}
+
+		// Pages are fetched until there is no cursor
+		fetch := func(sort string, size int, inserted func()) []int {
+			t.Helper()
+			var list []int
+			req := PageRequest{Sort: sort, Size: size}
+			for {
+				books, info, err := PageBooks(db, q, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(books) > size {
+					t.Errorf("page too large: %d", len(books))
+				}
+				for _, b := range books {
+					list = append(list, b.BookID)
+				}
+				if info.NextCursor == "" {
+					return list
+				}
+				if inserted != nil {
+					inserted()
+					inserted = nil
+				}
+				req = PageRequest{Cursor: info.NextCursor, Size: size}
+			}
+		}
+
+		by_title := append([]int(nil), ids...)
+		sort.Slice(by_title, func(i, j int) bool {
+			a, b := by_title[i], by_title[j]
+			if titles[a] != titles[b] {
+				return titles[a] < titles[b]
+			}
+			return a < b
+		})
+		reversed := make([]int, len(by_title))
+		for i, book_id := range by_title {
+			reversed[len(by_title)-1-i] = book_id
+		}
+
+		tb := []struct {
+			sort     string
+			size     int
+			expected []int
+		}{
+			{"", 3, ids},
+			{"book_id", 7, ids},
+			{"title", 3, by_title},
+			{"title", 1, by_title},
+			{"-title", 2, reversed},
+		}
+		for _, e := range tb {
+			list := fetch(e.sort, e.size, nil)
+			if !reflect.DeepEqual(list, e.expected) {
+				t.Errorf("%q: expected: %v, got: %v", e.sort, e.expected, list)
+			}
+		}
+
+		// Books added before the cursor do not shift later pages
+		list := fetch("title", 3, func() { addBook("0") })
+		if !reflect.DeepEqual(list, by_title) {
+			t.Errorf("expected: %v, got: %v", by_title, list)
+		}
+
+		_, info, err := PageBooks(db, q, PageRequest{Size: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Total != len(ids)+1 {
+			t.Errorf("expected %d in total, got: %d", len(ids)+1, info.Total)
+		}
+
+		cursor := info.NextCursor
+		errs := []struct {
+			req PageRequest
+			err error
+		}{
+			{PageRequest{Sort: "shelf"}, ErrInvalidSortKey},
+			{PageRequest{Size: -1}, ErrInvalidPageSize},
+			{PageRequest{Size: MaxPageSize + 1}, ErrInvalidPageSize},
+			{PageRequest{Cursor: "garbage"}, ErrInvalidCursor},
+			{PageRequest{Sort: "title", Cursor: cursor}, ErrInvalidCursor},
+			{PageRequest{Sort: "book_id", Cursor: cursor}, nil},
+		}
+		for _, e := range errs {
+			_, _, err := PageBooks(db, q, e.req)
+			if err != e.err {
+				t.Errorf("%+v: expected: %+v, got: %+v", e.req, e.err, err)
+			}
+		}
+	})
+}
+
+func TestCheckoutHistoryPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		for _, key := range []string{"", "-borrow_date", "deadline", "-deadline", "record_id"} {
+			all, info, err := CheckoutHistoryPage(db, 9, RecordFilter{}, PageRequest{Sort: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Total != 4 || info.NextCursor != "" || len(all) != 4 {
+				t.Errorf("%q: unexpected page: %+v, %+v", key, all, info)
+			}
+
+			var list []Record
+			req := PageRequest{Sort: key, Size: 1}
+			for {
+				page, info, err := CheckoutHistoryPage(db, 9, RecordFilter{}, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				list = append(list, page...)
+				if info.NextCursor == "" {
+					break
+				}
+				req.Cursor = info.NextCursor
+			}
+			if !reflect.DeepEqual(list, all) {
+				t.Errorf("%q: expected: %+v, got: %+v", key, all, list)
+			}
+		}
+
+		list, _, err := CheckoutHistoryPage(db, 9, RecordFilter{}, PageRequest{Sort: "deadline"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(list); i++ {
+			if list[i].DueDate.Before(list[i-1].DueDate) {
+				t.Errorf("not ordered by deadline: %+v", list)
+			}
+		}
+
+		_, _, err = CheckoutHistoryPage(db, 9, RecordFilter{}, PageRequest{Sort: "title"})
+		if err != ErrInvalidSortKey {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSortKey, err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Passwords are hashed with argon2id and stored as PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// where salt and hash are encoded in base64 without padding. Tokens
+// created by older versions of catmgrd are unsalted SHA-1 hex digests
+// (40 characters). They are still accepted, and rehashed with argon2id
+// on the next successful login.
+const (
+	argon2Memory  = 19 * 1024 // KiB
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// `LegacyPasswordCutoff` is the time after which legacy SHA-1 tokens
+// are rejected. Zero value means they are always accepted.
+var LegacyPasswordCutoff time.Time
+
+var ErrLegacyPassword = errors.New("password expired: please ask an administrator to reset your password")
+var errInvalidToken = errors.New("invalid password token")
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// `HashPassword` returns the PHC string of `password` with a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(hash)), nil
+}
+
+// `isLegacyToken` reports whether `token` is an unsalted SHA-1 hex digest.
+func isLegacyToken(token string) bool {
+	if len(token) != 40 {
+		return false
+	}
+	for _, c := range token {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseArgon2Token(token string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	var version int
+	parts := strings.Split(token, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidToken
+	}
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidToken
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil {
+		return p, nil, nil, errInvalidToken
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidToken
+	}
+	hash, err := b64.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return p, nil, nil, errInvalidToken
+	}
+
+	return p, salt, hash, nil
+}
+
+// `CheckPassword` reports whether `password` matches `token`, which
+// is either a PHC string or a legacy SHA-1 token.
+func CheckPassword(token string, password string) (bool, error) {
+	if isLegacyToken(token) {
+		hash := fmt.Sprintf("%x", sha1.Sum([]byte(password)))
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(token)) == 1, nil
+	}
+
+	p, salt, hash, err := parseArgon2Token(token)
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(got, hash) == 1, nil
+}
+
+// `NeedsRehash` reports whether `token` should be replaced with a new
+// hash, i.e. it is a legacy token or uses outdated parameters.
+func NeedsRehash(token string) bool {
+	if isLegacyToken(token) {
+		return true
+	}
+
+	p, salt, hash, err := parseArgon2Token(token)
+	if err != nil {
+		return true
+	}
+
+	return p.memory != argon2Memory || p.time != argon2Time ||
+		p.threads != argon2Threads || len(salt) != argon2SaltLen ||
+		len(hash) != argon2KeyLen
+}
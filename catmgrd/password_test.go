@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	token, err := HashPassword("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "$argon2id$v=19$") {
+		t.Errorf("unexpected token: %s", token)
+	}
+
+	other, err := HashPassword("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == other {
+		t.Errorf("tokens are not salted: %s", token)
+	}
+
+	tb := []struct {
+		token    string
+		password string
+		ok       bool
+		rehash   bool
+	}{
+		{token, "123456", true, false},
+		{token, "1234567", false, false},
+		{token, "", false, false},
+		{"7c4a8d09ca3762af61e59520943dc26494f8941b", "123456", true, true},
+		{"7c4a8d09ca3762af61e59520943dc26494f8941b", "654321", false, true},
+		{"$argon2id$v=19$m=4096,t=1,p=1$c2FsdHNhbHQ$Sx2ETWTrTlPbyCxdCVvnzA", "123456", false, true},
+	}
+
+	for _, e := range tb {
+		ok, err := CheckPassword(e.token, e.password)
+		if err != nil {
+			t.Errorf("%s: %+v", e.token, err)
+		} else if ok != e.ok {
+			t.Errorf("%s, %s: expected: %v, got: %v", e.token, e.password, e.ok, ok)
+		}
+		if rehash := NeedsRehash(e.token); rehash != e.rehash {
+			t.Errorf("%s: expected rehash: %v, got: %v", e.token, e.rehash, rehash)
+		}
+	}
+
+	for _, token := range []string{"", "root", "$argon2id$v=19$m=1,t=1$salt$hash", "$bcrypt$"} {
+		_, err := CheckPassword(token, "root")
+		if err == nil {
+			t.Errorf("%#v: expected error", token)
+		}
+	}
+}
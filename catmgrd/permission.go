@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// Permissions are named capabilities. User types act as roles: their
+// permissions are stored in table RolePermission, and those of API keys
+// in table ApiKeyPermission. Known names are listed in table Permission
+// and in `AllPermissions`, so a new capability needs no schema change,
+// only a new row and a new constant here.
+type Permission string
+
+const (
+	PermBookCreate       Permission = "book.create"
+	PermBookUpdate       Permission = "book.update"
+	PermBookDelete       Permission = "book.delete"
+	PermRecordBorrow     Permission = "record.borrow"
+	PermRecordInspectAny Permission = "record.inspect.any"
+	PermRecordReturnAny  Permission = "record.return.any"
+	PermUserCreate       Permission = "user.create"
+	PermUserManage       Permission = "user.manage"
+	PermUserTypeManage   Permission = "usertype.manage"
+	PermAPIKeyManage     Permission = "apikey.manage"
+	PermPolicyManage     Permission = "policy.manage"
+	PermFineManage       Permission = "fine.manage"
+	PermBranchManage     Permission = "branch.manage"
+)
+
+// `AllPermissions` must be kept in sync with table Permission.
+var AllPermissions = NewPermissionSet(
+	PermBookCreate, PermBookUpdate, PermBookDelete,
+	PermRecordBorrow, PermRecordInspectAny, PermRecordReturnAny,
+	PermUserCreate, PermUserManage, PermUserTypeManage,
+	PermAPIKeyManage, PermPolicyManage, PermFineManage,
+	PermBranchManage,
+)
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// `PermissionSet` is a sorted list of distinct permissions, which is
+// encoded as a JSON array of names. Use `NewPermissionSet` to build one.
+type PermissionSet []Permission
+
+// `NewPermissionSet` sorts `perms` and removes duplicates. The result
+// is never nil, so that it is encoded as [] rather than null.
+func NewPermissionSet(perms ...Permission) PermissionSet {
+	set := append(PermissionSet{}, perms...)
+	sort.Slice(set, func(i, j int) bool { return set[i] < set[j] })
+
+	n := 0
+	for i, p := range set {
+		if i == 0 || p != set[n-1] {
+			set[n] = p
+			n++
+		}
+	}
+	return set[:n]
+}
+
+// `Has` reports whether `p` is in `s`, which must be sorted.
+func (s PermissionSet) Has(p Permission) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i] >= p })
+	return i < len(s) && s[i] == p
+}
+
+// `Contains` reports whether every permission of `o` is in `s`.
+func (s PermissionSet) Contains(o PermissionSet) bool {
+	for _, p := range o {
+		if !s.Has(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// `Intersect` returns permissions in both `s` and `o`.
+func (s PermissionSet) Intersect(o PermissionSet) PermissionSet {
+	ret := PermissionSet{}
+	for _, p := range s {
+		if o.Has(p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// `Validate` returns `ErrUnknownPermission` if `s` has a name not in
+// `AllPermissions`.
+func (s PermissionSet) Validate() error {
+	if !AllPermissions.Contains(s) {
+		return ErrUnknownPermission
+	}
+	return nil
+}
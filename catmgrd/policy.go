@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Circulation policies decide how long users may keep books and when
+// they are suspended. Each user type has a `Policy`, stored in table
+// CirculationPolicy, and `DefaultPolicy` applies to types without one.
+// Loan periods may be overridden per book in table BookPolicy, e.g.
+// for reference books that can only be borrowed for a few days.
+var DefaultPolicy = Policy{
+	LoanDays:         30,
+	FinalDays:        90,
+	ExtendWindowDays: 7,
+	ExtendDays:       30,
+	MaxOverdue:       3,
+	MaxLoans:         0,
+	PickupDays:       3,
+}
+
+var ErrInvalidPolicy = errors.New("invalid circulation policy")
+
+func days(n int) time.Duration {
+	return time.Duration(n) * day
+}
+
+// `Validate` returns `ErrInvalidPolicy` if any period or limit of `p`
+// is negative, books are due immediately, reserved copies are not kept
+// at all, or `FinalDays` is less than `LoanDays`.
+func (p Policy) Validate() error {
+	if p.LoanDays < 1 || p.FinalDays < p.LoanDays || p.PickupDays < 1 ||
+		p.ExtendWindowDays < 0 || p.ExtendDays < 0 ||
+		p.MaxOverdue < 0 || p.MaxLoans < 0 ||
+		p.FineRate < 0 || p.FineCap < 0 || p.MaxBalance < 0 {
+		return ErrInvalidPolicy
+	}
+	return nil
+}
+
+// `Validate` returns `ErrInvalidPolicy` if any field of `o` is negative,
+// or `LoanDays` is zero.
+func (o PolicyOverride) Validate() error {
+	if (o.LoanDays != nil && *o.LoanDays < 1) ||
+		(o.FinalDays != nil && *o.FinalDays < 1) ||
+		(o.ExtendWindowDays != nil && *o.ExtendWindowDays < 0) ||
+		(o.ExtendDays != nil && *o.ExtendDays < 0) {
+		return ErrInvalidPolicy
+	}
+	return nil
+}
+
+// `Apply` returns `p` with fields overridden by `o`. `FinalDays` is
+// raised to `LoanDays` if needed, so that the result is always valid.
+func (p Policy) Apply(o PolicyOverride) Policy {
+	if o.LoanDays != nil {
+		p.LoanDays = *o.LoanDays
+	}
+	if o.FinalDays != nil {
+		p.FinalDays = *o.FinalDays
+	}
+	if o.ExtendWindowDays != nil {
+		p.ExtendWindowDays = *o.ExtendWindowDays
+	}
+	if o.ExtendDays != nil {
+		p.ExtendDays = *o.ExtendDays
+	}
+	if p.FinalDays < p.LoanDays {
+		p.FinalDays = p.LoanDays
+	}
+	return p
+}
+
+// `GetPolicy` returns the circulation policy of user type `type_id`.
+//
+// May return `ErrInvalidUserType`.
+func GetPolicy(db Store, type_id int) (Policy, error) {
+	_, err := db.UserTypeByID(type_id)
+	if err != nil {
+		return Policy{}, err
+	}
+	return db.TypePolicy(type_id)
+}
+
+// `SetPolicy` replaces the circulation policy of user type `type_id`.
+// Existing records keep their deadlines.
+//
+// May return `ErrInvalidUserType` or `ErrInvalidPolicy`.
+func SetPolicy(db Store, type_id int, p Policy) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	return db.Atomic(func(db Store) error {
+		_, err := db.UserTypeByID(type_id)
+		if err != nil {
+			return err
+		}
+		return db.SetTypePolicy(type_id, p)
+	})
+}
+
+// `GetBookPolicy` returns overrides of book `book_id`.
+//
+// May return `ErrInvalidBookID`.
+func GetBookPolicy(db Store, book_id int) (PolicyOverride, error) {
+	_, err := db.BookByID(book_id)
+	if err == ErrBookNotFound {
+		return PolicyOverride{}, ErrInvalidBookID
+	}
+	if err != nil {
+		return PolicyOverride{}, err
+	}
+	return db.BookPolicy(book_id)
+}
+
+// `SetBookPolicy` replaces overrides of book `book_id`. Empty `o`
+// removes them.
+//
+// May return `ErrInvalidBookID` or `ErrInvalidPolicy`.
+func SetBookPolicy(db Store, book_id int, o PolicyOverride) error {
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+
+	return db.Atomic(func(db Store) error {
+		_, err := db.BookByID(book_id)
+		if err == ErrBookNotFound {
+			return ErrInvalidBookID
+		}
+		if err != nil {
+			return err
+		}
+		return db.SetBookPolicy(book_id, o)
+	})
+}
+
+// `effectivePolicy` returns the policy that applies when `user_id`
+// borrows `book_id`.
+func effectivePolicy(db Store, user_id, book_id int) (Policy, error) {
+	u, err := db.UserByID(user_id)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := db.TypePolicy(u.TypeID)
+	if err != nil {
+		return Policy{}, err
+	}
+	o, err := db.BookPolicy(book_id)
+	if err != nil {
+		return Policy{}, err
+	}
+	return p.Apply(o), nil
+}
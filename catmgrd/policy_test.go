@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		type_id, err := NewUserType(db, UserType{TypeName: randString(8), Perm: PermissionSet{PermRecordBorrow}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user_id, err := AddUser(db, type_id, randString(8), "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		book_id, err := NewBook(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = UpdateBook(db, book_id, 3, BookInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := GetPolicy(db, type_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != DefaultPolicy {
+			t.Errorf("expected: %+v, got: %+v", DefaultPolicy, p)
+		}
+		_, err = GetPolicy(db, -1)
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+
+		err = SetPolicy(db, type_id, Policy{LoanDays: 10, FinalDays: 5})
+		if err != ErrInvalidPolicy {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPolicy, err)
+		}
+		err = SetPolicy(db, -1, DefaultPolicy)
+		if err != ErrInvalidUserType {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidUserType, err)
+		}
+
+		p = Policy{
+			LoanDays:         5,
+			FinalDays:        10,
+			ExtendWindowDays: 7,
+			ExtendDays:       0,
+			MaxOverdue:       3,
+			MaxLoans:         1,
+			PickupDays:       3,
+		}
+		err = SetPolicy(db, type_id, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := GetPolicy(db, type_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != p {
+			t.Errorf("expected: %+v, got: %+v", p, got)
+		}
+
+		record_id, err := BorrowBook(db, user_id, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		due := truncateDate(time.Now().Add(days(p.LoanDays)))
+		if !truncateDate(r.DueDate).Equal(due) {
+			t.Errorf("expected due date: %v, got: %v", due, r.DueDate)
+		}
+
+		err = ExtendDeadline(db, record_id)
+		if err != ErrNotExtensible {
+			t.Errorf("expected: %+v, got: %+v", ErrNotExtensible, err)
+		}
+
+		_, err = BorrowBook(db, user_id, book_id)
+		if err != ErrTooManyLoans {
+			t.Errorf("expected: %+v, got: %+v", ErrTooManyLoans, err)
+		}
+
+		// Book overrides take precedence over the type's policy
+		loan_days := 2
+		o := PolicyOverride{LoanDays: &loan_days}
+		err = SetBookPolicy(db, book_id, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got_o, err := GetBookPolicy(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got_o, o) {
+			t.Errorf("expected: %+v, got: %+v", o, got_o)
+		}
+
+		p.MaxLoans = 2
+		err = SetPolicy(db, type_id, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record_id, err = BorrowBook(db, user_id, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = CheckoutRecord(db, record_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		due = truncateDate(time.Now().Add(days(loan_days)))
+		if !truncateDate(r.DueDate).Equal(due) {
+			t.Errorf("expected due date: %v, got: %v", due, r.DueDate)
+		}
+
+		err = SetBookPolicy(db, book_id, PolicyOverride{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got_o, err = GetBookPolicy(db, book_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got_o, PolicyOverride{}) {
+			t.Errorf("override not removed: %+v", got_o)
+		}
+
+		negative := -1
+		err = SetBookPolicy(db, book_id, PolicyOverride{ExtendDays: &negative})
+		if err != ErrInvalidPolicy {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPolicy, err)
+		}
+		err = SetBookPolicy(db, -1, PolicyOverride{})
+		if err != ErrInvalidBookID {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidBookID, err)
+		}
+	})
+}
+
+func TestSuspendedError(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		// User 7 has more overdue books than the default threshold
+		_, err := BorrowBook(db, 7, 10)
+		if !errors.Is(err, ErrSuspendedUser) {
+			t.Fatalf("expected: %+v, got: %+v", ErrSuspendedUser, err)
+		}
+		if !strings.Contains(err.Error(), "more than 3 overdue") {
+			t.Errorf("threshold not in message: %q", err.Error())
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Book queries combine conditions on fields of books, e.g.
+//
+//	title:graph author:diestel available:>0 -comment:lost
+//	(title:"graph theory" OR title:combinatorics) AND NOT isbn:=978-3-662-53622-3
+//
+// Terms next to each other are joined by AND, which binds tighter than
+// OR. Terms are negated by NOT or a leading "-", and grouped by
+// parentheses. Text fields (`isbn`, `title`, `author`, `description`,
+// `comment`) match values they contain, or equal values with "=", both
+// ignoring case. Numeric fields (`book_id`, `available`) are compared
+// with "=" (the default), ">", ">=", "<" or "<=". A value without a
+// field is looked for in all text fields. Queries are compiled into
+// parameterized SQL by `SQLStore`, and evaluated in memory by
+// `MemoryStore`.
+
+// `QueryOp` is the kind of a `BookQuery` node.
+type QueryOp int
+
+const (
+	QueryTerm QueryOp = iota
+	QueryAnd
+	QueryOr
+	QueryNot
+)
+
+// `BookQuery` is a parsed book query. `Left` and `Right` are the
+// operands of AND and OR, and `Left` is that of NOT. Terms compare
+// `Field` with `Text` or `Number` by `Cmp`, which is ":" for "contains".
+// An empty `Field` means any text field.
+type BookQuery struct {
+	Op          QueryOp
+	Left, Right *BookQuery
+	Field       string
+	Cmp         string
+	Text        string
+	Number      int
+}
+
+// `queryTextFields` and `queryNumberFields` are the fields known to
+// book queries.
+var queryTextFields = []string{"isbn", "title", "author", "description", "comment"}
+var queryNumberFields = []string{"book_id", "available"}
+
+// `MaxQueryDepth` limits nesting of parentheses and negations.
+const MaxQueryDepth = 32
+
+// `QueryError` is returned for malformed queries. `Pos` is the byte
+// offset of the offending token `Token` in the query. It matches
+// `ErrInvalidQuery` in `errors.Is`.
+type QueryError struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e QueryError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("invalid query at %d: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("invalid query at %d near %q: %s", e.Pos, e.Token, e.Msg)
+}
+
+func (e QueryError) Is(target error) bool {
+	return target == ErrInvalidQuery
+}
+
+type queryTokenKind int
+
+const (
+	tokEOF queryTokenKind = iota
+	tokTerm
+	tokAnd
+	tokOr
+	tokNot
+	tokLeft
+	tokRight
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	pos  int
+	text string // as in the query
+	// Terms are split into `field` and `value`
+	field string
+	value string
+}
+
+// `lexQuery` splits `query` into tokens, ending with a `tokEOF`.
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for {
+		for i < len(query) && strings.IndexByte(" \t\r\n", query[i]) >= 0 {
+			i++
+		}
+		if i == len(query) {
+			return append(tokens, queryToken{kind: tokEOF, pos: i}), nil
+		}
+
+		start := i
+		switch {
+		case query[i] == '(':
+			tokens = append(tokens, queryToken{kind: tokLeft, pos: i, text: "("})
+			i++
+			continue
+		case query[i] == ')':
+			tokens = append(tokens, queryToken{kind: tokRight, pos: i, text: ")"})
+			i++
+			continue
+		case query[i] == '-':
+			tokens = append(tokens, queryToken{kind: tokNot, pos: i, text: "-"})
+			i++
+			continue
+		}
+
+		// A term is a word, a phrase, or a field followed by either
+		for i < len(query) && strings.IndexByte(" \t\r\n()\"", query[i]) < 0 {
+			i++
+		}
+		word := query[start:i]
+		t := queryToken{kind: tokTerm, pos: start}
+		if colon := strings.IndexByte(word, ':'); colon >= 0 {
+			t.field = word[:colon]
+			t.value = word[colon+1:]
+		} else {
+			t.value = word
+		}
+
+		if i < len(query) && query[i] == '"' && (word == "" || strings.HasSuffix(word, ":") ||
+			strings.HasSuffix(word, ":=")) {
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, QueryError{i, query[i:], "unterminated quote"}
+			}
+			t.value += query[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			switch word {
+			case "AND":
+				t.kind = tokAnd
+			case "OR":
+				t.kind = tokOr
+			case "NOT":
+				t.kind = tokNot
+			}
+		}
+
+		t.text = query[start:i]
+		tokens = append(tokens, t)
+	}
+}
+
+type queryParser struct {
+	tokens []queryToken
+	depth  int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[0]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[0]
+	if t.kind != tokEOF {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+func (p *queryParser) fail(t queryToken, msg string) error {
+	return QueryError{t.pos, t.text, msg}
+}
+
+// or := and ("OR" and)*
+func (p *queryParser) parseOr() (*BookQuery, error) {
+	q, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		q = &BookQuery{Op: QueryOr, Left: q, Right: right}
+	}
+	return q, nil
+}
+
+// and := unary (["AND"] unary)*
+func (p *queryParser) parseAnd() (*BookQuery, error) {
+	q, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokTerm, tokNot, tokLeft:
+		default:
+			return q, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		q = &BookQuery{Op: QueryAnd, Left: q, Right: right}
+	}
+}
+
+// unary := ("NOT" | "-") unary | "(" or ")" | term
+func (p *queryParser) parseUnary() (*BookQuery, error) {
+	t := p.next()
+	if t.kind == tokNot || t.kind == tokLeft {
+		p.depth++
+		if p.depth > MaxQueryDepth {
+			return nil, p.fail(t, "query nested too deeply")
+		}
+		defer func() { p.depth-- }()
+	}
+
+	switch t.kind {
+	case tokNot:
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &BookQuery{Op: QueryNot, Left: q}, nil
+	case tokLeft:
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRight {
+			return nil, p.fail(p.peek(), "expected \")\"")
+		}
+		p.next()
+		return q, nil
+	case tokTerm:
+		return parseQueryTerm(t)
+	case tokEOF:
+		return nil, p.fail(t, "unexpected end of query")
+	default:
+		return nil, p.fail(t, "expected a term")
+	}
+}
+
+// `parseQueryTerm` checks the field, comparison and value of `t`.
+func parseQueryTerm(t queryToken) (*BookQuery, error) {
+	field := strings.ToLower(t.field)
+	value := t.value
+	q := &BookQuery{Op: QueryTerm, Field: field, Cmp: ":"}
+
+	if contains(queryNumberFields, field) {
+		q.Cmp = "="
+		for _, cmp := range []string{">=", "<=", "=", ">", "<"} {
+			if strings.HasPrefix(value, cmp) {
+				q.Cmp = cmp
+				value = value[len(cmp):]
+				break
+			}
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, QueryError{t.pos, t.text, "expected a number"}
+		}
+		q.Number = n
+		return q, nil
+	}
+
+	if field != "" && !contains(queryTextFields, field) {
+		return nil, QueryError{t.pos, t.text, fmt.Sprintf("unknown field %#v", t.field)}
+	}
+	if field != "" && strings.HasPrefix(value, "=") {
+		q.Cmp = "="
+		value = value[1:]
+	}
+	if value == "" {
+		return nil, QueryError{t.pos, t.text, "missing value"}
+	}
+	q.Text = value
+	return q, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// `ParseBookQuery` parses `query` (see above).
+//
+// Returns a `QueryError` if `query` is malformed.
+func ParseBookQuery(query string) (*BookQuery, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.fail(t, "unexpected token")
+	}
+	return q, nil
+}
+
+// `QueryBooks` returns a page of books matching `query` with
+// available counts per branch. See `PageBooks`.
+//
+// Returns a `QueryError` if `query` is malformed.
+func QueryBooks(db Store, query string, req PageRequest) ([]Book, PageInfo, error) {
+	q, err := ParseBookQuery(query)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	return PageBooks(db, q, req)
+}
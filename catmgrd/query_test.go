@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBookQuery(t *testing.T) {
+	q, err := ParseBookQuery(`title:graph author:"Diestel, R" available:>0 OR -comment:=lost`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &BookQuery{
+		Op: QueryOr,
+		Left: &BookQuery{
+			Op: QueryAnd,
+			Left: &BookQuery{
+				Op:    QueryAnd,
+				Left:  &BookQuery{Field: "title", Cmp: ":", Text: "graph"},
+				Right: &BookQuery{Field: "author", Cmp: ":", Text: "Diestel, R"},
+			},
+			Right: &BookQuery{Field: "available", Cmp: ">", Number: 0},
+		},
+		Right: &BookQuery{
+			Op:   QueryNot,
+			Left: &BookQuery{Field: "comment", Cmp: "=", Text: "lost"},
+		},
+	}
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("unexpected query: %+v", q)
+	}
+
+	tb := []struct {
+		query string
+		pos   int
+		token string
+	}{
+		{"", 0, ""},
+		{"title:", 0, "title:"},
+		{"title:a foo:bar", 8, "foo:bar"},
+		{"available:>x", 0, "available:>x"},
+		{"book_id:=", 0, "book_id:="},
+		{"title:a (author:b", 17, ""},
+		{"title:a )", 8, ")"},
+		{`title:"abc`, 6, `"abc`},
+		{"title:a OR", 10, ""},
+		{"AND title:a", 0, "AND"},
+		{strings.Repeat("(", MaxQueryDepth+1) + "a", MaxQueryDepth, "("},
+	}
+	for _, e := range tb {
+		_, err := ParseBookQuery(e.query)
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: expected: %+v, got: %+v", e.query, ErrInvalidQuery, err)
+			continue
+		}
+		qe := err.(QueryError)
+		if qe.Pos != e.pos || qe.Token != e.token {
+			t.Errorf("%q: expected error at %d near %q, got: %v", e.query, e.pos, e.token, err)
+		}
+	}
+}
+
+func TestQueryBooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		word := randString(10)
+		var ids [3]int
+		for i, e := range []struct {
+			count int
+			info  BookInfo
+		}{
+			{2, BookInfo{Title: strp(word + " alpha"), Author: strp("Smith")}},
+			{0, BookInfo{Title: strp(word + " beta"), Author: strp("Jones"), Comment: strp("lost")}},
+			{1, BookInfo{Title: strp("gamma 100%"), Description: strp(word)}},
+		} {
+			var err error
+			ids[i], err = NewBook(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = UpdateBook(db, ids[i], e.count, e.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tb := []struct {
+			query    string
+			expected []int
+		}{
+			{"title:" + word, []int{ids[0], ids[1]}},
+			{strings.ToUpper(word), []int{ids[0], ids[1], ids[2]}},
+			{"title:" + word + " available:>0", []int{ids[0]}},
+			{"title:" + word + " -comment:lost", []int{ids[0]}},
+			{"title:" + word + " NOT available:0", []int{ids[0]}},
+			{"(author:smith OR author:jones) AND title:" + word, []int{ids[0], ids[1]}},
+			{`title:="` + word + ` alpha"`, []int{ids[0]}},
+			{"title:=" + word, []int{}},
+			{fmt.Sprintf("book_id:%d", ids[2]), []int{ids[2]}},
+			{fmt.Sprintf("book_id:>=%d description:%s", ids[0], word), []int{ids[2]}},
+			{`title:"` + word + ` b" OR description:` + word, []int{ids[1], ids[2]}},
+			{"title:gamma title:100%", []int{ids[2]}},
+			{"title:" + word + "%", []int{}},
+			{"title:" + word[:3] + "_" + word[4:], []int{}},
+		}
+		for _, e := range tb {
+			books, _, err := QueryBooks(db, e.query, PageRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, b := range books {
+				ids = append(ids, b.BookID)
+			}
+			if !reflect.DeepEqual(ids, e.expected) {
+				t.Errorf("%q: expected: %v, got: %v", e.query, e.expected, ids)
+			}
+		}
+
+		_, _, err := QueryBooks(db, "isbn", PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = QueryBooks(db, "shelf:3", PageRequest{})
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidQuery, err)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import "fmt"
+
+// Sample data for tests, same as `sql/samples.sql`.
+
+func strp(v string) *string {
+	return &v
+}
+
+var sampleUserTypes = []UserType{
+	{TypeName: "root", Perm: AllPermissions},
+	{TypeName: "admin", Perm: NewPermissionSet(
+		PermBookCreate, PermBookUpdate, PermBookDelete, PermRecordReturnAny,
+		PermUserCreate, PermUserManage, PermUserTypeManage, PermAPIKeyManage,
+		PermRecordInspectAny, PermPolicyManage, PermFineManage, PermBranchManage,
+	)},
+	{TypeName: "student", Perm: PermissionSet{PermRecordBorrow}},
+	{TypeName: "guest", Perm: PermissionSet{}},
+}
+
+var sampleUsers = []struct {
+	type_id int
+	name    string
+	token   string
+}{
+	{1, "root", "dc76e9f0c0006e8f919e0c515c66dbba3982f785"},   // 1 root
+	{2, "admin", "d033e22ae348aeb5660fc2140aec35850c4da997"},  // 2 admin
+	{3, "riteme", "7c4a8d09ca3762af61e59520943dc26494f8941b"}, // 3 123456
+	{3, "nano", "7c4a8d09ca3762af61e59520943dc26494f8941b"},   // 4 123456
+	{4, "cxk", "dd5fef9c1c1da1394d6d34b248c51be2ad740840"},    // 5 654321
+	{3, "ayaya", "7c4a8d09ca3762af61e59520943dc26494f8941b"},  // 6 123456
+	{3, "lemon", "7c4a8d09ca3762af61e59520943dc26494f8941b"},  // 7 123456
+	{3, "test", "7c4a8d09ca3762af61e59520943dc26494f8941b"},   // 8 123456
+	{3, "steve", "7c4a8d09ca3762af61e59520943dc26494f8941b"},  // 9 123456
+}
+
+// ALL FROM springer.com
+var sampleBooks = []struct {
+	title       *string
+	author      *string
+	isbn        *string
+	count       int
+	description *string
+	comment     *string
+}{
+	{strp("Monte Carlo Methods"), strp("Barbu, Adrian, Zhu, Song-Chun"), strp("978-981-13-2971-5"), 2, nil, nil},
+	{strp("Compiler Design"), strp("Hack, Sebastian, Wilhelm, Reinhard, Seidl, Helmut"), strp("978-3-642-17637-1"), 1, nil, nil},
+	{strp("Energy Internet"), strp("Zobaa, Ahmed F, Cao, Junwei (Eds.)"), strp("978-3-030-45452-4"), 5, strp("Provides an ideal resource for students in advanced graduate-level courses and special topics in energy, information and control systems"), strp("5 books")},
+	{strp("Systems Benchmarking"), strp("Kounev, Samuel, Lange, Klaus-Dieter, von Kistowski, Jóakim"), strp("978-3-030-41704-8"), 3, strp("Provides theoretical and practical foundations as well as an in-depth look at modern benchmarks and benchmark development"), nil},
+	{strp("Database Design and Implementation"), strp("Sciore, Edward"), strp("978-3-030-33836-7"), 9999, nil, strp("too many books!")},
+	{strp("Mathematical Modeling and Computational Tools"), strp("Bhattacharya, Somnath, Kumar, Jitendra, Ghoshal, Koeli (Eds.)"), strp("978-981-15-3615-1"), 23, strp("Collects a wide-range of topics in mathematics, statistics, engineering, healthcare, and their applications"), strp("23 books")},
+	{strp("Foundations of Software Science and Computation Structures"), strp("Goubault-Larrecq, Jean, König, Barbara (Eds.)"), strp("978-3-030-45231-5"), 1, nil, strp("open access")},
+	{strp("Cornerstones"), strp("Birkhäuser Boston"), strp("2197-182X"), 2, strp("Cornerstones comprises textbooks that focus on what students need to know and what faculty should teach regarding various selected topics in pure and applied mathematics and related subjects. Aimed at aspiring young mathematicians at the advanced undergraduate to the second-year graduate level, books that appear in this series are intended to serve as the definitive advanced texts for the next generation of mathematicians. By enlisting only expert mathematicians and leading researchers in each field who are top-notch expositors with established track records, Cornerstones volumes are models of clarity that provide authoritative modern treatments of the essential subjects of pure and applied mathematics while capturing the beauty and excitement of mathematics for the reader. The Series Editors themselves are accomplished researchers with considerable writing experience, and seek to infuse each text with excellence and purpose through a collaborative, yet highly rigorous selection and reviewing protocol."), nil},
+	{strp("Principles of Mathematics for Economics"), strp("Cerreia-Vioglio, Simone, Marinacci, Massimo, Vigna, Elena"), strp("978-3-319-44715-5"), 5, nil, nil},
+	{strp("Algebra for Applications"), strp("Slinko, Arkadii"), strp("978-3-030-44073-2"), 4, strp("Suitable for an undergraduate applied algebra course"), nil},
+	{strp("Fundamental Mathematical Analysis"), strp("Magnus, Robert"), strp("978-3-030-46321-2"), 0, strp("Recognises and addresses student difficulties"), strp("lost")},
+	{strp("A Course in Algebraic Error-Correcting Codes"), strp("Ball, Simeon"), strp("978-3-030-41152-7"), 6, nil, strp("aha!")},
+	{strp("Graph Theory"), strp("Diestel, Reinhard"), strp("978-3-662-53622-3"), 1, strp("Standard textbook of modern graph theory"), nil},
+	{strp("Computational Geometry and Graph Theory"), strp("Ito, H., Kano, M., Katoh, N., Uno, Y. (Eds.)"), strp("978-3-540-89550-3"), 1, nil, strp("conference")},
+	{strp("Graph Theory"), strp("Bollobas, Bela"), strp("978-1-4612-9967-7"), 2, nil, nil},
+	{strp("Graph Theory"), strp("Gera, Ralucca, Hedetniemi, Stephen, Larson, Craig (Eds.)"), strp("978-3-319-31940-7"), 5, strp("Describes the origin and history behind conjectures and problems in graph theory"), nil},
+	{strp("Graph Theory and Applications"), strp("Alavi, Y., Lick, D. R., White, A. T. (Eds.)"), strp("978-3-540-38114-3"), 1, strp("Proceedings of the Conference at Western Michigan University, May 10 - 13, 1972"), strp("conference")},
+	{strp("Computational Graph Theory"), strp("Tinhofer, G., Mayr, E.W., Noltemeier, H., Syslo, M.M., Albrecht, R. (Eds.)"), strp("978-3-7091-9076-0"), 0, strp("One ofthe most important aspects in research fields where mathematics is applied is the construction of a formal model of a real system. As for structural relations, graphs have turned out to provide the most appropriate tool for setting up the mathematical model. This is certainly one of the reasons for the rapid expansion in graph theory during the last decades. Furthermore, in recent years it also became clear that the two disciplines of graph theory and computer science have very much in common, and that each one has been capable of assisting significantly in the development of the other. On one hand, graph theorists have found that many of their problems can be solved by the use of com­ puting techniques, and on the other hand, computer scientists have realized that many of their concepts, with which they have to deal, may be conveniently expressed in the lan­ guage of graph theory, and that standard results in graph theory are often very relevant to the solution of problems concerning them. As a consequence, a tremendous number of publications has appeared, dealing with graphtheoretical problems from a computational point of view or treating computational problems using graph theoretical concepts."), strp("lost")},
+	{strp("Basic Graph Theory"), strp("Rahman, Md. Saidur"), strp("978-3-319-49475-3"), 3, strp("This undergraduate textbook provides an introduction to graph theory, which has numerous applications in modeling problems in science and technology, and has become a vital component to computer science, computer science and engineering, and mathematics curricula of universities all over the world."), nil},
+	{strp("Combinatorics and Graph Theory"), strp("Harris, John M., Hirst, Jeffry L., Mossinghoff, Michael J."), strp("978-1-4757-4803-1"), 8, nil, nil},
+	{strp("Graph Theory and Algorithms"), strp("Saito, N., Nishizeki, T. (Eds.)"), strp("978-3-540-10704-0"), 6, strp("17th Symposium of Research Institute of Electrical Communication, Tohoku University, Sendai, Japan, October 24-25, 1980. Proceedings"), nil},
+	{strp("Algebraic Graph Theory"), strp("Godsil, Chris, Royle, Gordon F."), strp("978-1-4613-0163-9"), 3, nil, strp("no description")},
+	{strp("Ten Applications of Graph Theory"), strp("Walther, Hansjoachim"), strp("978-94-009-7154-7"), 2, strp("Growing specialization and diversification have brought a host of monographs and textbooks on increasingly specialized topics. However, the \"tree\" of knowledge of mathematics and related fields does not grow only by putting forth new bran­ ches. It also happens, quite often in fact, that branches which were thought to be completely disparate are suddenly seen to be related. Further, the kind and level of sophistication of mathematics applied in various sciences has changed drastically in recent years: measure theory is used (non-tri­ vially) in regional and theoretical economics; algebraic geometry interacts with physics; the Minkowsky lemma, coding theory and the structure of water meet one another in packing and covering theory; quantum fields, crystal defects and mathematical programming profit from homotopy theory; Lie algebras are relevant to filtering; and prediction and electrical engineering can use Stein spaces. And in addition to this there are such new emerging subdisciplines as \"completely integrable systems\", \"chaos, synergetics and large-scale order\", which are almost impossible to fit into the existing classification schemes. They draw upon widely different sections of mathematics. This program, Mathematics and Its Applications, is devoted to such (new) interrelations as exempla gratia: - a central concept which plays an important role in several different mathe­ matical and/or scientific specialized areas; - new applications of the results and ideas from one area of scientific endeavor into another; - influences which the results, problems and concepts of one field of enquiry have and have had on the development of another."), nil},
+	{strp("Graph Drawing"), strp("Whitesides, Sue H. (Ed.)"), strp("978-3-540-37623-1"), 6, strp("6th International Symposium, GD '98 Montreal, Canada, August 13-15, 1998 Proceedings"), strp("conference")},
+	{strp("Graph Drawing"), strp("Kratochvil, Jan (Ed.)"), strp("978-3-540-46648-2"), 1, strp("7th International Symposium, GD'99, Stirin Castle, Czech Republic, September 15-19, 1999 Proceedings"), nil},
+	{strp("Encyclopedia of Algorithms"), strp("Kao, Ming-Yang (Ed.)"), strp("978-1-4939-2865-1"), 1, strp("Covers a wealth of problems currently relevant in diverse fields including biology, economics, financial software and computer science, amongst others"), strp("TOO EXPENSIVE!")},
+}
+
+var sampleRecords = []struct {
+	user_id int
+	book_id int
+	ret     string
+	borrow  string
+	due     string
+	final   string
+}{
+	{9, 5, "", "2020-02-01", "2999-01-01", "2999-01-02"},           // 1 normal
+	{9, 5, "", "2020-02-02", "2020-02-19", "2020-03-04"},           // 2 overdue
+	{9, 5, "2020-02-04", "2020-02-03", "2999-02-07", "2999-02-07"}, // 3 normal return
+	{9, 5, "2020-03-04", "2020-02-04", "2020-02-29", "2020-02-29"}, // 4 overdue return
+	{6, 1, "", "1926-08-17", "1926-09-17", "2020-02-02"},
+	{6, 1, "", "1926-08-17", "1926-09-17", "2020-02-02"},
+	{6, 1, "", "1926-08-17", "1926-09-17", "2020-02-02"},
+	{7, 2, "", "2020-03-14", "2020-03-15", "2020-03-16"},
+	{7, 3, "", "2020-03-14", "2020-03-15", "2020-03-16"},
+	{7, 4, "", "2020-03-14", "2020-03-15", "2020-03-16"},
+	{7, 5, "", "2020-03-14", "2020-03-15", "2020-03-16"},
+	{4, 9, "", "2020-03-13", "2999-09-26", "2999-09-26"},
+	{4, 10, "", "2020-03-13", "2999-09-26", "2999-09-26"},
+	{4, 11, "", "2020-03-13", "2999-09-26", "2999-09-26"},
+	{4, 12, "", "2020-03-13", "2999-09-26", "2999-09-26"},
+	{4, 13, "", "2020-03-13", "2999-09-26", "2999-09-26"},
+	{4, 5, "", "2020-01-01", "2020-05-03", "2020-06-03"},
+	{4, 5, "", "2020-01-01", "2020-05-03", "2020-05-12"},
+}
+
+// `loadSamples` fills an empty store `s` with sample data.
+func loadSamples(s Store) error {
+	for _, t := range sampleUserTypes {
+		_, err := s.InsertUserType(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, u := range sampleUsers {
+		_, err := s.InsertUser(u.type_id, u.name, u.token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Atomic(func(s Store) error {
+		for _, b := range sampleBooks {
+			book_id, err := s.InsertBook()
+			if err != nil {
+				return err
+			}
+
+			// ISBNs are stored as migration 0014 leaves them
+			isbn := b.isbn
+			if b.isbn != nil {
+				if isbn13, err := NormalizeISBN(*b.isbn); err == nil {
+					isbn = &isbn13
+				}
+			}
+			err = s.ModifyBook(book_id, BookInfo{
+				Title:       b.title,
+				Author:      b.author,
+				ISBN:        isbn,
+				ISBNDisplay: b.isbn,
+				Description: b.description,
+				Comment:     b.comment,
+			})
+			if err != nil {
+				return err
+			}
+
+			for i := 1; i <= b.count; i++ {
+				_, err = s.InsertItem(Item{
+					BookID:     book_id,
+					BranchID:   DefaultBranchID,
+					Barcode:    fmt.Sprintf("%d-%d", book_id, i),
+					Status:     ItemAvailable,
+					AcquiredAt: parseDate("2020-01-01"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Unreturned records have copies on loan, barcoded as by
+		// migration 0012
+		for i, r := range sampleRecords {
+			item_id := 0
+			if r.ret == "" {
+				var err error
+				item_id, err = s.InsertItem(Item{
+					BookID:     r.book_id,
+					BranchID:   DefaultBranchID,
+					Barcode:    fmt.Sprintf("%d-R%d", r.book_id, i+1),
+					Status:     ItemLoaned,
+					AcquiredAt: parseDate("2020-01-01"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := s.InsertRecord(Record{
+				UserID:     r.user_id,
+				BookID:     r.book_id,
+				ItemID:     item_id,
+				Returned:   r.ret != "",
+				ReturnDate: parseDate(r.ret),
+				BorrowDate: parseDate(r.borrow),
+				DueDate:    parseDate(r.due),
+				FinalDate:  parseDate(r.final),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sessions let clients send passwords only once, to "/login". The
+// token returned is an opaque random string. Only its SHA-256 hash is
+// stored in table Session, so leaked database rows cannot be used to
+// impersonate users. Tokens are sent back in the "Authorization:
+// Bearer <token>" header.
+const sessionTokenLen = 32
+
+// `SessionLifetime` is how long a session lasts after login.
+var SessionLifetime = 24 * time.Hour
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// `newToken` returns `n` random bytes encoded in base64 (URL-safe).
+func newToken(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// `hashToken` returns the SHA-256 hex digest of `token`. Unlike
+// passwords, tokens are random enough that no salt is needed.
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// `Login` authenticates `user` with `password` from remote address
+// `addr` and starts a new session. Returns the session token along
+// with the session. Expired sessions of all users are purged on every
+// login.
+//
+// May return errors from `AuthUserFrom`.
+func Login(db Store, addr string, user interface{}, password string) (string, Session, error) {
+	u, err := AuthUserFrom(db, addr, user, password, nil)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	// Session tokens must not look like API keys
+	var token string
+	for token == "" || isAPIKey(token) {
+		token, err = newToken(sessionTokenLen)
+		if err != nil {
+			return "", Session{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	err = db.PurgeSessions(now)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	session := Session{
+		UserID:    u.UserID,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionLifetime),
+	}
+	session.SessionID, err = db.InsertSession(session)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	return token, session, nil
+}
+
+// `AuthSession` checks session `token` and permissions `req` of its
+// user, and returns the user.
+//
+// May return `ErrInvalidSession`, `ErrInactiveUser`, `ErrExpiredUser`
+// or `ErrPermissionDenied`.
+func AuthSession(db Store, token string, req PermissionSet) (User, error) {
+	session, err := db.SessionByTokenHash(hashToken(token))
+	if err != nil {
+		return User{}, err
+	}
+	if session.Revoked || !time.Now().Before(session.ExpiresAt) {
+		return User{}, ErrInvalidSession
+	}
+
+	u, err := db.UserByID(session.UserID)
+	if err == ErrInvalidUser {
+		return User{}, ErrInvalidSession
+	}
+	if err != nil {
+		return User{}, err
+	}
+	err = checkUserStatus(u, time.Now())
+	if err != nil {
+		return User{}, err
+	}
+
+	if !u.Perm.Contains(req) {
+		return User{}, ErrPermissionDenied
+	}
+
+	return u, nil
+}
+
+// `Logout` revokes the session of `token`. If `all` is set, all
+// sessions of the same user are revoked.
+//
+// May return `ErrInvalidSession`.
+func Logout(db Store, token string, all bool) error {
+	session, err := db.SessionByTokenHash(hashToken(token))
+	if err != nil {
+		return err
+	}
+	if session.Revoked || !time.Now().Before(session.ExpiresAt) {
+		return ErrInvalidSession
+	}
+
+	if all {
+		return db.RevokeUserSessions(session.UserID)
+	}
+	return db.RevokeSession(session.SessionID)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, _, err := Login(db, "", "riteme", "1234567")
+		if err != ErrInvalidPassword {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidPassword, err)
+		}
+
+		token, session, err := Login(db, "", "riteme", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.UserID != 3 || !session.ExpiresAt.After(time.Now()) {
+			t.Errorf("unexpected session: %+v", session)
+		}
+
+		u, err := AuthSession(db, token, PermissionSet{PermRecordBorrow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.UserID != 3 {
+			t.Errorf("expected user 3, got %d", u.UserID)
+		}
+
+		_, err = AuthSession(db, token, PermissionSet{PermBookUpdate})
+		if err != ErrPermissionDenied {
+			t.Errorf("expected: %+v, got: %+v", ErrPermissionDenied, err)
+		}
+		_, err = AuthSession(db, token+"x", PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+
+		other, _, err := Login(db, "", 3, "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other == token {
+			t.Errorf("tokens should differ")
+		}
+
+		err = Logout(db, token, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthSession(db, token, PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+		err = Logout(db, token, false)
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+
+		_, err = AuthSession(db, other, PermissionSet{})
+		if err != nil {
+			t.Errorf("expected: %+v, got: %+v", nil, err)
+		}
+		err = Logout(db, other, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthSession(db, other, PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+	})
+}
+
+func TestSessionExpire(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer func(v time.Duration) { SessionLifetime = v }(SessionLifetime)
+
+		SessionLifetime = -time.Minute
+		token, session, err := Login(db, "", "nano", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = AuthSession(db, token, PermissionSet{})
+		if err != ErrInvalidSession {
+			t.Errorf("expected: %+v, got: %+v", ErrInvalidSession, err)
+		}
+
+		SessionLifetime = time.Hour
+		_, _, err = Login(db, "", "nano", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.SessionByTokenHash(session.TokenHash)
+		if err != ErrInvalidSession {
+			t.Errorf("expired session is not purged: %+v", err)
+		}
+	})
+}
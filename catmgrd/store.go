@@ -0,0 +1,212 @@
+package main
+
+import "time"
+
+// `Store` is the storage backend behind all catalog and circulation
+// functions in api.go. It covers users, user types, books, items and
+// records.
+//
+// Implementations only provide primitive operations. Business rules
+// (authentication, deadlines, suspension, etc.) live in api.go and are
+// shared by all backends.
+type Store interface {
+	// `Atomic` runs `fn` in a transaction. All operations done through
+	// the `Store` passed to `fn` are committed if `fn` returns nil, and
+	// discarded otherwise. Nested calls join the outer transaction.
+	Atomic(fn func(s Store) error) error
+
+	// `UserByID` and `UserByName` return `ErrInvalidUser` if no such
+	// user exists.
+	UserByID(user_id int) (User, error)
+	UserByName(name string) (User, error)
+	InsertUser(type_id int, name string, token string) (int, error)
+	// `SetUserToken` replaces the password hash of a user, and sets
+	// whether the password must be changed on next login.
+	SetUserToken(user_id int, token string, must_change bool) error
+	SetUserName(user_id int, name string) error
+	SetUserType(user_id int, type_id int) error
+	// `SetUserStatus` sets whether a user is active and when the account
+	// expires. Zero `expires_at` means never.
+	SetUserStatus(user_id int, active bool, expires_at time.Time) error
+	// `DeleteUser` removes a user along with its sessions, API keys,
+	// holds and failure counter. It fails if the user has any record.
+	DeleteUser(user_id int) error
+
+	// `UserTypeByName` and `UserTypeByID` return `ErrInvalidUserType`
+	// if no such user type exists.
+	UserTypeByName(type_name string) (UserType, error)
+	UserTypeByID(type_id int) (UserType, error)
+	// `ListUserTypes` returns all user types ordered by type ID.
+	ListUserTypes() ([]UserType, error)
+	InsertUserType(t UserType) (int, error)
+	// `UpdateUserType` and `DeleteUserType` return `ErrInvalidUserType`
+	// if no type has the ID. Types in use cannot be deleted.
+	UpdateUserType(t UserType) error
+	DeleteUserType(type_id int) error
+	CountUsersOfType(type_id int) (int, error)
+	// `TypePolicy` returns the circulation policy of `type_id`, which
+	// is `DefaultPolicy` if none is set.
+	TypePolicy(type_id int) (Policy, error)
+	SetTypePolicy(type_id int, p Policy) error
+	// `BookPolicy` returns overrides of `book_id`, which are empty if
+	// none is set. Empty overrides are removed by `SetBookPolicy`.
+	BookPolicy(book_id int) (PolicyOverride, error)
+	SetBookPolicy(book_id int, o PolicyOverride) error
+
+	// `SessionByTokenHash` returns `ErrInvalidSession` if no session
+	// has `token_hash`. Revoked and expired sessions are returned as is.
+	SessionByTokenHash(token_hash string) (Session, error)
+	InsertSession(session Session) (int, error)
+	RevokeSession(session_id int) error
+	RevokeUserSessions(user_id int) error
+	// `PurgeSessions` deletes sessions that expire before `now`.
+	PurgeSessions(now time.Time) error
+
+	// `APIKeyByHash` returns `ErrInvalidAPIKey` if no API key has
+	// `key_hash`. Revoked keys are returned as is.
+	APIKeyByHash(key_hash string) (APIKey, error)
+	// `APIKeyByID` returns `ErrInvalidAPIKey` if no API key has `key_id`.
+	APIKeyByID(key_id int) (APIKey, error)
+	InsertAPIKey(k APIKey) (int, error)
+	// `ListAPIKeys` returns keys of `user_id`, or all keys if `user_id`
+	// is 0, ordered by key ID.
+	ListAPIKeys(user_id int) ([]APIKey, error)
+	// `RevokeAPIKey` returns `ErrInvalidAPIKey` if no key has `key_id`.
+	RevokeAPIKey(key_id int) error
+	RevokeUserAPIKeys(user_id int) error
+	// `TouchAPIKey` sets the last used time of a key to `now`.
+	TouchAPIKey(key_id int, now time.Time) error
+
+	// `AuthFailure` returns the failure counter of `subject`, which is
+	// zero if there is no failure recorded.
+	AuthFailure(subject string) (AuthFailure, error)
+	SetAuthFailure(f AuthFailure) error
+	DeleteAuthFailure(subject string) error
+
+	// `BookByID` and `BookByISBN` return `ErrBookNotFound` if no such
+	// book exists.
+	BookByID(book_id int) (Book, error)
+	BookByISBN(isbn string) (Book, error)
+	// `ListBooks` returns all books ordered by book ID.
+	ListBooks() ([]Book, error)
+	// `QueryBooks` returns books matching `q` (see query.go), or all
+	// books if `q` is nil, in page `p` (see page.go). Books are ordered
+	// by book ID by default.
+	QueryBooks(q *BookQuery, p Page) ([]Book, error)
+	CountBooks(q *BookQuery) (int, error)
+	InsertBook() (int, error)
+	// `ModifyBook` returns `ErrInvalidBookID` if no book has `book_id`.
+	ModifyBook(book_id int, info BookInfo) error
+
+	// `ItemByID` returns `ErrInvalidItemID`, and `ItemByBarcode`
+	// returns `ErrInvalidBarcode`, if no such item exists.
+	ItemByID(item_id int) (Item, error)
+	ItemByBarcode(barcode string) (Item, error)
+	// `InsertItem` adds `it` as is. `it.ItemID` is ignored.
+	InsertItem(it Item) (int, error)
+	SetItemStatus(item_id int, status ItemStatus) error
+	// `ChangeItemStatus` changes the status of item `item_id` from
+	// `from` to `to`, and returns `ErrNoAvailableBook` if the item is not
+	// `from`, e.g. when a concurrent transaction has taken it.
+	ChangeItemStatus(item_id int, from, to ItemStatus) error
+	// `ListItems` returns copies of `book_id` ordered by item ID.
+	ListItems(book_id int) ([]Item, error)
+	// `AvailableItem` returns the available copy of `book_id` at
+	// `branch_id` (at any branch if 0) with the least item ID, which is
+	// zero if there is none.
+	AvailableItem(book_id, branch_id int) (Item, error)
+	// `CountCopies` returns the number of copies of `book_id` in
+	// circulation, i.e. neither lost nor withdrawn.
+	CountCopies(book_id int) (int, error)
+	// `BranchCounts` returns available counts of `book_id` at branches
+	// having copies of it, ordered by branch ID.
+	BranchCounts(book_id int) ([]BranchCount, error)
+
+	// `BranchByID` returns `ErrInvalidBranchID` if no such branch
+	// exists.
+	BranchByID(branch_id int) (Branch, error)
+	// `ListBranches` returns all branches ordered by branch ID.
+	ListBranches() ([]Branch, error)
+	InsertBranch(name string) (int, error)
+	// `ItemLoan` returns the unreturned record of `item_id`, which is
+	// zero if there is none.
+	ItemLoan(item_id int) (Record, error)
+
+	// `HoldByID` returns `ErrInvalidHoldID` if no such hold exists.
+	HoldByID(hold_id int) (Hold, error)
+	// `InsertHold` adds `h` as is. `h.HoldID` and `h.Position` are
+	// ignored. `ExpiresAt` is stored only for ready holds.
+	InsertHold(h Hold) (int, error)
+	// `UpdateHold` sets status, item and pickup window of `h.HoldID`
+	// to those of `h`.
+	UpdateHold(h Hold) error
+	// `ActiveHold` returns the waiting or ready hold of `user_id` on
+	// `book_id`, which is zero if there is none.
+	ActiveHold(user_id, book_id int) (Hold, error)
+	// `NextHold` returns the earliest waiting hold on `book_id`, which
+	// is zero if there is none.
+	NextHold(book_id int) (Hold, error)
+	// `ListHolds` returns waiting and ready holds of `user_id`,
+	// ordered by hold ID. `Position` is not filled.
+	ListHolds(user_id int) ([]Hold, error)
+	// `CountHoldsBefore` counts waiting holds on `book_id` placed
+	// before `hold_id`.
+	CountHoldsBefore(book_id, hold_id int) (int, error)
+	// `ExpiredHolds` returns ready holds whose pickup windows end
+	// before `now`.
+	ExpiredHolds(now time.Time) ([]Hold, error)
+
+	// `InsertFine` adds ledger entry `f` as is. `f.FineID` is ignored.
+	InsertFine(f Fine) (int, error)
+	// `ListFines` returns ledger entries of `user_id` in order.
+	ListFines(user_id int) ([]Fine, error)
+	// `FineBalance` returns charges minus payments and waivers of
+	// `user_id`.
+	FineBalance(user_id int) (int, error)
+
+	// `RecordByID` returns `ErrInvalidRecordID` if no such record exists.
+	RecordByID(record_id int) (Record, error)
+	// `InsertRecord` adds `r` as is. `r.RecordID`, `r.Username` and
+	// `r.Barcode` are ignored. `ReturnDate` is stored only if
+	// `r.Returned` is set. Zero branch IDs are stored as NULL.
+	InsertRecord(r Record) (int, error)
+	SetDeadline(record_id int, due time.Time) error
+	// `SetReturnDate` marks a record as returned at `branch_id`.
+	SetReturnDate(record_id int, date time.Time, branch_id int) error
+	// `CountOverdue` counts unreturned records of `user_id` whose
+	// deadlines are before `now`.
+	CountOverdue(user_id int, now time.Time) (int, error)
+	// `CountLoans` counts unreturned records of `user_id`.
+	CountLoans(user_id int) (int, error)
+	// `ListRecords` returns records of `user_id` that match `filter`
+	// in page `p` (see page.go). Records are ordered by borrow date,
+	// latest first, by default.
+	ListRecords(user_id int, filter RecordFilter, p Page) ([]Record, error)
+	CountRecords(user_id int, filter RecordFilter) (int, error)
+}
+
+// `RecordFilter` selects records in `ListRecords`.
+// Zero values impose no restriction.
+type RecordFilter struct {
+	Returned    bool
+	NotReturned bool
+	DueBefore   time.Time
+}
+
+// `truncateDate` drops the time of day of `t`, as DATE columns do.
+func truncateDate(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// `ceilDate` returns the earliest midnight not before `t`. For any
+// DATE value d, d < t if and only if d < ceilDate(t). It is used when
+// comparing DATE columns with a point of time, because PostgreSQL
+// converts the latter to a DATE first.
+func ceilDate(t time.Time) time.Time {
+	date := truncateDate(t)
+	if date.Before(t) {
+		date = date.Add(24 * time.Hour)
+	}
+	return date
+}
@@ -0,0 +1,1411 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errDuplicateKey = errors.New("duplicate entry for unique key")
+var errForeignKey = errors.New("foreign key constraint fails")
+
+type memBook struct {
+	BookInfo
+}
+
+type memData struct {
+	user_types []UserType
+	users      []User
+	books      []memBook
+	items      []Item
+	branches   []Branch
+	records    []Record
+	// Deleted users, purged sessions and holds of deleted users are left
+	// as zero values.
+	sessions []Session
+	api_keys []APIKey
+	holds    []Hold
+	fines    []Fine
+	// Indexed by subject.
+	auth_failures map[string]AuthFailure
+	// Indexed by type ID and book ID respectively.
+	policies      map[int]Policy
+	book_policies map[int]PolicyOverride
+}
+
+// `clone` makes a copy of `d` that shares nothing mutable with it.
+func (d *memData) clone() *memData {
+	c := &memData{
+		user_types: append([]UserType(nil), d.user_types...),
+		users:      append([]User(nil), d.users...),
+		books:      append([]memBook(nil), d.books...),
+		items:      append([]Item(nil), d.items...),
+		branches:   append([]Branch(nil), d.branches...),
+		records:    append([]Record(nil), d.records...),
+		sessions:   append([]Session(nil), d.sessions...),
+		api_keys:   append([]APIKey(nil), d.api_keys...),
+		holds:      append([]Hold(nil), d.holds...),
+		fines:      append([]Fine(nil), d.fines...),
+
+		auth_failures: map[string]AuthFailure{},
+		policies:      map[int]Policy{},
+		book_policies: map[int]PolicyOverride{},
+	}
+	for k, v := range d.auth_failures {
+		c.auth_failures[k] = v
+	}
+	for k, v := range d.policies {
+		c.policies[k] = v
+	}
+	for k, v := range d.book_policies {
+		c.book_policies[k] = v
+	}
+	return c
+}
+
+// `MemoryStore` implements `Store` in memory. All data are lost when
+// the process exits. IDs start from 1, same as AUTO_INCREMENT columns.
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data **memData
+	tx   bool
+}
+
+// The default branch is created, as by migration 0013.
+func NewMemoryStore() *MemoryStore {
+	data := &memData{
+		branches:      []Branch{{DefaultBranchID, "main"}},
+		auth_failures: map[string]AuthFailure{},
+		policies:      map[int]Policy{},
+		book_policies: map[int]PolicyOverride{},
+	}
+	return &MemoryStore{mu: &sync.Mutex{}, data: &data}
+}
+
+// `lock` acquires the store lock unless `s` is already in a
+// transaction, and returns the function to release it.
+func (s *MemoryStore) lock() func() {
+	if s.tx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *MemoryStore) Atomic(fn func(s Store) error) error {
+	if s.tx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := (*s.data).clone()
+	err := fn(&MemoryStore{mu: s.mu, data: s.data, tx: true})
+	if err != nil {
+		*s.data = snapshot
+	}
+
+	return err
+}
+
+func (d *memData) userType(type_id int) (UserType, bool) {
+	if type_id < 1 || type_id > len(d.user_types) || d.user_types[type_id-1].TypeID == 0 {
+		return UserType{}, false
+	}
+	return d.user_types[type_id-1], true
+}
+
+func (d *memData) user(u User) User {
+	t, _ := d.userType(u.TypeID)
+	u.Perm = t.Perm
+	return u
+}
+
+// `hasUser` reports whether `user_id` exists and is not deleted.
+func (d *memData) hasUser(user_id int) bool {
+	return user_id >= 1 && user_id <= len(d.users) && d.users[user_id-1].UserID != 0
+}
+
+func (s *MemoryStore) UserByID(user_id int) (User, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(user_id) {
+		return User{}, ErrInvalidUser
+	}
+	return d.user(d.users[user_id-1]), nil
+}
+
+func (s *MemoryStore) UserByName(name string) (User, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, u := range d.users {
+		if u.UserID != 0 && u.Name == name {
+			return d.user(u), nil
+		}
+	}
+	return User{}, ErrInvalidUser
+}
+
+func (s *MemoryStore) InsertUser(type_id int, name string, token string) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.userType(type_id); !ok {
+		return -1, errForeignKey
+	}
+	for _, u := range d.users {
+		if u.UserID != 0 && u.Name == name {
+			return -1, errDuplicateKey
+		}
+	}
+
+	user_id := len(d.users) + 1
+	d.users = append(d.users, User{
+		UserID: user_id,
+		TypeID: type_id,
+		Name:   name,
+		Token:  token,
+		Active: true,
+	})
+	return user_id, nil
+}
+
+func (s *MemoryStore) SetUserName(user_id int, name string) error {
+	defer s.lock()()
+	d := *s.data
+
+	for _, u := range d.users {
+		if u.UserID != 0 && u.UserID != user_id && u.Name == name {
+			return errDuplicateKey
+		}
+	}
+	if d.hasUser(user_id) {
+		d.users[user_id-1].Name = name
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetUserType(user_id int, type_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.userType(type_id); !ok {
+		return errForeignKey
+	}
+	if d.hasUser(user_id) {
+		d.users[user_id-1].TypeID = type_id
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetUserStatus(user_id int, active bool, expires_at time.Time) error {
+	defer s.lock()()
+	d := *s.data
+
+	if d.hasUser(user_id) {
+		d.users[user_id-1].Active = active
+		d.users[user_id-1].ExpiresAt = expires_at.UTC()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(user_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(user_id) {
+		return nil
+	}
+	for _, r := range d.records {
+		if r.UserID == user_id {
+			return errForeignKey
+		}
+	}
+	for _, f := range d.fines {
+		if f.UserID == user_id {
+			return errForeignKey
+		}
+	}
+
+	for i := range d.sessions {
+		if d.sessions[i].UserID == user_id {
+			d.sessions[i] = Session{}
+		}
+	}
+	for i := range d.api_keys {
+		if d.api_keys[i].UserID == user_id {
+			d.api_keys[i] = APIKey{}
+		}
+	}
+	for i := range d.holds {
+		if d.holds[i].UserID == user_id {
+			d.holds[i] = Hold{}
+		}
+	}
+	delete(d.auth_failures, userSubject(user_id))
+	d.users[user_id-1] = User{}
+	return nil
+}
+
+func (s *MemoryStore) SetUserToken(user_id int, token string, must_change bool) error {
+	defer s.lock()()
+	d := *s.data
+
+	if d.hasUser(user_id) {
+		d.users[user_id-1].Token = token
+		d.users[user_id-1].MustChange = must_change
+	}
+	return nil
+}
+
+func (s *MemoryStore) SessionByTokenHash(token_hash string) (Session, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, session := range d.sessions {
+		if session.SessionID != 0 && session.TokenHash == token_hash {
+			return session, nil
+		}
+	}
+	return Session{}, ErrInvalidSession
+}
+
+func (s *MemoryStore) InsertSession(session Session) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(session.UserID) {
+		return -1, errForeignKey
+	}
+	for _, e := range d.sessions {
+		if e.SessionID != 0 && e.TokenHash == session.TokenHash {
+			return -1, errDuplicateKey
+		}
+	}
+
+	session.SessionID = len(d.sessions) + 1
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	d.sessions = append(d.sessions, session)
+	return session.SessionID, nil
+}
+
+func (s *MemoryStore) RevokeSession(session_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if session_id >= 1 && session_id <= len(d.sessions) && d.sessions[session_id-1].SessionID != 0 {
+		d.sessions[session_id-1].Revoked = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserSessions(user_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	for i := range d.sessions {
+		if d.sessions[i].SessionID != 0 && d.sessions[i].UserID == user_id {
+			d.sessions[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) PurgeSessions(now time.Time) error {
+	defer s.lock()()
+	d := *s.data
+
+	for i := range d.sessions {
+		if d.sessions[i].ExpiresAt.Before(now) {
+			d.sessions[i] = Session{}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) APIKeyByHash(key_hash string) (APIKey, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, k := range d.api_keys {
+		if k.KeyID != 0 && k.KeyHash == key_hash {
+			return k, nil
+		}
+	}
+	return APIKey{}, ErrInvalidAPIKey
+}
+
+func (s *MemoryStore) APIKeyByID(key_id int) (APIKey, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if key_id < 1 || key_id > len(d.api_keys) || d.api_keys[key_id-1].KeyID == 0 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return d.api_keys[key_id-1], nil
+}
+
+func (s *MemoryStore) InsertAPIKey(k APIKey) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(k.UserID) {
+		return -1, errForeignKey
+	}
+	for _, e := range d.api_keys {
+		if e.KeyID != 0 && e.KeyHash == k.KeyHash {
+			return -1, errDuplicateKey
+		}
+	}
+	if k.Scope.Validate() != nil {
+		return -1, errForeignKey
+	}
+
+	k.KeyID = len(d.api_keys) + 1
+	k.Scope = NewPermissionSet(k.Scope...)
+	k.CreatedAt = k.CreatedAt.UTC()
+	k.Used = false
+	k.LastUsed = time.Time{}
+	d.api_keys = append(d.api_keys, k)
+	return k.KeyID, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(user_id int) ([]APIKey, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []APIKey{}
+	for _, k := range d.api_keys {
+		if k.KeyID != 0 && (user_id == 0 || k.UserID == user_id) {
+			list = append(list, k)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(key_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if key_id < 1 || key_id > len(d.api_keys) || d.api_keys[key_id-1].KeyID == 0 {
+		return ErrInvalidAPIKey
+	}
+	d.api_keys[key_id-1].Revoked = true
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserAPIKeys(user_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	for i := range d.api_keys {
+		if d.api_keys[i].KeyID != 0 && d.api_keys[i].UserID == user_id {
+			d.api_keys[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) TouchAPIKey(key_id int, now time.Time) error {
+	defer s.lock()()
+	d := *s.data
+
+	if key_id >= 1 && key_id <= len(d.api_keys) && d.api_keys[key_id-1].KeyID != 0 {
+		d.api_keys[key_id-1].Used = true
+		d.api_keys[key_id-1].LastUsed = now.UTC()
+	}
+	return nil
+}
+
+func (s *MemoryStore) AuthFailure(subject string) (AuthFailure, error) {
+	defer s.lock()()
+	d := *s.data
+
+	f, ok := d.auth_failures[subject]
+	if !ok {
+		return AuthFailure{Subject: subject}, nil
+	}
+	return f, nil
+}
+
+func (s *MemoryStore) SetAuthFailure(f AuthFailure) error {
+	defer s.lock()()
+	d := *s.data
+
+	f.LastFailure = f.LastFailure.UTC()
+	f.LockedUntil = f.LockedUntil.UTC()
+	d.auth_failures[f.Subject] = f
+	return nil
+}
+
+func (s *MemoryStore) DeleteAuthFailure(subject string) error {
+	defer s.lock()()
+	d := *s.data
+
+	delete(d.auth_failures, subject)
+	return nil
+}
+
+func (s *MemoryStore) UserTypeByName(type_name string) (UserType, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, t := range d.user_types {
+		if t.TypeID != 0 && t.TypeName == type_name {
+			return t, nil
+		}
+	}
+	return UserType{}, ErrInvalidUserType
+}
+
+func (s *MemoryStore) UserTypeByID(type_id int) (UserType, error) {
+	defer s.lock()()
+	d := *s.data
+
+	t, ok := d.userType(type_id)
+	if !ok {
+		return UserType{}, ErrInvalidUserType
+	}
+	return t, nil
+}
+
+func (s *MemoryStore) ListUserTypes() ([]UserType, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []UserType{}
+	for _, t := range d.user_types {
+		if t.TypeID != 0 {
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) UpdateUserType(t UserType) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.userType(t.TypeID); !ok {
+		return ErrInvalidUserType
+	}
+	for _, e := range d.user_types {
+		if e.TypeID != 0 && e.TypeID != t.TypeID && e.TypeName == t.TypeName {
+			return errDuplicateKey
+		}
+	}
+	if t.Perm.Validate() != nil {
+		return errForeignKey
+	}
+
+	t.Perm = NewPermissionSet(t.Perm...)
+	d.user_types[t.TypeID-1] = t
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserType(type_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.userType(type_id); !ok {
+		return ErrInvalidUserType
+	}
+	for _, u := range d.users {
+		if u.UserID != 0 && u.TypeID == type_id {
+			return errForeignKey
+		}
+	}
+
+	d.user_types[type_id-1] = UserType{}
+	delete(d.policies, type_id)
+	return nil
+}
+
+func (s *MemoryStore) CountUsersOfType(type_id int) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	cnt := 0
+	for _, u := range d.users {
+		if u.UserID != 0 && u.TypeID == type_id {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (s *MemoryStore) TypePolicy(type_id int) (Policy, error) {
+	defer s.lock()()
+	d := *s.data
+
+	p, ok := d.policies[type_id]
+	if !ok {
+		return DefaultPolicy, nil
+	}
+	return p, nil
+}
+
+func (s *MemoryStore) SetTypePolicy(type_id int, p Policy) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.userType(type_id); !ok {
+		return errForeignKey
+	}
+	d.policies[type_id] = p
+	return nil
+}
+
+func (s *MemoryStore) InsertUserType(t UserType) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, e := range d.user_types {
+		if e.TypeID != 0 && e.TypeName == t.TypeName {
+			return -1, errDuplicateKey
+		}
+	}
+	if t.Perm.Validate() != nil {
+		return -1, errForeignKey
+	}
+
+	t.Perm = NewPermissionSet(t.Perm...)
+	t.TypeID = len(d.user_types) + 1
+	d.user_types = append(d.user_types, t)
+	return t.TypeID, nil
+}
+
+// `bookView` returns book `book_id`, with its available items
+// counted, as the subquery in `SQLStore` does.
+func (d *memData) bookView(book_id int) Book {
+	b := d.books[book_id-1]
+	available_count := 0
+	for _, it := range d.items {
+		if it.BookID == book_id && it.Status == ItemAvailable {
+			available_count++
+		}
+	}
+
+	orDefault := func(v *string, def string) string {
+		if v == nil {
+			return def
+		}
+		return *v
+	}
+	isbn13 := orDefault(b.ISBN, "")
+	if !isCanonicalISBN(isbn13) {
+		isbn13 = ""
+	}
+
+	return Book{
+		BookID:         book_id,
+		Title:          orDefault(b.Title, "(no title)"),
+		Author:         orDefault(b.Author, "(no author)"),
+		ISBN:           orDefault(b.ISBNDisplay, orDefault(b.ISBN, "(no isbn)")),
+		ISBN13:         isbn13,
+		AvailableCount: available_count,
+		Description:    orDefault(b.Description, "(no description)"),
+		Comment:        orDefault(b.Comment, "(no comment)"),
+	}
+}
+
+func (d *memData) book(book_id int) (*memBook, bool) {
+	if book_id < 1 || book_id > len(d.books) {
+		return nil, false
+	}
+	return &d.books[book_id-1], true
+}
+
+func (s *MemoryStore) BookByID(book_id int) (Book, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.book(book_id); !ok {
+		return Book{}, ErrBookNotFound
+	}
+	return d.bookView(book_id), nil
+}
+
+func (s *MemoryStore) BookByISBN(isbn string) (Book, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for i, b := range d.books {
+		if b.ISBN != nil && *b.ISBN == isbn {
+			return d.bookView(i + 1), nil
+		}
+	}
+	return Book{}, ErrBookNotFound
+}
+
+func (s *MemoryStore) ListBooks() ([]Book, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []Book{}
+	for i := range d.books {
+		list = append(list, d.bookView(i+1))
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) QueryBooks(q *BookQuery, p Page) ([]Book, error) {
+	defer s.lock()()
+	d := *s.data
+
+	all := []Book{}
+	for i := range d.books {
+		if q == nil || d.matchBook(q, i+1) {
+			all = append(all, d.bookView(i+1))
+		}
+	}
+
+	rows, err := pageRows(len(all), p, func(i int) (interface{}, int) {
+		return bookSortKey(all[i], p.Sort), all[i].BookID
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := []Book{}
+	for _, i := range rows {
+		list = append(list, all[i])
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) CountBooks(q *BookQuery) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	count := 0
+	for i := range d.books {
+		if q == nil || d.matchBook(q, i+1) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// `pageRows` sorts `n` rows as `SQLStore` does, and returns indexes of
+// those in page `p`. `row` returns the sort key (see `Cursor.Key`) and
+// ID of a row.
+func pageRows(n int, p Page, row func(i int) (interface{}, int)) ([]int, error) {
+	compare := func(key1 interface{}, id1 int, key2 interface{}, id2 int) bool {
+		c := 0
+		switch v := key1.(type) {
+		case string:
+			c = strings.Compare(v, key2.(string))
+		case time.Time:
+			if v.Before(key2.(time.Time)) {
+				c = -1
+			} else if v.After(key2.(time.Time)) {
+				c = 1
+			}
+		}
+		if c == 0 {
+			c = id1 - id2
+		}
+		if p.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	rows := make([]int, n)
+	for i := range rows {
+		rows[i] = i
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		key1, id1 := row(rows[i])
+		key2, id2 := row(rows[j])
+		return compare(key1, id1, key2, id2)
+	})
+
+	if p.After != nil {
+		after, err := p.After.Key()
+		if err != nil {
+			return nil, err
+		}
+		rows = rows[sort.Search(len(rows), func(i int) bool {
+			key, id := row(rows[i])
+			return compare(after, p.After.ID, key, id)
+		}):]
+	}
+	if p.Size > 0 && p.Size < len(rows) {
+		rows = rows[:p.Size]
+	}
+	return rows, nil
+}
+
+// `matchBook` evaluates `q` on `book_id` as `SQLStore` does.
+func (d *memData) matchBook(q *BookQuery, book_id int) bool {
+	switch q.Op {
+	case QueryAnd:
+		return d.matchBook(q.Left, book_id) && d.matchBook(q.Right, book_id)
+	case QueryOr:
+		return d.matchBook(q.Left, book_id) || d.matchBook(q.Right, book_id)
+	case QueryNot:
+		return !d.matchBook(q.Left, book_id)
+	}
+
+	b := d.books[book_id-1]
+	text := map[string]*string{
+		"title":       b.Title,
+		"author":      b.Author,
+		"description": b.Description,
+		"comment":     b.Comment,
+	}
+	matchValue := func(v *string) bool {
+		value := ""
+		if v != nil {
+			value = strings.ToLower(*v)
+		}
+		if q.Cmp == "=" {
+			return value == strings.ToLower(q.Text)
+		}
+		return strings.Contains(value, strings.ToLower(q.Text))
+	}
+	matchText := func(field string) bool {
+		if field != "isbn" {
+			return matchValue(text[field])
+		}
+		if isbn, err := NormalizeISBN(q.Text); err == nil && q.Cmp == "=" {
+			return b.ISBN != nil && *b.ISBN == isbn
+		}
+		return matchValue(b.ISBNDisplay) || matchValue(b.ISBN)
+	}
+
+	switch q.Field {
+	case "book_id":
+		return compareInt(book_id, q.Cmp, q.Number)
+	case "available":
+		return compareInt(d.bookView(book_id).AvailableCount, q.Cmp, q.Number)
+	case "":
+		for _, field := range queryTextFields {
+			if matchText(field) {
+				return true
+			}
+		}
+		return false
+	default:
+		return matchText(q.Field)
+	}
+}
+
+func compareInt(a int, cmp string, b int) bool {
+	switch cmp {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	default:
+		return a == b
+	}
+}
+
+func (s *MemoryStore) InsertBook() (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	d.books = append(d.books, memBook{})
+	return len(d.books), nil
+}
+
+func (s *MemoryStore) ModifyBook(book_id int, info BookInfo) error {
+	defer s.lock()()
+	d := *s.data
+
+	b, ok := d.book(book_id)
+	if !ok {
+		return ErrInvalidBookID
+	}
+
+	if info.ISBN != nil && *info.ISBN != "" {
+		for i, e := range d.books {
+			if i+1 != book_id && e.ISBN != nil && *e.ISBN == *info.ISBN {
+				return errDuplicateKey
+			}
+		}
+	}
+
+	copyString := func(v *string) *string {
+		c := *v
+		return &c
+	}
+	if info.Author != nil {
+		b.Author = copyString(info.Author)
+	}
+	if info.Comment != nil {
+		b.Comment = copyString(info.Comment)
+	}
+	if info.Description != nil {
+		b.Description = copyString(info.Description)
+	}
+	if info.ISBN != nil && *info.ISBN == "" {
+		b.ISBN, b.ISBNDisplay = nil, nil
+	} else if info.ISBN != nil {
+		b.ISBN = copyString(info.ISBN)
+		b.ISBNDisplay = b.ISBN
+		if info.ISBNDisplay != nil {
+			b.ISBNDisplay = copyString(info.ISBNDisplay)
+		}
+	}
+	if info.Title != nil {
+		b.Title = copyString(info.Title)
+	}
+
+	return nil
+}
+
+func (d *memData) item(item_id int) (*Item, bool) {
+	if item_id < 1 || item_id > len(d.items) {
+		return nil, false
+	}
+	return &d.items[item_id-1], true
+}
+
+func (s *MemoryStore) ItemByID(item_id int) (Item, error) {
+	defer s.lock()()
+	d := *s.data
+
+	it, ok := d.item(item_id)
+	if !ok {
+		return Item{}, ErrInvalidItemID
+	}
+	return *it, nil
+}
+
+func (s *MemoryStore) ItemByBarcode(barcode string) (Item, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, it := range d.items {
+		if it.Barcode == barcode {
+			return it, nil
+		}
+	}
+	return Item{}, ErrInvalidBarcode
+}
+
+func (s *MemoryStore) InsertItem(it Item) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.book(it.BookID); !ok {
+		return -1, errForeignKey
+	}
+	if _, ok := d.branch(it.BranchID); !ok {
+		return -1, errForeignKey
+	}
+	for _, e := range d.items {
+		if e.Barcode == it.Barcode {
+			return -1, errDuplicateKey
+		}
+	}
+
+	it.ItemID = len(d.items) + 1
+	it.AcquiredAt = truncateDate(it.AcquiredAt)
+	d.items = append(d.items, it)
+	return it.ItemID, nil
+}
+
+func (s *MemoryStore) SetItemStatus(item_id int, status ItemStatus) error {
+	defer s.lock()()
+	d := *s.data
+
+	if it, ok := d.item(item_id); ok {
+		it.Status = status
+	}
+	return nil
+}
+
+func (s *MemoryStore) ChangeItemStatus(item_id int, from, to ItemStatus) error {
+	defer s.lock()()
+	d := *s.data
+
+	it, ok := d.item(item_id)
+	if !ok || it.Status != from {
+		return ErrNoAvailableBook
+	}
+	it.Status = to
+	return nil
+}
+
+func (s *MemoryStore) ListItems(book_id int) ([]Item, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []Item{}
+	for _, it := range d.items {
+		if it.BookID == book_id {
+			list = append(list, it)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) AvailableItem(book_id, branch_id int) (Item, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, it := range d.items {
+		if it.BookID == book_id && it.Status == ItemAvailable &&
+			(branch_id == 0 || it.BranchID == branch_id) {
+			return it, nil
+		}
+	}
+	return Item{}, nil
+}
+
+func (s *MemoryStore) CountCopies(book_id int) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	cnt := 0
+	for _, it := range d.items {
+		if it.BookID == book_id && it.Status != ItemLost && it.Status != ItemWithdrawn {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (s *MemoryStore) BranchCounts(book_id int) ([]BranchCount, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []BranchCount{}
+	for _, b := range d.branches {
+		has_copy := false
+		c := BranchCount{BranchID: b.BranchID, Name: b.Name}
+		for _, it := range d.items {
+			if it.BookID == book_id && it.BranchID == b.BranchID {
+				has_copy = true
+				if it.Status == ItemAvailable {
+					c.AvailableCount++
+				}
+			}
+		}
+		if has_copy {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+func (d *memData) branch(branch_id int) (Branch, bool) {
+	if branch_id < 1 || branch_id > len(d.branches) {
+		return Branch{}, false
+	}
+	return d.branches[branch_id-1], true
+}
+
+func (s *MemoryStore) BranchByID(branch_id int) (Branch, error) {
+	defer s.lock()()
+	d := *s.data
+
+	b, ok := d.branch(branch_id)
+	if !ok {
+		return Branch{}, ErrInvalidBranchID
+	}
+	return b, nil
+}
+
+func (s *MemoryStore) ListBranches() ([]Branch, error) {
+	defer s.lock()()
+	d := *s.data
+
+	return append([]Branch{}, d.branches...), nil
+}
+
+func (s *MemoryStore) InsertBranch(name string) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, b := range d.branches {
+		if b.Name == name {
+			return -1, errDuplicateKey
+		}
+	}
+
+	b := Branch{BranchID: len(d.branches) + 1, Name: name}
+	d.branches = append(d.branches, b)
+	return b.BranchID, nil
+}
+
+func (s *MemoryStore) ItemLoan(item_id int) (Record, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, r := range d.records {
+		if r.ItemID == item_id && !r.Returned {
+			return d.recordView(r), nil
+		}
+	}
+	return Record{}, nil
+}
+
+func (s *MemoryStore) BookPolicy(book_id int) (PolicyOverride, error) {
+	defer s.lock()()
+	d := *s.data
+
+	return d.book_policies[book_id], nil
+}
+
+// Pointers in `o` are copied, so that changing them later does not
+// affect the store.
+func (s *MemoryStore) SetBookPolicy(book_id int, o PolicyOverride) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.book(book_id); !ok {
+		return errForeignKey
+	}
+	if o == (PolicyOverride{}) {
+		delete(d.book_policies, book_id)
+		return nil
+	}
+
+	copyInt := func(v *int) *int {
+		if v == nil {
+			return nil
+		}
+		n := *v
+		return &n
+	}
+	d.book_policies[book_id] = PolicyOverride{
+		LoanDays:         copyInt(o.LoanDays),
+		FinalDays:        copyInt(o.FinalDays),
+		ExtendWindowDays: copyInt(o.ExtendWindowDays),
+		ExtendDays:       copyInt(o.ExtendDays),
+	}
+	return nil
+}
+
+func (s *MemoryStore) HoldByID(hold_id int) (Hold, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if hold_id < 1 || hold_id > len(d.holds) || d.holds[hold_id-1].HoldID == 0 {
+		return Hold{}, ErrInvalidHoldID
+	}
+	return d.holds[hold_id-1], nil
+}
+
+func (s *MemoryStore) InsertHold(h Hold) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(h.UserID) {
+		return -1, errForeignKey
+	}
+	if _, ok := d.book(h.BookID); !ok {
+		return -1, errForeignKey
+	}
+	if _, ok := d.item(h.ItemID); h.ItemID != 0 && !ok {
+		return -1, errForeignKey
+	}
+
+	h.HoldID = len(d.holds) + 1
+	h.Position = 0
+	if h.Status != HoldReady {
+		h.ExpiresAt = time.Time{}
+	}
+	d.holds = append(d.holds, h)
+	return h.HoldID, nil
+}
+
+func (s *MemoryStore) UpdateHold(h Hold) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.item(h.ItemID); h.ItemID != 0 && !ok {
+		return errForeignKey
+	}
+	if h.HoldID >= 1 && h.HoldID <= len(d.holds) && d.holds[h.HoldID-1].HoldID != 0 {
+		if h.Status != HoldReady {
+			h.ExpiresAt = time.Time{}
+		}
+		d.holds[h.HoldID-1].Status = h.Status
+		d.holds[h.HoldID-1].ItemID = h.ItemID
+		d.holds[h.HoldID-1].ExpiresAt = h.ExpiresAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) ActiveHold(user_id, book_id int) (Hold, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, h := range d.holds {
+		if h.UserID == user_id && h.BookID == book_id && h.Status.Active() {
+			return h, nil
+		}
+	}
+	return Hold{}, nil
+}
+
+func (s *MemoryStore) NextHold(book_id int) (Hold, error) {
+	defer s.lock()()
+	d := *s.data
+
+	for _, h := range d.holds {
+		if h.BookID == book_id && h.Status == HoldWaiting {
+			return h, nil
+		}
+	}
+	return Hold{}, nil
+}
+
+func (s *MemoryStore) ListHolds(user_id int) ([]Hold, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []Hold{}
+	for _, h := range d.holds {
+		if h.UserID == user_id && h.Status.Active() {
+			list = append(list, h)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) CountHoldsBefore(book_id, hold_id int) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	count := 0
+	for _, h := range d.holds {
+		if h.BookID == book_id && h.Status == HoldWaiting && h.HoldID < hold_id {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) ExpiredHolds(now time.Time) ([]Hold, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []Hold{}
+	for _, h := range d.holds {
+		if h.Status == HoldReady && h.ExpiresAt.Before(now) {
+			list = append(list, h)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) InsertFine(f Fine) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(f.UserID) {
+		return -1, errForeignKey
+	}
+	if _, ok := d.record(f.RecordID); f.RecordID != 0 && !ok {
+		return -1, errForeignKey
+	}
+
+	f.FineID = len(d.fines) + 1
+	d.fines = append(d.fines, f)
+	return f.FineID, nil
+}
+
+func (s *MemoryStore) ListFines(user_id int) ([]Fine, error) {
+	defer s.lock()()
+	d := *s.data
+
+	list := []Fine{}
+	for _, f := range d.fines {
+		if f.UserID == user_id {
+			list = append(list, f)
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) FineBalance(user_id int) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	balance := 0
+	for _, f := range d.fines {
+		if f.UserID != user_id {
+			continue
+		}
+		if f.Kind == FineCharge {
+			balance += f.Amount
+		} else {
+			balance -= f.Amount
+		}
+	}
+	return balance, nil
+}
+
+func (d *memData) record(record_id int) (*Record, bool) {
+	if record_id < 1 || record_id > len(d.records) {
+		return nil, false
+	}
+	return &d.records[record_id-1], true
+}
+
+func (s *MemoryStore) RecordByID(record_id int) (Record, error) {
+	defer s.lock()()
+	d := *s.data
+
+	r, ok := d.record(record_id)
+	if !ok {
+		return Record{}, ErrInvalidRecordID
+	}
+	return d.recordView(*r), nil
+}
+
+// `recordView` fills `Username` with the current name of the user and
+// `Barcode` with that of the item, as the joins in `SQLStore` do.
+func (d *memData) recordView(r Record) Record {
+	r.Username = d.users[r.UserID-1].Name
+	if it, ok := d.item(r.ItemID); ok {
+		r.Barcode = it.Barcode
+	}
+	return r
+}
+
+func (s *MemoryStore) InsertRecord(r Record) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	if !d.hasUser(r.UserID) {
+		return -1, errForeignKey
+	}
+	if _, ok := d.book(r.BookID); !ok {
+		return -1, errForeignKey
+	}
+	if _, ok := d.item(r.ItemID); r.ItemID != 0 && !ok {
+		return -1, errForeignKey
+	}
+	if _, ok := d.branch(r.BorrowBranchID); r.BorrowBranchID != 0 && !ok {
+		return -1, errForeignKey
+	}
+	if _, ok := d.branch(r.ReturnBranchID); r.ReturnBranchID != 0 && !ok {
+		return -1, errForeignKey
+	}
+
+	r.RecordID = len(d.records) + 1
+	r.Username = d.users[r.UserID-1].Name
+	r.BorrowDate = truncateDate(r.BorrowDate)
+	r.DueDate = truncateDate(r.DueDate)
+	r.FinalDate = truncateDate(r.FinalDate)
+	if r.Returned {
+		r.ReturnDate = truncateDate(r.ReturnDate)
+	} else {
+		r.ReturnDate = time.Time{}
+	}
+
+	d.records = append(d.records, r)
+	return r.RecordID, nil
+}
+
+func (s *MemoryStore) SetDeadline(record_id int, due time.Time) error {
+	defer s.lock()()
+	d := *s.data
+
+	if r, ok := d.record(record_id); ok {
+		r.DueDate = truncateDate(due)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetReturnDate(record_id int, date time.Time, branch_id int) error {
+	defer s.lock()()
+	d := *s.data
+
+	if _, ok := d.branch(branch_id); branch_id != 0 && !ok {
+		return errForeignKey
+	}
+	if r, ok := d.record(record_id); ok {
+		r.Returned = true
+		r.ReturnDate = truncateDate(date)
+		r.ReturnBranchID = branch_id
+	}
+	return nil
+}
+
+func (s *MemoryStore) CountOverdue(user_id int, now time.Time) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	overdue_count := 0
+	for _, r := range d.records {
+		if r.UserID == user_id && !r.Returned && r.DueDate.Before(now) {
+			overdue_count++
+		}
+	}
+	return overdue_count, nil
+}
+
+func (s *MemoryStore) CountLoans(user_id int) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	loan_count := 0
+	for _, r := range d.records {
+		if r.UserID == user_id && !r.Returned {
+			loan_count++
+		}
+	}
+	return loan_count, nil
+}
+
+func (s *MemoryStore) ListRecords(user_id int, filter RecordFilter, p Page) ([]Record, error) {
+	defer s.lock()()
+	d := *s.data
+
+	all := d.filterRecords(user_id, filter)
+	if p.Sort == "" {
+		p.Sort, p.Desc = "borrow_date", true
+	}
+	rows, err := pageRows(len(all), p, func(i int) (interface{}, int) {
+		return recordSortKey(all[i], p.Sort), all[i].RecordID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Record{}
+	for _, i := range rows {
+		list = append(list, all[i])
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) CountRecords(user_id int, filter RecordFilter) (int, error) {
+	defer s.lock()()
+	d := *s.data
+
+	return len(d.filterRecords(user_id, filter)), nil
+}
+
+func (d *memData) filterRecords(user_id int, filter RecordFilter) []Record {
+	list := []Record{}
+	for _, r := range d.records {
+		if r.UserID != user_id ||
+			(filter.Returned && !r.Returned) ||
+			(filter.NotReturned && r.Returned) ||
+			(!filter.DueBefore.IsZero() && !r.DueDate.Before(filter.DueBefore)) {
+			continue
+		}
+		list = append(list, d.recordView(r))
+	}
+	return list
+}
@@ -0,0 +1,1626 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// `querier` is implemented by both `*sql.DB` and `*sql.Tx`.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// `SQLStore` implements `Store` on top of a SQL database. Queries are
+// written in MySQL flavor and translated by `dialect`.
+type SQLStore struct {
+	db      *sql.DB
+	q       querier
+	tx      *sql.Tx
+	dialect Dialect
+}
+
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{db: db, q: db, dialect: dialect}
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.q.Exec(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.q.Query(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.q.QueryRow(s.dialect.Rebind(query), args...)
+}
+
+// `insert` executes INSERT statement `query` and returns the value of
+// auto-increment column `id_column` of the new row.
+func (s *SQLStore) insert(query string, id_column string, args ...interface{}) (int, error) {
+	if s.dialect.Returning() {
+		var id int
+		err := s.queryRow(query+" RETURNING "+id_column, args...).Scan(&id)
+		if err != nil {
+			return -1, err
+		}
+		return id, nil
+	}
+
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+
+	return int(id), nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) Atomic(fn func(s Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(&SQLStore{db: s.db, q: tx, tx: tx, dialect: s.dialect})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+var selectUser = `
+SELECT
+	user_id, type_id, name, token, must_change, active, expires_at
+FROM User
+WHERE `
+
+func scanUser(row RowScanner) (User, error) {
+	var expires_at sql.NullTime
+	var u User
+	err := row.Scan(
+		&u.UserID, &u.TypeID, &u.Name, &u.Token, &u.MustChange,
+		&u.Active, &expires_at,
+	)
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidUser
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if expires_at.Valid {
+		u.ExpiresAt = expires_at.Time.UTC()
+	}
+	return u, nil
+}
+
+// `permissions` returns permissions in link table `table`, i.e.
+// RolePermission or ApiKeyPermission, of row `id` in `id_column`.
+func (s *SQLStore) permissions(table, id_column string, id int) (PermissionSet, error) {
+	rows, err := s.query(
+		"SELECT perm_name FROM "+table+" WHERE "+id_column+" = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var perms []Permission
+	for rows.Next() {
+		var p Permission
+		err = rows.Scan(&p)
+		if err != nil {
+			return nil, err
+		}
+
+		perms = append(perms, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPermissionSet(perms...), nil
+}
+
+// `setPermissions` replaces permissions of row `id` in link table
+// `table`. It must be called in `Atomic`.
+func (s *SQLStore) setPermissions(table, id_column string, id int, perms PermissionSet) error {
+	_, err := s.exec("DELETE FROM "+table+" WHERE "+id_column+" = ?", id)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range perms {
+		_, err = s.exec(
+			"INSERT INTO "+table+" ("+id_column+", perm_name) VALUES (?, ?)",
+			id, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) user(row RowScanner) (User, error) {
+	u, err := scanUser(row)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.Perm, err = s.permissions("RolePermission", "type_id", u.TypeID)
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *SQLStore) UserByID(user_id int) (User, error) {
+	return s.user(s.queryRow(selectUser+"user_id = ?", user_id))
+}
+
+func (s *SQLStore) UserByName(name string) (User, error) {
+	return s.user(s.queryRow(selectUser+"name = ?", name))
+}
+
+func (s *SQLStore) InsertUser(type_id int, name string, token string) (int, error) {
+	return s.insert(
+		"INSERT INTO User (type_id, name, token) VALUES (?, ?, ?)", "user_id",
+		type_id, name, token,
+	)
+}
+
+func (s *SQLStore) SetUserToken(user_id int, token string, must_change bool) error {
+	_, err := s.exec(
+		"UPDATE User SET token = ?, must_change = ? WHERE user_id = ?",
+		token, must_change, user_id)
+	return err
+}
+
+var selectUserType = `
+SELECT type_id, type_name
+FROM UserType
+WHERE `
+
+func scanUserType(row RowScanner) (UserType, error) {
+	var t UserType
+	err := row.Scan(&t.TypeID, &t.TypeName)
+	if err == sql.ErrNoRows {
+		return UserType{}, ErrInvalidUserType
+	}
+	if err != nil {
+		return UserType{}, err
+	}
+
+	return t, nil
+}
+
+func (s *SQLStore) userType(row RowScanner) (UserType, error) {
+	t, err := scanUserType(row)
+	if err != nil {
+		return UserType{}, err
+	}
+
+	t.Perm, err = s.permissions("RolePermission", "type_id", t.TypeID)
+	if err != nil {
+		return UserType{}, err
+	}
+	return t, nil
+}
+
+func (s *SQLStore) UserTypeByName(type_name string) (UserType, error) {
+	return s.userType(s.queryRow(selectUserType+"type_name = ?", type_name))
+}
+
+func (s *SQLStore) UserTypeByID(type_id int) (UserType, error) {
+	return s.userType(s.queryRow(selectUserType+"type_id = ?", type_id))
+}
+
+func (s *SQLStore) ListUserTypes() ([]UserType, error) {
+	rows, err := s.query(selectUserType + "1 = 1 ORDER BY type_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []UserType{}
+	for rows.Next() {
+		t, err := scanUserType(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Permissions are loaded after `rows` is closed, since some drivers
+	// cannot run another query on a transaction with pending rows.
+	for i := range list {
+		list[i].Perm, err = s.permissions("RolePermission", "type_id", list[i].TypeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) UpdateUserType(t UserType) error {
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		result, err := s.exec(
+			"UPDATE UserType SET type_name = ? WHERE type_id = ?",
+			t.TypeName, t.TypeID)
+		if err != nil {
+			return err
+		}
+
+		// Some drivers count only changed rows
+		cnt, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			_, err = s.UserTypeByID(t.TypeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.setPermissions("RolePermission", "type_id", t.TypeID, t.Perm)
+	})
+}
+
+func (s *SQLStore) DeleteUserType(type_id int) error {
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		_, err := s.exec("DELETE FROM RolePermission WHERE type_id = ?", type_id)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("DELETE FROM CirculationPolicy WHERE type_id = ?", type_id)
+		if err != nil {
+			return err
+		}
+
+		result, err := s.exec("DELETE FROM UserType WHERE type_id = ?", type_id)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			return ErrInvalidUserType
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLStore) CountUsersOfType(type_id int) (int, error) {
+	var cnt int
+	err := s.queryRow("SELECT COUNT(*) FROM User WHERE type_id = ?", type_id).Scan(&cnt)
+	if err != nil {
+		return -1, err
+	}
+	return cnt, nil
+}
+
+func (s *SQLStore) TypePolicy(type_id int) (Policy, error) {
+	var p Policy
+	err := s.queryRow(`
+		SELECT
+			loan_days, final_days, extend_window_days, extend_days,
+			max_overdue, max_loans, pickup_days,
+			fine_rate, fine_cap, max_balance
+		FROM CirculationPolicy
+		WHERE type_id = ?`, type_id).
+		Scan(
+			&p.LoanDays, &p.FinalDays, &p.ExtendWindowDays, &p.ExtendDays,
+			&p.MaxOverdue, &p.MaxLoans, &p.PickupDays,
+			&p.FineRate, &p.FineCap, &p.MaxBalance,
+		)
+	if err == sql.ErrNoRows {
+		return DefaultPolicy, nil
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+
+	return p, nil
+}
+
+// `SetTypePolicy` replaces the row in one transaction, same as
+// `SetAuthFailure`.
+func (s *SQLStore) SetTypePolicy(type_id int, p Policy) error {
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		_, err := s.exec("DELETE FROM CirculationPolicy WHERE type_id = ?", type_id)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.exec(`
+			INSERT INTO CirculationPolicy
+				(type_id, loan_days, final_days, extend_window_days,
+				 extend_days, max_overdue, max_loans, pickup_days,
+				 fine_rate, fine_cap, max_balance)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			type_id, p.LoanDays, p.FinalDays, p.ExtendWindowDays,
+			p.ExtendDays, p.MaxOverdue, p.MaxLoans, p.PickupDays,
+			p.FineRate, p.FineCap, p.MaxBalance)
+		return err
+	})
+}
+
+func (s *SQLStore) InsertUserType(t UserType) (int, error) {
+	type_id := -1
+	err := s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		var err error
+		type_id, err = s.insert(
+			"INSERT INTO UserType (type_name) VALUES (?)", "type_id", t.TypeName)
+		if err != nil {
+			return err
+		}
+
+		return s.setPermissions("RolePermission", "type_id", type_id, t.Perm)
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return type_id, nil
+}
+
+func (s *SQLStore) SetUserName(user_id int, name string) error {
+	_, err := s.exec("UPDATE User SET name = ? WHERE user_id = ?", name, user_id)
+	return err
+}
+
+func (s *SQLStore) SetUserType(user_id int, type_id int) error {
+	_, err := s.exec("UPDATE User SET type_id = ? WHERE user_id = ?", type_id, user_id)
+	return err
+}
+
+func (s *SQLStore) SetUserStatus(user_id int, active bool, expires_at time.Time) error {
+	var expires sql.NullTime
+	if !expires_at.IsZero() {
+		expires = sql.NullTime{Time: expires_at.UTC(), Valid: true}
+	}
+
+	_, err := s.exec(
+		"UPDATE User SET active = ?, expires_at = ? WHERE user_id = ?",
+		active, expires, user_id)
+	return err
+}
+
+func (s *SQLStore) DeleteUser(user_id int) error {
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		_, err := s.exec("DELETE FROM Session WHERE user_id = ?", user_id)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec(`
+			DELETE FROM ApiKeyPermission
+			WHERE key_id IN (SELECT key_id FROM ApiKey WHERE user_id = ?)`,
+			user_id)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("DELETE FROM ApiKey WHERE user_id = ?", user_id)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("DELETE FROM Hold WHERE user_id = ?", user_id)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("DELETE FROM AuthFailure WHERE subject = ?", userSubject(user_id))
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("DELETE FROM User WHERE user_id = ?", user_id)
+		return err
+	})
+}
+
+func (s *SQLStore) SessionByTokenHash(token_hash string) (Session, error) {
+	var session Session
+	err := s.queryRow(`
+		SELECT
+			session_id, user_id, token_hash,
+			created_at, expires_at, revoked
+		FROM Session
+		WHERE token_hash = ?`, token_hash).
+		Scan(
+			&session.SessionID, &session.UserID, &session.TokenHash,
+			&session.CreatedAt, &session.ExpiresAt, &session.Revoked,
+		)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrInvalidSession
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	return session, nil
+}
+
+func (s *SQLStore) InsertSession(session Session) (int, error) {
+	return s.insert(`
+		INSERT INTO Session
+			(user_id, token_hash, created_at, expires_at, revoked)
+		VALUES (?, ?, ?, ?, ?)`, "session_id",
+		session.UserID, session.TokenHash,
+		session.CreatedAt.UTC(), session.ExpiresAt.UTC(), session.Revoked)
+}
+
+func (s *SQLStore) RevokeSession(session_id int) error {
+	_, err := s.exec("UPDATE Session SET revoked = ? WHERE session_id = ?", true, session_id)
+	return err
+}
+
+func (s *SQLStore) RevokeUserSessions(user_id int) error {
+	_, err := s.exec("UPDATE Session SET revoked = ? WHERE user_id = ?", true, user_id)
+	return err
+}
+
+func (s *SQLStore) PurgeSessions(now time.Time) error {
+	_, err := s.exec("DELETE FROM Session WHERE expires_at < ?", now.UTC())
+	return err
+}
+
+var selectAPIKey = `
+SELECT
+	key_id, user_id, name, key_hash,
+	created_at, last_used, revoked
+FROM ApiKey
+WHERE `
+
+func scanAPIKey(row RowScanner) (APIKey, error) {
+	var last_used sql.NullTime
+	var k APIKey
+	err := row.Scan(
+		&k.KeyID, &k.UserID, &k.Name, &k.KeyHash,
+		&k.CreatedAt, &last_used, &k.Revoked,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	k.CreatedAt = k.CreatedAt.UTC()
+	if last_used.Valid {
+		k.Used = true
+		k.LastUsed = last_used.Time.UTC()
+	}
+	return k, nil
+}
+
+func (s *SQLStore) APIKeyByHash(key_hash string) (APIKey, error) {
+	return s.apiKey("key_hash = ?", key_hash)
+}
+
+func (s *SQLStore) APIKeyByID(key_id int) (APIKey, error) {
+	return s.apiKey("key_id = ?", key_id)
+}
+
+// `apiKey` returns the API key matching `cond` with its scope.
+func (s *SQLStore) apiKey(cond string, args ...interface{}) (APIKey, error) {
+	k, err := scanAPIKey(s.queryRow(selectAPIKey+cond, args...))
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	k.Scope, err = s.permissions("ApiKeyPermission", "key_id", k.KeyID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+func (s *SQLStore) InsertAPIKey(k APIKey) (int, error) {
+	key_id := -1
+	err := s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		var err error
+		key_id, err = s.insert(`
+			INSERT INTO ApiKey
+				(user_id, name, key_hash, created_at, revoked)
+			VALUES (?, ?, ?, ?, ?)`, "key_id",
+			k.UserID, k.Name, k.KeyHash, k.CreatedAt.UTC(), k.Revoked)
+		if err != nil {
+			return err
+		}
+
+		return s.setPermissions("ApiKeyPermission", "key_id", key_id, k.Scope)
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return key_id, nil
+}
+
+func (s *SQLStore) ListAPIKeys(user_id int) ([]APIKey, error) {
+	cond := "1 = 1"
+	var args []interface{}
+	if user_id != 0 {
+		cond = "user_id = ?"
+		args = append(args, user_id)
+	}
+
+	rows, err := s.query(selectAPIKey+cond+" ORDER BY key_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, k)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range list {
+		list[i].Scope, err = s.permissions("ApiKeyPermission", "key_id", list[i].KeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) RevokeAPIKey(key_id int) error {
+	result, err := s.exec("UPDATE ApiKey SET revoked = ? WHERE key_id = ?", true, key_id)
+	if err != nil {
+		return err
+	}
+
+	cnt, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return ErrInvalidAPIKey
+	}
+
+	return nil
+}
+
+func (s *SQLStore) RevokeUserAPIKeys(user_id int) error {
+	_, err := s.exec("UPDATE ApiKey SET revoked = ? WHERE user_id = ?", true, user_id)
+	return err
+}
+
+func (s *SQLStore) TouchAPIKey(key_id int, now time.Time) error {
+	_, err := s.exec("UPDATE ApiKey SET last_used = ? WHERE key_id = ?", now.UTC(), key_id)
+	return err
+}
+
+func (s *SQLStore) AuthFailure(subject string) (AuthFailure, error) {
+	var locked_until sql.NullTime
+	f := AuthFailure{Subject: subject}
+	err := s.queryRow(`
+		SELECT failures, last_failure, locked_until
+		FROM AuthFailure
+		WHERE subject = ?`, subject).
+		Scan(&f.Failures, &f.LastFailure, &locked_until)
+	if err == sql.ErrNoRows {
+		return AuthFailure{Subject: subject}, nil
+	}
+	if err != nil {
+		return AuthFailure{}, err
+	}
+
+	f.LastFailure = f.LastFailure.UTC()
+	if locked_until.Valid {
+		f.LockedUntil = locked_until.Time.UTC()
+	}
+	return f, nil
+}
+
+// `SetAuthFailure` replaces the row in one transaction, which works
+// the same in all dialects.
+func (s *SQLStore) SetAuthFailure(f AuthFailure) error {
+	var locked_until sql.NullTime
+	if !f.LockedUntil.IsZero() {
+		locked_until = sql.NullTime{Time: f.LockedUntil.UTC(), Valid: true}
+	}
+
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		_, err := s.exec("DELETE FROM AuthFailure WHERE subject = ?", f.Subject)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.exec(`
+			INSERT INTO AuthFailure
+				(subject, failures, last_failure, locked_until)
+			VALUES (?, ?, ?, ?)`,
+			f.Subject, f.Failures, f.LastFailure.UTC(), locked_until)
+		return err
+	})
+}
+
+func (s *SQLStore) DeleteAuthFailure(subject string) error {
+	_, err := s.exec("DELETE FROM AuthFailure WHERE subject = ?", subject)
+	return err
+}
+
+var selectBook = `
+SELECT
+	book_id,
+	COALESCE(title, '(no title)'),
+	COALESCE(author, '(no author)'),
+	COALESCE(isbn_display, isbn, '(no isbn)'),
+	COALESCE(isbn, ''),
+	(SELECT COUNT(*) FROM Item
+		WHERE Item.book_id = Book.book_id AND status = 'available'),
+	COALESCE(description, '(no description)'),
+	COALESCE(comment, '(no comment)')
+FROM Book
+WHERE `
+
+func scanBook(row RowScanner) (Book, error) {
+	var book Book
+	err := row.Scan(
+		&book.BookID, &book.Title,
+		&book.Author, &book.ISBN, &book.ISBN13,
+		&book.AvailableCount,
+		&book.Description,
+		&book.Comment,
+	)
+	if err == sql.ErrNoRows {
+		return Book{}, ErrBookNotFound
+	}
+	if err != nil {
+		return Book{}, err
+	}
+
+	if !isCanonicalISBN(book.ISBN13) {
+		book.ISBN13 = ""
+	}
+	return book, nil
+}
+
+func (s *SQLStore) BookByID(book_id int) (Book, error) {
+	return scanBook(s.queryRow(selectBook+"book_id = ?", book_id))
+}
+
+func (s *SQLStore) BookByISBN(isbn string) (Book, error) {
+	return scanBook(s.queryRow(selectBook+"isbn = ?", isbn))
+}
+
+func (s *SQLStore) ListBooks() ([]Book, error) {
+	rows, err := s.query(selectBook + "1 = 1 ORDER BY book_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, book)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// `bookSortColumns` are the expressions of book sort keys, which are
+// the same as shown.
+var bookSortColumns = map[string]string{
+	"book_id": "Book.book_id",
+	"title":   "COALESCE(title, '(no title)')",
+	"author":  "COALESCE(author, '(no author)')",
+	"isbn":    "COALESCE(isbn_display, isbn, '(no isbn)')",
+}
+
+func (s *SQLStore) QueryBooks(q *BookQuery, p Page) ([]Book, error) {
+	var args []interface{}
+	where := "1 = 1"
+	if q != nil {
+		where = s.bookCondition(q, &args)
+	}
+	order, err := pageClause(p, bookSortColumns, "book_id", &where, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(selectBook+where+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, book)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) CountBooks(q *BookQuery) (int, error) {
+	var args []interface{}
+	where := "1 = 1"
+	if q != nil {
+		where = s.bookCondition(q, &args)
+	}
+
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM Book WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+// `pageClause` adds the condition of rows after `p.After` to `where`,
+// and returns the ORDER BY and LIMIT clauses of `p`. `columns` are the
+// expressions of sort keys, and `id` is the key of the ID column.
+func pageClause(p Page, columns map[string]string, id string, where *string, args *[]interface{}) (string, error) {
+	id_column := columns[id]
+	column := id_column
+	if p.Sort != "" && p.Sort != id {
+		column = columns[p.Sort]
+	}
+	if column == "" {
+		return "", ErrInvalidSortKey
+	}
+	dir, cmp := " ASC", " > "
+	if p.Desc {
+		dir, cmp = " DESC", " < "
+	}
+
+	if p.After != nil {
+		key, err := p.After.Key()
+		if err != nil {
+			return "", err
+		}
+		if key == nil {
+			*where = "(" + *where + ") AND " + id_column + cmp + "?"
+			*args = append(*args, p.After.ID)
+		} else {
+			if t, ok := key.(time.Time); ok {
+				key = truncateDate(t)
+			}
+			*where = "(" + *where + ") AND (" + column + cmp + "? OR (" +
+				column + " = ? AND " + id_column + cmp + "?))"
+			*args = append(*args, key, key, p.After.ID)
+		}
+	}
+
+	order := " ORDER BY " + column + dir
+	if column != id_column {
+		order += ", " + id_column + dir
+	}
+	if p.Size > 0 {
+		order += " LIMIT ?"
+		*args = append(*args, p.Size)
+	}
+	return order, nil
+}
+
+// `bookCondition` compiles `q` into a condition on Book, and appends
+// its parameters to `args`. Field names are checked by
+// `ParseBookQuery`, and values are always passed as parameters.
+func (s *SQLStore) bookCondition(q *BookQuery, args *[]interface{}) string {
+	switch q.Op {
+	case QueryAnd:
+		return "(" + s.bookCondition(q.Left, args) + " AND " + s.bookCondition(q.Right, args) + ")"
+	case QueryOr:
+		return "(" + s.bookCondition(q.Left, args) + " OR " + s.bookCondition(q.Right, args) + ")"
+	case QueryNot:
+		return "(NOT " + s.bookCondition(q.Left, args) + ")"
+	}
+
+	switch q.Field {
+	case "book_id":
+		*args = append(*args, q.Number)
+		return "Book.book_id " + q.Cmp + " ?"
+	case "available":
+		*args = append(*args, q.Number)
+		return `(SELECT COUNT(*) FROM Item
+			WHERE Item.book_id = Book.book_id AND status = 'available') ` + q.Cmp + " ?"
+	case "":
+		var conds []string
+		for _, field := range queryTextFields {
+			conds = append(conds, s.fieldCondition(field, q.Cmp, q.Text, args))
+		}
+		return "(" + strings.Join(conds, " OR ") + ")"
+	default:
+		return s.fieldCondition(q.Field, q.Cmp, q.Text, args)
+	}
+}
+
+// `fieldCondition` matches text field `field`. ISBNs match as shown, or
+// as stored, and equal ISBNs in any formatting.
+func (s *SQLStore) fieldCondition(field, cmp, text string, args *[]interface{}) string {
+	if field != "isbn" {
+		return s.textCondition(field, cmp, text, args)
+	}
+
+	if isbn, err := NormalizeISBN(text); err == nil && cmp == "=" {
+		*args = append(*args, isbn)
+		return "isbn = ?"
+	}
+	return "(" + s.textCondition("isbn_display", cmp, text, args) + " OR " +
+		s.textCondition("isbn", cmp, text, args) + ")"
+}
+
+// `textCondition` matches `column` containing `text`, or equal to it
+// if `cmp` is "=", ignoring case. NULL is taken as an empty string.
+func (s *SQLStore) textCondition(column, cmp, text string, args *[]interface{}) string {
+	if cmp == "=" {
+		*args = append(*args, strings.ToLower(text))
+		return "LOWER(COALESCE(" + column + ", '')) = ?"
+	}
+
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(text)
+	*args = append(*args, "%"+escaped+"%")
+	return "COALESCE(" + column + ", '') " + s.dialect.ILike() + " ? ESCAPE '!'"
+}
+
+func (s *SQLStore) InsertBook() (int, error) {
+	return s.insert("INSERT INTO Book (title) VALUES (NULL)", "book_id")
+}
+
+func (s *SQLStore) ModifyBook(book_id int, info BookInfo) error {
+	var tmp int
+	err := s.queryRow("SELECT book_id FROM Book WHERE book_id = ?", book_id).
+		Scan(&tmp)
+	if err == sql.ErrNoRows {
+		return ErrInvalidBookID
+	}
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	buf.WriteString("UPDATE Book SET ")
+
+	var args []interface{}
+	if info.Author != nil {
+		buf.WriteString("author=?,")
+		args = append(args, info.Author)
+	}
+	if info.Comment != nil {
+		buf.WriteString("comment=?,")
+		args = append(args, info.Comment)
+	}
+	if info.Description != nil {
+		buf.WriteString("description=?,")
+		args = append(args, info.Description)
+	}
+	if info.ISBN != nil {
+		isbn, display := info.ISBN, info.ISBNDisplay
+		if display == nil {
+			display = isbn
+		}
+		if *isbn == "" {
+			isbn, display = nil, nil
+		}
+		buf.WriteString("isbn=?,isbn_display=?,")
+		args = append(args, isbn, display)
+	}
+	if info.Title != nil {
+		buf.WriteString("title=?,")
+		args = append(args, info.Title)
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	query := strings.TrimSuffix(buf.String(), ",") + " WHERE book_id=?"
+	args = append(args, book_id)
+
+	_, err = s.exec(query, args...)
+	return err
+}
+
+var selectItem = `
+SELECT item_id, book_id, branch_id, barcode, status, acquired_at
+FROM Item
+WHERE `
+
+func scanItem(row RowScanner) (Item, error) {
+	var it Item
+	err := row.Scan(&it.ItemID, &it.BookID, &it.BranchID, &it.Barcode, &it.Status, &it.AcquiredAt)
+	if err != nil {
+		return Item{}, err
+	}
+
+	it.AcquiredAt = it.AcquiredAt.UTC()
+	return it, nil
+}
+
+func (s *SQLStore) ItemByID(item_id int) (Item, error) {
+	it, err := scanItem(s.queryRow(selectItem+"item_id = ?", item_id))
+	if err == sql.ErrNoRows {
+		return Item{}, ErrInvalidItemID
+	}
+	return it, err
+}
+
+func (s *SQLStore) ItemByBarcode(barcode string) (Item, error) {
+	it, err := scanItem(s.queryRow(selectItem+"barcode = ?", barcode))
+	if err == sql.ErrNoRows {
+		return Item{}, ErrInvalidBarcode
+	}
+	return it, err
+}
+
+func (s *SQLStore) InsertItem(it Item) (int, error) {
+	return s.insert(`
+		INSERT INTO Item
+			(book_id, branch_id, barcode, status, acquired_at)
+		VALUES (?, ?, ?, ?, ?)`, "item_id",
+		it.BookID, it.BranchID, it.Barcode, it.Status, truncateDate(it.AcquiredAt))
+}
+
+func (s *SQLStore) SetItemStatus(item_id int, status ItemStatus) error {
+	_, err := s.exec("UPDATE Item SET status = ? WHERE item_id = ?", status, item_id)
+	return err
+}
+
+func (s *SQLStore) ChangeItemStatus(item_id int, from, to ItemStatus) error {
+	result, err := s.exec("UPDATE Item SET status = ? WHERE item_id = ? AND status = ?", to, item_id, from)
+	if err != nil {
+		return err
+	}
+
+	cnt, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return ErrNoAvailableBook
+	}
+
+	return nil
+}
+
+func (s *SQLStore) ListItems(book_id int) ([]Item, error) {
+	rows, err := s.query(selectItem+"book_id = ? ORDER BY item_id", book_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Item{}
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, it)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) AvailableItem(book_id, branch_id int) (Item, error) {
+	cond := "book_id = ? AND status = ?"
+	args := []interface{}{book_id, ItemAvailable}
+	if branch_id != 0 {
+		cond += " AND branch_id = ?"
+		args = append(args, branch_id)
+	}
+
+	it, err := scanItem(s.queryRow(selectItem+cond+" ORDER BY item_id LIMIT 1", args...))
+	if err == sql.ErrNoRows {
+		return Item{}, nil
+	}
+	return it, err
+}
+
+func (s *SQLStore) CountCopies(book_id int) (int, error) {
+	var cnt int
+	err := s.queryRow("SELECT COUNT(*) FROM Item WHERE book_id = ? AND status NOT IN (?, ?)",
+		book_id, ItemLost, ItemWithdrawn).Scan(&cnt)
+	return cnt, err
+}
+
+func (s *SQLStore) BranchCounts(book_id int) ([]BranchCount, error) {
+	rows, err := s.query(`
+		SELECT
+			Branch.branch_id, name,
+			SUM(CASE WHEN status = ? THEN 1 ELSE 0 END)
+		FROM Item JOIN Branch ON Branch.branch_id = Item.branch_id
+		WHERE book_id = ?
+		GROUP BY Branch.branch_id, name
+		ORDER BY Branch.branch_id`, ItemAvailable, book_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []BranchCount{}
+	for rows.Next() {
+		var c BranchCount
+		err := rows.Scan(&c.BranchID, &c.Name, &c.AvailableCount)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, c)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) BranchByID(branch_id int) (Branch, error) {
+	b := Branch{BranchID: branch_id}
+	err := s.queryRow("SELECT name FROM Branch WHERE branch_id = ?", branch_id).
+		Scan(&b.Name)
+	if err == sql.ErrNoRows {
+		return Branch{}, ErrInvalidBranchID
+	}
+	if err != nil {
+		return Branch{}, err
+	}
+
+	return b, nil
+}
+
+func (s *SQLStore) ListBranches() ([]Branch, error) {
+	rows, err := s.query("SELECT branch_id, name FROM Branch ORDER BY branch_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Branch{}
+	for rows.Next() {
+		var b Branch
+		err := rows.Scan(&b.BranchID, &b.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, b)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) InsertBranch(name string) (int, error) {
+	return s.insert("INSERT INTO Branch (name) VALUES (?)", "branch_id", name)
+}
+
+func (s *SQLStore) ItemLoan(item_id int) (Record, error) {
+	r, err := scanRecord(s.queryRow(
+		selectRecord+"Record.item_id = ? AND return_date IS NULL", item_id))
+	if err == sql.ErrNoRows {
+		return Record{}, nil
+	}
+	return r, err
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+func (s *SQLStore) BookPolicy(book_id int) (PolicyOverride, error) {
+	var loan, final, window, extend sql.NullInt64
+	err := s.queryRow(`
+		SELECT loan_days, final_days, extend_window_days, extend_days
+		FROM BookPolicy
+		WHERE book_id = ?`, book_id).
+		Scan(&loan, &final, &window, &extend)
+	if err == sql.ErrNoRows {
+		return PolicyOverride{}, nil
+	}
+	if err != nil {
+		return PolicyOverride{}, err
+	}
+
+	return PolicyOverride{
+		LoanDays:         nullIntPtr(loan),
+		FinalDays:        nullIntPtr(final),
+		ExtendWindowDays: nullIntPtr(window),
+		ExtendDays:       nullIntPtr(extend),
+	}, nil
+}
+
+func (s *SQLStore) SetBookPolicy(book_id int, o PolicyOverride) error {
+	return s.Atomic(func(db Store) error {
+		s := db.(*SQLStore)
+		_, err := s.exec("DELETE FROM BookPolicy WHERE book_id = ?", book_id)
+		if err != nil || o == (PolicyOverride{}) {
+			return err
+		}
+
+		_, err = s.exec(`
+			INSERT INTO BookPolicy
+				(book_id, loan_days, final_days, extend_window_days, extend_days)
+			VALUES (?, ?, ?, ?, ?)`,
+			book_id, o.LoanDays, o.FinalDays, o.ExtendWindowDays, o.ExtendDays)
+		return err
+	})
+}
+
+var selectHold = `
+SELECT
+	hold_id, user_id, book_id, status, item_id,
+	created_at, expires_at
+FROM Hold
+WHERE `
+
+func scanHold(row RowScanner) (Hold, error) {
+	var item_id sql.NullInt64
+	var expires_at sql.NullTime
+	var h Hold
+	err := row.Scan(
+		&h.HoldID, &h.UserID, &h.BookID, &h.Status, &item_id,
+		&h.CreatedAt, &expires_at,
+	)
+	if err != nil {
+		return Hold{}, err
+	}
+
+	h.ItemID = int(item_id.Int64)
+
+	h.CreatedAt = h.CreatedAt.UTC()
+	if expires_at.Valid {
+		h.ExpiresAt = expires_at.Time.UTC()
+	}
+	return h, nil
+}
+
+// `queryHolds` runs `selectHold` with `cond` and collects the rows.
+func (s *SQLStore) queryHolds(cond string, args ...interface{}) ([]Hold, error) {
+	rows, err := s.query(selectHold+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Hold{}
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, h)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) HoldByID(hold_id int) (Hold, error) {
+	h, err := scanHold(s.queryRow(selectHold+"hold_id = ?", hold_id))
+	if err == sql.ErrNoRows {
+		return Hold{}, ErrInvalidHoldID
+	}
+	if err != nil {
+		return Hold{}, err
+	}
+
+	return h, nil
+}
+
+func (s *SQLStore) InsertHold(h Hold) (int, error) {
+	var expires_at sql.NullTime
+	if h.Status == HoldReady {
+		expires_at = sql.NullTime{Time: h.ExpiresAt.UTC(), Valid: true}
+	}
+
+	return s.insert(`
+		INSERT INTO Hold
+			(user_id, book_id, status, item_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, "hold_id",
+		h.UserID, h.BookID, h.Status, nullID(h.ItemID), h.CreatedAt.UTC(), expires_at)
+}
+
+func (s *SQLStore) UpdateHold(h Hold) error {
+	var expires_at sql.NullTime
+	if h.Status == HoldReady {
+		expires_at = sql.NullTime{Time: h.ExpiresAt.UTC(), Valid: true}
+	}
+
+	_, err := s.exec(
+		"UPDATE Hold SET status = ?, item_id = ?, expires_at = ? WHERE hold_id = ?",
+		h.Status, nullID(h.ItemID), expires_at, h.HoldID)
+	return err
+}
+
+func (s *SQLStore) ActiveHold(user_id, book_id int) (Hold, error) {
+	h, err := scanHold(s.queryRow(
+		selectHold+"user_id = ? AND book_id = ? AND status IN (?, ?)",
+		user_id, book_id, HoldWaiting, HoldReady))
+	if err == sql.ErrNoRows {
+		return Hold{}, nil
+	}
+	return h, err
+}
+
+func (s *SQLStore) NextHold(book_id int) (Hold, error) {
+	h, err := scanHold(s.queryRow(
+		selectHold+"book_id = ? AND status = ? ORDER BY hold_id LIMIT 1",
+		book_id, HoldWaiting))
+	if err == sql.ErrNoRows {
+		return Hold{}, nil
+	}
+	return h, err
+}
+
+func (s *SQLStore) ListHolds(user_id int) ([]Hold, error) {
+	return s.queryHolds(
+		"user_id = ? AND status IN (?, ?) ORDER BY hold_id",
+		user_id, HoldWaiting, HoldReady)
+}
+
+func (s *SQLStore) CountHoldsBefore(book_id, hold_id int) (int, error) {
+	var count int
+	err := s.queryRow(`
+		SELECT COUNT(*)
+		FROM Hold
+		WHERE book_id = ? AND status = ? AND hold_id < ?`,
+		book_id, HoldWaiting, hold_id).
+		Scan(&count)
+	if err != nil {
+		return -1, err
+	}
+
+	return count, nil
+}
+
+func (s *SQLStore) ExpiredHolds(now time.Time) ([]Hold, error) {
+	return s.queryHolds(
+		"status = ? AND expires_at < ? ORDER BY hold_id",
+		HoldReady, now.UTC())
+}
+
+var selectFine = `
+SELECT
+	fine_id, user_id, record_id, kind, amount,
+	created_at, admin_id, note
+FROM Fine
+WHERE `
+
+func scanFine(row RowScanner) (Fine, error) {
+	var record_id, admin_id sql.NullInt64
+	var f Fine
+	err := row.Scan(
+		&f.FineID, &f.UserID, &record_id, &f.Kind, &f.Amount,
+		&f.CreatedAt, &admin_id, &f.Note,
+	)
+	if err != nil {
+		return Fine{}, err
+	}
+
+	f.RecordID = int(record_id.Int64)
+	f.AdminID = int(admin_id.Int64)
+	f.CreatedAt = f.CreatedAt.UTC()
+	return f, nil
+}
+
+// `nullID` maps zero IDs to NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+func (s *SQLStore) InsertFine(f Fine) (int, error) {
+	return s.insert(`
+		INSERT INTO Fine
+			(user_id, record_id, kind, amount, created_at, admin_id, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, "fine_id",
+		f.UserID, nullID(f.RecordID), f.Kind, f.Amount,
+		f.CreatedAt.UTC(), nullID(f.AdminID), f.Note)
+}
+
+func (s *SQLStore) ListFines(user_id int) ([]Fine, error) {
+	rows, err := s.query(selectFine+"user_id = ? ORDER BY fine_id", user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Fine{}
+	for rows.Next() {
+		f, err := scanFine(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, f)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) FineBalance(user_id int) (int, error) {
+	var balance int
+	err := s.queryRow(`
+		SELECT COALESCE(SUM(CASE WHEN kind = ? THEN amount ELSE -amount END), 0)
+		FROM Fine
+		WHERE user_id = ?`, FineCharge, user_id).
+		Scan(&balance)
+	if err != nil {
+		return -1, err
+	}
+
+	return balance, nil
+}
+
+var selectRecord = `
+SELECT
+	record_id, user_id, name, Record.book_id,
+	Record.item_id, COALESCE(barcode, ''),
+	borrow_branch_id, return_branch_id,
+	return_date, borrow_date,
+	deadline, final_deadline
+FROM Record
+	JOIN User USING (user_id)
+	LEFT JOIN Item ON Item.item_id = Record.item_id
+WHERE `
+
+func scanRecord(row RowScanner) (Record, error) {
+	var item_id, borrow_branch_id, return_branch_id sql.NullInt64
+	var return_date sql.NullTime
+	var r Record
+	err := row.Scan(
+		&r.RecordID, &r.UserID, &r.Username, &r.BookID,
+		&item_id, &r.Barcode,
+		&borrow_branch_id, &return_branch_id,
+		&return_date, &r.BorrowDate,
+		&r.DueDate, &r.FinalDate,
+	)
+	if err != nil {
+		return Record{}, err
+	}
+
+	r.ItemID = int(item_id.Int64)
+	r.BorrowBranchID = int(borrow_branch_id.Int64)
+	r.ReturnBranchID = int(return_branch_id.Int64)
+
+	// SQLite does not remember time zones
+	r.BorrowDate = r.BorrowDate.UTC()
+	r.DueDate = r.DueDate.UTC()
+	r.FinalDate = r.FinalDate.UTC()
+	if return_date.Valid {
+		r.Returned = true
+		r.ReturnDate = return_date.Time.UTC()
+	}
+
+	return r, nil
+}
+
+func (s *SQLStore) RecordByID(record_id int) (Record, error) {
+	row := s.queryRow(selectRecord+"record_id = ?", record_id)
+	r, err := scanRecord(row)
+	if err == sql.ErrNoRows {
+		return Record{}, ErrInvalidRecordID
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	return r, nil
+}
+
+func (s *SQLStore) InsertRecord(r Record) (int, error) {
+	var return_date sql.NullTime
+	if r.Returned {
+		return_date = sql.NullTime{Time: truncateDate(r.ReturnDate), Valid: true}
+	}
+
+	return s.insert(`
+		INSERT INTO Record
+			(user_id, book_id, item_id, borrow_branch_id, return_branch_id,
+			return_date, borrow_date, deadline, final_deadline)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, "record_id",
+		r.UserID, r.BookID, nullID(r.ItemID),
+		nullID(r.BorrowBranchID), nullID(r.ReturnBranchID),
+		return_date, truncateDate(r.BorrowDate),
+		truncateDate(r.DueDate), truncateDate(r.FinalDate))
+}
+
+func (s *SQLStore) SetDeadline(record_id int, due time.Time) error {
+	_, err := s.exec(`
+		UPDATE Record
+		SET deadline = ?
+		WHERE record_id = ?`, truncateDate(due), record_id)
+	return err
+}
+
+func (s *SQLStore) SetReturnDate(record_id int, date time.Time, branch_id int) error {
+	_, err := s.exec(
+		"UPDATE Record SET return_date = ?, return_branch_id = ? WHERE record_id = ?",
+		truncateDate(date), nullID(branch_id), record_id)
+	return err
+}
+
+func (s *SQLStore) CountOverdue(user_id int, now time.Time) (int, error) {
+	var overdue_count int
+	err := s.queryRow(`
+		SELECT COUNT(*)
+		FROM Record
+		WHERE
+			user_id = ? AND
+			return_date IS NULL AND
+			deadline < ?`,
+		user_id, ceilDate(now)).
+		Scan(&overdue_count)
+	if err != nil {
+		return -1, err
+	}
+
+	return overdue_count, nil
+}
+
+func (s *SQLStore) CountLoans(user_id int) (int, error) {
+	var loan_count int
+	err := s.queryRow(`
+		SELECT COUNT(*)
+		FROM Record
+		WHERE user_id = ? AND return_date IS NULL`, user_id).
+		Scan(&loan_count)
+	if err != nil {
+		return -1, err
+	}
+
+	return loan_count, nil
+}
+
+// `recordCondition` returns the condition of `filter` on records of
+// `user_id`.
+func recordCondition(user_id int, filter RecordFilter) (string, []interface{}) {
+	conds := []string{"Record.user_id = ?"}
+	args := []interface{}{user_id}
+	if filter.Returned {
+		conds = append(conds, "return_date IS NOT NULL")
+	}
+	if filter.NotReturned {
+		conds = append(conds, "return_date IS NULL")
+	}
+	if !filter.DueBefore.IsZero() {
+		conds = append(conds, "deadline < ?")
+		args = append(args, ceilDate(filter.DueBefore))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+var recordSortColumns = map[string]string{
+	"record_id":   "Record.record_id",
+	"borrow_date": "Record.borrow_date",
+	"deadline":    "Record.deadline",
+}
+
+func (s *SQLStore) ListRecords(user_id int, filter RecordFilter, p Page) ([]Record, error) {
+	if p.Sort == "" {
+		p.Sort, p.Desc = "borrow_date", true
+	}
+	where, args := recordCondition(user_id, filter)
+	order, err := pageClause(p, recordSortColumns, "record_id", &where, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(selectRecord+where+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Record{}
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) CountRecords(user_id int, filter RecordFilter) (int, error) {
+	where, args := recordCondition(user_id, filter)
+
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM Record WHERE "+where, args...).Scan(&count)
+	return count, err
+}
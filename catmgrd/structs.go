@@ -16,6 +16,14 @@ type MHello struct {
 	Message string `json:"message"`
 }
 
+// `Error` is set if `ImportResult.Errors` lists invalid rows.
+type MImport struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dry_run"`
+	ImportResult
+}
+
 type MNewBook struct {
 	Status string `json:"status"`
 	BookID int    `json:"book_id"`
@@ -26,14 +34,35 @@ type MAddUser struct {
 	UserID int    `json:"user_id"`
 }
 
+type MDelUser struct {
+	Status     string `json:"status"`
+	UserID     int    `json:"user_id"`
+	Anonymized bool   `json:"anonymized"`
+}
+
+type MUserType struct {
+	Status string `json:"status"`
+	TypeID int    `json:"type_id"`
+}
+
+type MUserTypeList struct {
+	Status  string     `json:"status"`
+	Results []UserType `json:"results"`
+}
+
+// `NextCursor` and `Total` of lists are from `PageInfo`.
 type MBookList struct {
-	Status  string `json:"status"`
-	Results []Book `json:"results"`
+	Status     string `json:"status"`
+	Results    []Book `json:"results"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int    `json:"total"`
 }
 
 type MRecordList struct {
-	Status  string   `json:"status"`
-	Results []Record `json:"results"`
+	Status     string   `json:"status"`
+	Results    []Record `json:"results"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Total      int      `json:"total"`
 }
 
 type MBook struct {
@@ -46,62 +75,283 @@ type MRecord struct {
 	RecordID int    `json:"record_id"`
 }
 
+// `Hold` is a place in the queue for a book. See hold.go. `ExpiresAt`
+// is the end of the pickup window and `ItemID` is the reserved copy,
+// both valid only for ready holds. `Position` is 1 for the first
+// waiting hold on a book.
+type Hold struct {
+	HoldID    int        `json:"hold_id"`
+	UserID    int        `json:"user_id"`
+	BookID    int        `json:"book_id"`
+	Status    HoldStatus `json:"status"`
+	ItemID    int        `json:"item_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Position  int        `json:"position"`
+}
+
+// `Item` is a physical copy of a book. See item.go. `BranchID` is the
+// home branch of the copy.
+type Item struct {
+	ItemID     int        `json:"item_id"`
+	BookID     int        `json:"book_id"`
+	BranchID   int        `json:"branch_id"`
+	Barcode    string     `json:"barcode"`
+	Status     ItemStatus `json:"status"`
+	AcquiredAt time.Time  `json:"acquired_at"`
+}
+
+type MItem struct {
+	Status  string `json:"status"`
+	ItemID  int    `json:"item_id"`
+	Barcode string `json:"barcode"`
+}
+
+type MItemList struct {
+	Status  string `json:"status"`
+	Results []Item `json:"results"`
+}
+
+// `Branch` is a library branch. See branch.go.
+type Branch struct {
+	BranchID int    `json:"branch_id"`
+	Name     string `json:"name"`
+}
+
+// `BranchCount` is the number of available copies of a book at a
+// branch.
+type BranchCount struct {
+	BranchID       int    `json:"branch_id"`
+	Name           string `json:"name"`
+	AvailableCount int    `json:"count"`
+}
+
+type MBranch struct {
+	Status   string `json:"status"`
+	BranchID int    `json:"branch_id"`
+}
+
+type MBranchList struct {
+	Status  string   `json:"status"`
+	Results []Branch `json:"results"`
+}
+
+type MHold struct {
+	Status string `json:"status"`
+	HoldID int    `json:"hold_id"`
+}
+
+type MHoldList struct {
+	Status  string `json:"status"`
+	Results []Hold `json:"results"`
+}
+
+// `Fine` is an entry of the fine ledger. See fine.go. `RecordID` and
+// `AdminID` are zero if not applicable.
+type Fine struct {
+	FineID    int       `json:"fine_id"`
+	UserID    int       `json:"user_id"`
+	RecordID  int       `json:"record_id"`
+	Kind      FineKind  `json:"kind"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+	AdminID   int       `json:"admin_id"`
+	Note      string    `json:"note"`
+}
+
+// `FineBalance` is what a user owes: `Posted` in the ledger, and
+// `Accruing` on overdue books not returned yet.
+type FineBalance struct {
+	Posted   int `json:"posted"`
+	Accruing int `json:"accruing"`
+	Balance  int `json:"balance"`
+}
+
+type MFine struct {
+	Status string `json:"status"`
+	FineID int    `json:"fine_id"`
+}
+
+type MFineBalance struct {
+	Status string `json:"status"`
+	UserID int    `json:"user_id"`
+	FineBalance
+	Entries []Fine `json:"entries"`
+}
+
 type RowScanner interface {
 	Scan(dest ...interface{}) error
 }
 
-type Permission struct {
-	Update  bool
-	AddUser bool
-	Borrow  bool
-	Inspect bool
+type User struct {
+	UserID int
+	TypeID int
+	Name   string
+	Token  string
+	Perm   PermissionSet
+	// Set if the password must be changed on next login.
+	MustChange bool
+	// Deactivated users cannot log in. Zero `ExpiresAt` means the
+	// account never expires.
+	Active    bool
+	ExpiresAt time.Time
+}
+
+type UserType struct {
+	TypeID   int           `json:"type_id"`
+	TypeName string        `json:"type_name"`
+	Perm     PermissionSet `json:"permissions"`
+}
+
+// `Policy` is the circulation policy of a user type. See policy.go.
+type Policy struct {
+	// Books are due `LoanDays` after borrowing, and must be returned
+	// within `FinalDays` whatever extensions are made.
+	LoanDays  int `json:"loan_days"`
+	FinalDays int `json:"final_days"`
+	// Deadlines can be extended by `ExtendDays` within
+	// `ExtendWindowDays` before them. Zero `ExtendDays` disables
+	// extension.
+	ExtendWindowDays int `json:"extend_window_days"`
+	ExtendDays       int `json:"extend_days"`
+	// Users with more than `MaxOverdue` overdue books cannot borrow.
+	MaxOverdue int `json:"max_overdue"`
+	// Zero `MaxLoans` means no limit on unreturned books.
+	MaxLoans int `json:"max_loans"`
+	// Copies reserved for holders are kept for `PickupDays`.
+	PickupDays int `json:"pickup_days"`
+	// Overdue books are fined `FineRate` per day, up to `FineCap` per
+	// loan, and users owing more than `MaxBalance` cannot borrow. Zero
+	// disables each of them. See fine.go.
+	FineRate   int `json:"fine_rate"`
+	FineCap    int `json:"fine_cap"`
+	MaxBalance int `json:"max_balance"`
+}
+
+// `PolicyOverride` overrides loan periods of `Policy` for a book. Nil
+// fields are not overridden.
+type PolicyOverride struct {
+	LoanDays         *int `json:"loan_days,omitempty"`
+	FinalDays        *int `json:"final_days,omitempty"`
+	ExtendWindowDays *int `json:"extend_window_days,omitempty"`
+	ExtendDays       *int `json:"extend_days,omitempty"`
+}
+
+type MPolicy struct {
+	Status string `json:"status"`
+	TypeID int    `json:"type_id"`
+	Policy Policy `json:"policy"`
+}
+
+type MBookPolicy struct {
+	Status   string         `json:"status"`
+	BookID   int            `json:"book_id"`
+	Override PolicyOverride `json:"override"`
+}
+
+// `Session` is a login session. See session.go.
+type Session struct {
+	SessionID int
+	UserID    int
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Revoked   bool
 }
 
-func (e Permission) mask() int {
-	var ret int
-	if e.Update {
-		ret |= 1
-	}
-	if e.AddUser {
-		ret |= 2
-	}
-	if e.Borrow {
-		ret |= 4
-	}
-	if e.Inspect {
-		ret |= 8
-	}
-	return ret
+type MLogin struct {
+	Status    string    `json:"status"`
+	UserID    int       `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// `APIKey` is an API key, without the key itself. See apikey.go.
+// `LastUsed` is valid only if `Used` is set.
+type APIKey struct {
+	KeyID     int           `json:"key_id"`
+	UserID    int           `json:"user_id"`
+	Name      string        `json:"name"`
+	KeyHash   string        `json:"-"`
+	Scope     PermissionSet `json:"scope"`
+	CreatedAt time.Time     `json:"created_at"`
+	Used      bool          `json:"used"`
+	LastUsed  time.Time     `json:"last_used"`
+	Revoked   bool          `json:"revoked"`
+}
+
+type MNewAPIKey struct {
+	Status string `json:"status"`
+	KeyID  int    `json:"key_id"`
+	Key    string `json:"key"`
 }
 
+type MAPIKey struct {
+	Status string `json:"status"`
+	KeyID  int    `json:"key_id"`
+}
+
+type MAPIKeyList struct {
+	Status  string   `json:"status"`
+	Results []APIKey `json:"results"`
+}
+
+// `AuthFailure` counts failed password attempts of `Subject`, which
+// is "user:<user_id>" or "addr:<address>". See lockout.go.
+// Zero `LockedUntil` means not locked.
+type AuthFailure struct {
+	Subject     string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// `AvailableCount` of a book is the number of its available items, and
+// `Branches` breaks it down by branch. `Branches` is filled by
+// `CheckoutBook` and the like only.
+// `ISBN` is as entered, and `ISBN13` is what it is stored as, empty
+// for invalid ISBNs left by migration 0014 (see isbn.go).
 type Book struct {
-	BookID         int    `json:"book_id"`
-	Title          string `json:"title"`
-	Author         string `json:"author"`
-	ISBN           string `json:"isbn"`
-	AvailableCount int    `json:"count"`
-	Description    string `json:"description"`
-	Comment        string `json:"comment"`
+	BookID         int           `json:"book_id"`
+	Title          string        `json:"title"`
+	Author         string        `json:"author"`
+	ISBN           string        `json:"isbn"`
+	ISBN13         string        `json:"isbn13,omitempty"`
+	AvailableCount int           `json:"count"`
+	Description    string        `json:"description"`
+	Comment        string        `json:"comment"`
+	Branches       []BranchCount `json:"branches,omitempty"`
 }
 
 // `BookInfo` is used by `UpdateBook`.
 // nil values indicate that corresponding fields will not be updated.
+// `ISBNDisplay` is set by `UpdateBook` with `ISBN`, and stores take
+// `ISBN` for it if nil. An empty `ISBN` removes both.
 type BookInfo struct {
 	Title       *string
 	Author      *string
 	ISBN        *string
+	ISBNDisplay *string
 	Description *string
 	Comment     *string
 }
 
+// `ItemID` and `Barcode` are the borrowed copy. They are zero for
+// records inserted without one. `BorrowBranchID` and `ReturnBranchID`
+// are where the book was borrowed and returned, zero if unknown or not
+// returned yet.
 type Record struct {
-	RecordID   int       `json:"record_id"`
-	UserID     int       `json:"user_id"`
-	Username   string    `json:"username"`
-	BookID     int       `json:"book_id"`
-	Returned   bool      `json:"returned"`
-	ReturnDate time.Time `json:"return_date"`
-	BorrowDate time.Time `json:"borrow_date"`
-	DueDate    time.Time `json:"deadline"`
-	FinalDate  time.Time `json:"final_deadline"`
+	RecordID       int       `json:"record_id"`
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	BookID         int       `json:"book_id"`
+	ItemID         int       `json:"item_id"`
+	Barcode        string    `json:"barcode"`
+	BorrowBranchID int       `json:"borrow_branch_id"`
+	ReturnBranchID int       `json:"return_branch_id"`
+	Returned       bool      `json:"returned"`
+	ReturnDate     time.Time `json:"return_date"`
+	BorrowDate     time.Time `json:"borrow_date"`
+	DueDate        time.Time `json:"deadline"`
+	FinalDate      time.Time `json:"final_deadline"`
 }
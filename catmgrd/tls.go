@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// `CertReloader` serves a certificate loaded from `CertFile` and
+// `KeyFile`, and reloads them on demand. Handshakes in progress keep
+// the certificate they started with, so no connection is dropped.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// `NewCertReloader` loads the certificate for the first time.
+func NewCertReloader(cert_file string, key_file string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: cert_file, KeyFile: key_file}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// `Reload` reads the certificate files again. The current certificate
+// is kept if they are invalid.
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// `GetCertificate` is used as `tls.Config.GetCertificate`.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// `ReloadOnSignal` reloads the certificate whenever the process
+// receives SIGHUP.
+func (r *CertReloader) ReloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			err := r.Reload()
+			if err != nil {
+				log.Println("failed to reload TLS certificate:", err)
+			} else {
+				log.Println("TLS certificate reloaded")
+			}
+		}
+	}()
+}
+
+// `SelfSignedCertificate` generates a throwaway certificate for
+// "localhost", 127.0.0.1 and ::1, valid for `lifetime`. Returns the
+// certificate along with its PEM encoding (without the private key).
+// It is intended for development only.
+func SelfSignedCertificate(lifetime time.Duration) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"catmgrd self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	cert_pem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return cert, cert_pem, nil
+}
+
+// `ConfigureTLS` enables HTTPS on `server` with a self-signed
+// certificate if `self_signed` is set, or with the certificate in
+// `cert_file` and `key_file`, which is reloaded on SIGHUP. Returns
+// false if neither is given, i.e. the server should use plain HTTP.
+func ConfigureTLS(server *http.Server, cert_file string, key_file string, self_signed bool) (bool, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case self_signed:
+		cert, cert_pem, err := SelfSignedCertificate(7 * day)
+		if err != nil {
+			return false, err
+		}
+
+		// Clients may trust the certificate with this file
+		path := filepath.Join(os.TempDir(), "catmgrd-self-signed.pem")
+		err = ioutil.WriteFile(path, cert_pem, 0644)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("self-signed certificate written to %s", path)
+		log.Printf("SHA-256 fingerprint: %s", fingerprint(cert.Certificate[0]))
+
+		config.Certificates = []tls.Certificate{cert}
+	case cert_file != "" && key_file != "":
+		reloader, err := NewCertReloader(cert_file, key_file)
+		if err != nil {
+			return false, err
+		}
+		reloader.ReloadOnSignal()
+
+		config.GetCertificate = reloader.GetCertificate
+	case cert_file != "" || key_file != "":
+		return false, fmt.Errorf("both TLS certificate and key are required")
+	default:
+		return false, nil
+	}
+
+	server.TLSConfig = config
+	return true, nil
+}
+
+// `fingerprint` is the SHA-256 fingerprint of DER certificate `der`.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	var s string
+	for i, b := range sum {
+		if i > 0 {
+			s += ":"
+		}
+		s += fmt.Sprintf("%02X", b)
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// `writeSelfSigned` writes a new self-signed certificate and its key
+// to `cert_file` and `key_file`.
+func writeSelfSigned(t *testing.T, cert_file string, key_file string) []byte {
+	cert, cert_pem, err := SelfSignedCertificate(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_pem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	err = ioutil.WriteFile(cert_file, cert_pem, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(key_file, key_pem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catmgrd-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert_file := filepath.Join(dir, "cert.pem")
+	key_file := filepath.Join(dir, "key.pem")
+	first := writeSelfSigned(t, cert_file, key_file)
+
+	r, err := NewCertReloader(cert_file, key_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if !bytes.Equal(cert.Certificate[0], first) {
+		t.Errorf("unexpected certificate")
+	}
+
+	second := writeSelfSigned(t, cert_file, key_file)
+	err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = r.GetCertificate(nil)
+	if !bytes.Equal(cert.Certificate[0], second) {
+		t.Errorf("certificate is not reloaded")
+	}
+
+	err = ioutil.WriteFile(cert_file, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Reload()
+	if err == nil {
+		t.Errorf("expected error for invalid certificate")
+	}
+	cert, _ = r.GetCertificate(nil)
+	if !bytes.Equal(cert.Certificate[0], second) {
+		t.Errorf("certificate should be kept after failed reload")
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, cert_pem, err := SelfSignedCertificate(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parsed.VerifyHostname("localhost")
+	if err != nil {
+		t.Error(err)
+	}
+	err = parsed.VerifyHostname("127.0.0.1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(cert_pem) {
+		t.Fatal("invalid PEM")
+	}
+	_, err = parsed.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool})
+	if err != nil {
+		t.Error(err)
+	}
+}
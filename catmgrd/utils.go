@@ -3,52 +3,81 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
-type MySQLConfig struct {
+// `DatabaseConfig` is loaded from "catmgrd.json". `Driver` selects
+// the database: "mysql" (default), "sqlite" or "postgres". For SQLite,
+// only `File` is used, which is the path to the database file.
+// `SSLMode` is the "sslmode" parameter of PostgreSQL, "disable" by
+// default. `Protocol` is used by MySQL only.
+type DatabaseConfig struct {
+	Driver   string
+	File     string
 	Username string
 	Password string
 	Protocol string
 	Address  string
 	Port     int
 	Database string
+	SSLMode  string
 }
 
-func LoadMySQLConfig(path string) (MySQLConfig, error) {
+// `Config` is the content of "catmgrd.json": database settings and
+// server options. `LegacyPasswordCutoff` is a date ("2006-01-02") after
+// which legacy SHA-1 password tokens are rejected; empty means never.
+// `SessionLifetime` is a duration like "12h", 24 hours by default.
+// `TLSCert` and `TLSKey` are used unless "-tls-cert" and "-tls-key"
+// flags are given. `LockoutThreshold` overrides the default of
+// `LockoutThreshold` in lockout.go if positive.
+type Config struct {
+	DatabaseConfig
+	LegacyPasswordCutoff string `json:"legacy_password_cutoff"`
+	SessionLifetime      string `json:"session_lifetime"`
+	TLSCert              string `json:"tls_cert"`
+	TLSKey               string `json:"tls_key"`
+	LockoutThreshold     int    `json:"lockout_threshold"`
+}
+
+func LoadConfig(path string) (Config, error) {
 	fp, err := os.Open(path)
 	if err != nil {
-		return MySQLConfig{}, err
+		return Config{}, err
 	}
+	defer fp.Close()
 
-	var config MySQLConfig
+	var config Config
 	err = json.NewDecoder(fp).Decode(&config)
 	if err != nil {
-		return MySQLConfig{}, err
+		return Config{}, err
 	}
 
 	return config, nil
 }
 
-func ConnectMySQL(config MySQLConfig) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@%s(%s:%d)/%s?parseTime=true",
-		config.Username, config.Password,
-		config.Protocol, config.Address,
-		config.Port, config.Database)
-	db, err := sql.Open("mysql", dsn)
+// `ConnectDatabase` connects to the database described by `config`
+// and returns its dialect along with the connection.
+func ConnectDatabase(config DatabaseConfig) (*sql.DB, Dialect, error) {
+	dialect, err := GetDialect(config.Driver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open(dialect.Name(), dialect.DSN(config))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	err = db.Ping()
 	if err != nil {
-		return nil, err
+		db.Close()
+		return nil, nil, err
 	}
 
-	return db, nil
+	return db, dialect, nil
 }
 
 func SendJSON(resp http.ResponseWriter, v interface{}) {
@@ -78,29 +107,57 @@ func DecodePayload(resp http.ResponseWriter, req *http.Request, v interface{}) b
 	return true
 }
 
-func AuthRequest(resp http.ResponseWriter, req *http.Request, user interface{}, password string, perm Permission) bool {
-	err := AuthUser(db, user, password, perm)
-	if err == ErrInvalidUser || err == ErrInvalidPassword || err == ErrPermissionDenied {
+// `bearerToken` extracts the token from "Authorization: Bearer" header.
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
+
+// `AuthRequest` authenticates `req` with the session token or API key
+// in its "Authorization: Bearer" header, or with `user` and `password`
+// if there is no such header. The user must have all permissions in
+// `perms`. Returns the authenticated user.
+func AuthRequest(resp http.ResponseWriter, req *http.Request, user interface{}, password string, perms ...Permission) (User, bool) {
+	perm := NewPermissionSet(perms...)
+	var u User
+	var err error
+	if token, ok := bearerToken(req); ok && isAPIKey(token) {
+		u, err = AuthAPIKey(db, token, perm)
+	} else if ok {
+		u, err = AuthSession(db, token, perm)
+	} else {
+		u, err = AuthUserFrom(db, req.RemoteAddr, user, password, perm)
+	}
+	if err == ErrInvalidUser || err == ErrInvalidPassword ||
+		err == ErrLegacyPassword || err == ErrInvalidSession ||
+		err == ErrInvalidAPIKey || err == ErrAccountLocked ||
+		err == ErrInactiveUser || err == ErrExpiredUser ||
+		err == ErrMustChangePassword || err == ErrPermissionDenied {
 		log.Println(req.RemoteAddr, "AuthRequest", err)
 		SendJSON(resp, MError{"failed", err.Error()})
-		return false
+		return User{}, false
 	}
 	if err != nil {
 		log.Println(req.RemoteAddr, "AuthRequest", err)
 		SendJSON(resp, MErrAuthUser)
-		return false
+		return User{}, false
 	}
-	return true
+	return u, true
 }
 
-func CheckRecordID(resp http.ResponseWriter, req *http.Request, record_id int, user interface{}) bool {
-	user_id, err := ObtainUserID(user)
-	if err != nil {
-		log.Println(req.RemoteAddr, "CheckRecordID", err)
-		SendJSON(resp, NewMError("an error occurred during retrieving user"))
-		return false
-	}
+// `logEscalation` records an attempt of `u` to grant permissions beyond
+// its own (see `CheckGrant`).
+func logEscalation(req *http.Request, u User, action string) {
+	log.Printf("%s privilege escalation attempt by user %d: %s", req.RemoteAddr, u.UserID, action)
+}
 
+// `CheckRecordID` checks that record `record_id` belongs to `u`, unless
+// `u` has permission `others` to act on records of other users.
+func CheckRecordID(resp http.ResponseWriter, req *http.Request, record_id int, u User, others Permission) bool {
 	r, err := CheckoutRecord(db, record_id)
 	if err == ErrInvalidRecordID {
 		log.Println(req.RemoteAddr, "CheckRecordID", err)
@@ -112,7 +169,7 @@ func CheckRecordID(resp http.ResponseWriter, req *http.Request, record_id int, u
 		SendJSON(resp, NewMError("an error occurred during examining record"))
 		return false
 	}
-	if r.UserID != user_id {
+	if r.UserID != u.UserID && !u.Perm.Has(others) {
 		SendJSON(resp, NewMError("your user ID does not match that of the record"))
 		return false
 	}
@@ -123,6 +180,8 @@ func ObtainUserID(v interface{}) (int, error) {
 	switch t := v.(type) {
 	case int:
 		return t, nil
+	case float64:
+		return int(t), nil
 	case string:
 		return GetUserID(db, t)
 	default:
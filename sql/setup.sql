@@ -1,4 +1 @@
-CREATE DATABASE IF NOT EXISTS library;
-USE library;
-
-SOURCE sql/create_tables.sql;
\ No newline at end of file
+CREATE DATABASE IF NOT EXISTS library;
\ No newline at end of file
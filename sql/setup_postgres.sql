@@ -0,0 +1,2 @@
+-- Run with `psql -f sql/setup_postgres.sql`.
+CREATE DATABASE library;
@@ -1,6 +1,2 @@
 DROP DATABASE IF EXISTS library_test;
-CREATE DATABASE library_test;
-USE library_test;
-
-SOURCE sql/create_tables.sql;
-SOURCE sql/samples.sql;
\ No newline at end of file
+CREATE DATABASE library_test;
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- Run with `psql -f sql/setup_test_postgres.sql`.
+DROP DATABASE IF EXISTS library_test;
+CREATE DATABASE library_test;